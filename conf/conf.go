@@ -3,6 +3,16 @@ package conf
 import (
 	"errors"
 	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/thanhqng1510/dbdb/store"
 )
 
 // Config holds the node configuration.
@@ -16,25 +26,154 @@ type Config struct {
 	// Port for HTTP API
 	HttpPort  string
 
+	// Directory holding the Raft data of the node, under <id>-raft
+	DataDir string
+
+	// Addresses Raft and the HTTP API listen on, and the addresses other
+	// nodes reach them at. They default to the ports on every interface and
+	// on the hostname.
+	RaftBind      string
+	RaftAdvertise string
+	HttpBind      string
+	HttpAdvertise string
+
+	// Port for the Redis-compatible (RESP) API, disabled if empty
+	RedisPort string
+
+	// Port for the memcached-compatible API, disabled if empty
+	MemcachedPort string
+
 	// Address of an existing node within a cluster to join (e.g., "localhost:8221")
 	JoinAddr  string
 
 	// If true, bootstrap a new cluster (should only be true for the first node),
 	// This cannot be used with JoinAddr
 	Bootstrap bool
+
+	// Maximum sizes in bytes of keys and values accepted in writes, 0 for no limit
+	MaxKeySize   int
+	MaxValueSize int
+
+	// Token required to manage namespaces, which also grants access to every
+	// namespace. Namespaces can be managed without a token if empty
+	AdminToken string
+
+	// Requests per second and bursts allowed to each client and each
+	// namespace on the HTTP API, 0 for no limit
+	ClientRate     float64
+	ClientBurst    int
+	NamespaceRate  float64
+	NamespaceBurst int
+
+	// Maximum number of concurrent writes the leader coalesces into one Raft
+	// entry, 1 to disable batching, and how long it waits for more writes
+	MaxBatchSize int
+	BatchLinger  time.Duration
+
+	// Tuning of Raft, defaulting to the defaults of hashicorp/raft
+	Raft store.RaftConfig
+
+	// Configuration file the configuration was read from, if any
+	File string
+
+	// If true, print the configuration and exit
+	PrintConfig bool
 }
 
-// GetConfig parses command-line arguments and returns the configuration.
-func GetConfig(args []string) (Config, error) {
-	var cfg Config
-	fs := flag.NewFlagSet("dbdb", flag.ExitOnError)
+// newFlagSet returns the flags setting the fields of a configuration, set to
+// their defaults.
+func newFlagSet(cfg *Config) *flag.FlagSet {
+	fs := flag.NewFlagSet("dbdb", flag.ContinueOnError)
+	fs.StringVar(&cfg.File, "config", "", "Configuration file in YAML, TOML or JSON format (optional)")
+	fs.BoolVar(&cfg.PrintConfig, "print-config", false, "Print the configuration and exit")
 	fs.StringVar(&cfg.Id, "node-id", "", "Node ID (required)")
-	fs.StringVar(&cfg.RaftPort, "raft-port", "", "Raft communication port (required)")
-	fs.StringVar(&cfg.HttpPort, "http-port", "", "HTTP API port (required)")
+	fs.StringVar(&cfg.RaftPort, "raft-port", "", "Raft communication port (required unless --raft-bind and --raft-advertise are set)")
+	fs.StringVar(&cfg.HttpPort, "http-port", "", "HTTP API port (required unless --http-bind and --http-advertise are set)")
+	fs.StringVar(&cfg.DataDir, "data-dir", "data", "Directory holding the Raft data")
+	fs.StringVar(&cfg.RaftBind, "raft-bind", "", "Address Raft listens on (default 0.0.0.0:<raft-port>)")
+	fs.StringVar(&cfg.RaftAdvertise, "raft-advertise", "", "Address other nodes reach Raft at (default <hostname>:<raft-port>)")
+	fs.StringVar(&cfg.HttpBind, "http-bind", "", "Address the HTTP API listens on (default :<http-port>)")
+	fs.StringVar(&cfg.HttpAdvertise, "http-advertise", "", "Address other nodes direct clients to for the HTTP API (default <hostname>:<http-port>)")
+	fs.StringVar(&cfg.RedisPort, "redis-port", "", "Redis protocol (RESP) API port (optional)")
+	fs.StringVar(&cfg.MemcachedPort, "memcached-port", "", "Memcached text protocol API port (optional)")
 	fs.StringVar(&cfg.JoinAddr, "join", "", "Address of a leader node to join (HTTP API address)")
 	fs.BoolVar(&cfg.Bootstrap, "bootstrap", false, "Bootstrap as the first node in a new cluster")
+	fs.IntVar(&cfg.MaxKeySize, "max-key-size", 4096, "Maximum key size in bytes, 0 for no limit")
+	fs.IntVar(&cfg.MaxValueSize, "max-value-size", 1024*1024, "Maximum value size in bytes, 0 for no limit")
 
-	fs.Parse(args)
+	fs.StringVar(&cfg.AdminToken, "admin-token", "", "Token required to manage namespaces (optional)")
+	fs.Float64Var(&cfg.ClientRate, "client-rate", 0, "Requests per second allowed to each client, 0 for no limit")
+	fs.IntVar(&cfg.ClientBurst, "client-burst", 0, "Burst of requests allowed to each client, 0 for one second of requests")
+	fs.Float64Var(&cfg.NamespaceRate, "namespace-rate", 0, "Requests per second allowed to each namespace, 0 for no limit")
+	fs.IntVar(&cfg.NamespaceBurst, "namespace-burst", 0, "Burst of requests allowed to each namespace, 0 for one second of requests")
+	fs.IntVar(&cfg.MaxBatchSize, "max-batch-size", 64, "Maximum number of writes batched into one Raft entry, 1 to disable batching")
+	fs.DurationVar(&cfg.BatchLinger, "batch-linger", 0, "Time the leader waits for more writes before sending a batch")
+
+	d := store.DefaultRaftConfig()
+	fs.DurationVar(&cfg.Raft.HeartbeatTimeout, "heartbeat-timeout", d.HeartbeatTimeout, "Time without contact from the leader before a follower starts an election")
+	fs.DurationVar(&cfg.Raft.ElectionTimeout, "election-timeout", d.ElectionTimeout, "Time without a leader before a candidate starts another election")
+	fs.DurationVar(&cfg.Raft.LeaderLeaseTimeout, "leader-lease-timeout", d.LeaderLeaseTimeout, "Time without contact from a quorum before the leader steps down")
+	fs.DurationVar(&cfg.Raft.SnapshotInterval, "snapshot-interval", d.SnapshotInterval, "How often to check whether to take a snapshot")
+	fs.Uint64Var(&cfg.Raft.SnapshotThreshold, "snapshot-threshold", d.SnapshotThreshold, "Number of log entries since the last snapshot that triggers a snapshot")
+	fs.Uint64Var(&cfg.Raft.TrailingLogs, "trailing-logs", d.TrailingLogs, "Number of log entries kept after a snapshot")
+	fs.IntVar(&cfg.Raft.MaxAppendEntries, "max-append-entries", d.MaxAppendEntries, "Maximum number of log entries sent to a follower in one request")
+	fs.IntVar(&cfg.Raft.PoolSize, "raft-pool-size", d.PoolSize, "Number of connections pooled to each node")
+	fs.DurationVar(&cfg.Raft.TransportTimeout, "raft-timeout", d.TransportTimeout, "Timeout of I/O between nodes")
+	fs.IntVar(&cfg.Raft.SnapshotsRetained, "snapshots-retained", d.SnapshotsRetained, "Number of snapshots kept on disk")
+	fs.DurationVar(&cfg.Raft.ApplyTimeout, "apply-timeout", d.ApplyTimeout, "Time to wait for Raft to accept a write before failing with a timeout")
+	fs.StringVar(&cfg.Raft.LogLevel, "log-level", d.LogLevel, "Level of the logs of Raft: trace, debug, info, warn or error")
+	return fs
+}
+
+// GetConfig returns the configuration from command-line arguments, DBDB_*
+// environment variables and the configuration file, in that order of
+// precedence. Settings are named after their flags in the configuration file,
+// and in upper case with underscores after the DBDB_ prefix in environment
+// variables, such as DBDB_NODE_ID. The configuration file is set by --config
+// or DBDB_CONFIG.
+func GetConfig(args []string) (Config, error) {
+	var cfg Config
+	fs := newFlagSet(&cfg)
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	// Each setting is only taken from the first source that sets it
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		value, ok := os.LookupEnv(envName(f.Name))
+		if !ok || set[f.Name] || err != nil {
+			return
+		}
+		if e := fs.Set(f.Name, value); e != nil {
+			err = fmt.Errorf("error: invalid value %q for %s: %w", value, envName(f.Name), e)
+		}
+		set[f.Name] = true
+	})
+	if err != nil {
+		return Config{}, err
+	}
+
+	if cfg.File != "" {
+		settings, err := readFile(cfg.File)
+		if err != nil {
+			return Config{}, fmt.Errorf("error: could not read configuration file: %w", err)
+		}
+		for _, name := range slices.Sorted(maps.Keys(settings)) {
+			if fs.Lookup(name) == nil || name == "config" {
+				return Config{}, fmt.Errorf("error: unknown setting %q in %s", name, cfg.File)
+			}
+			if set[name] {
+				continue
+			}
+			if err := fs.Set(name, settings[name]); err != nil {
+				return Config{}, fmt.Errorf("error: invalid value %q for %s in %s: %w", settings[name], name, cfg.File, err)
+			}
+		}
+	}
 
 	if cfg.Bootstrap && cfg.JoinAddr != "" {
 		fs.Usage() // Print usage information
@@ -46,14 +185,116 @@ func GetConfig(args []string) (Config, error) {
 		fs.Usage()
 		return Config{}, errors.New("error: --node-id is required")
 	}
-	if cfg.RaftPort == "" {
+	if cfg.RaftPort == "" && (cfg.RaftBind == "" || cfg.RaftAdvertise == "") {
 		fs.Usage()
 		return Config{}, errors.New("error: --raft-port is required")
 	}
-	if cfg.HttpPort == "" {
-		flag.Usage()
+	if cfg.HttpPort == "" && (cfg.HttpBind == "" || cfg.HttpAdvertise == "") {
+		fs.Usage()
 		return Config{}, errors.New("error: --http-port is required")
 	}
+	if cfg.DataDir == "" {
+		fs.Usage()
+		return Config{}, errors.New("error: --data-dir must not be empty")
+	}
+	if err := cfg.resolveAddrs(); err != nil {
+		fs.Usage()
+		return Config{}, err
+	}
+
+	if cfg.MaxKeySize < 0 || cfg.MaxValueSize < 0 {
+		fs.Usage()
+		return Config{}, errors.New("error: --max-key-size and --max-value-size must not be negative")
+	}
+	if cfg.ClientRate < 0 || cfg.ClientBurst < 0 || cfg.NamespaceRate < 0 || cfg.NamespaceBurst < 0 {
+		fs.Usage()
+		return Config{}, errors.New("error: rate limits must not be negative")
+	}
+	if cfg.MaxBatchSize < 1 || cfg.BatchLinger < 0 {
+		fs.Usage()
+		return Config{}, errors.New("error: --max-batch-size must be at least 1 and --batch-linger must not be negative")
+	}
+	if err := cfg.Raft.Validate(); err != nil {
+		fs.Usage()
+		return Config{}, fmt.Errorf("error: invalid raft configuration: %w", err)
+	}
 	
 	return cfg, nil
-}
\ No newline at end of file
+}
+
+// resolveAddrs fills in the default bind and advertise addresses and checks
+// that the advertise addresses can be reached by other nodes.
+func (cfg *Config) resolveAddrs() error {
+	var hostname string
+	if cfg.RaftAdvertise == "" || cfg.HttpAdvertise == "" {
+		var err error
+		if hostname, err = os.Hostname(); err != nil {
+			return fmt.Errorf("error: could not get hostname for the advertise addresses: %w", err)
+		}
+		if hostname == "" {
+			return errors.New("error: hostname is empty, set --raft-advertise and --http-advertise")
+		}
+	}
+
+	if cfg.RaftBind == "" {
+		cfg.RaftBind = net.JoinHostPort("0.0.0.0", cfg.RaftPort)
+	}
+	if cfg.RaftAdvertise == "" {
+		cfg.RaftAdvertise = net.JoinHostPort(hostname, cfg.RaftPort)
+	}
+	if cfg.HttpBind == "" {
+		cfg.HttpBind = net.JoinHostPort("", cfg.HttpPort)
+	}
+	if cfg.HttpAdvertise == "" {
+		cfg.HttpAdvertise = net.JoinHostPort(hostname, cfg.HttpPort)
+	}
+
+	for _, a := range []struct{ name, addr string }{
+		{"raft-bind", cfg.RaftBind},
+		{"raft-advertise", cfg.RaftAdvertise},
+		{"http-bind", cfg.HttpBind},
+		{"http-advertise", cfg.HttpAdvertise},
+	} {
+		host, port, err := net.SplitHostPort(a.addr)
+		if err != nil || port == "" {
+			return fmt.Errorf("error: invalid --%s %q, expected host:port", a.name, a.addr)
+		}
+		if strings.HasSuffix(a.name, "-advertise") {
+			if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+				return fmt.Errorf("error: --%s %q must have a host other nodes can reach", a.name, a.addr)
+			}
+		}
+	}
+	return nil
+}
+
+// envName returns the name of the environment variable of a setting.
+func envName(name string) string {
+	return "DBDB_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// Print writes a configuration in YAML, with the settings named as in
+// configuration files. The admin token is redacted.
+func Print(w io.Writer, cfg Config) error {
+	var printed Config
+	fs := newFlagSet(&printed)
+	printed = cfg
+	if printed.AdminToken != "" {
+		printed.AdminToken = "REDACTED"
+	}
+
+	settings := make(map[string]any)
+	fs.VisitAll(func(f *flag.Flag) {
+		switch f.Name {
+		case "config", "print-config":
+			return
+		}
+
+		v := f.Value.(flag.Getter).Get()
+		if d, ok := v.(time.Duration); ok {
+			v = d.String()
+		}
+		settings[f.Name] = v
+	})
+	return writeYAML(w, settings)
+}
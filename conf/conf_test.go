@@ -1,7 +1,12 @@
 package conf
 
 import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestGetConfig_ValidArgs(t *testing.T) {
@@ -26,6 +31,74 @@ func TestGetConfig_ValidArgs(t *testing.T) {
 	if cfg.JoinAddr != "" {
 		t.Errorf("expected JoinAddr '', got '%s'", cfg.JoinAddr)
 	}
+	if cfg.MaxKeySize != 4096 || cfg.MaxValueSize != 1024*1024 {
+		t.Errorf("expected default size limits, got %d and %d", cfg.MaxKeySize, cfg.MaxValueSize)
+	}
+}
+
+func TestGetConfig_NegativeSizeLimit(t *testing.T) {
+	args := []string{
+		"--node-id", "node1",
+		"--raft-port", "9000",
+		"--http-port", "8000",
+		"--max-value-size", "-1",
+	}
+	if _, err := GetConfig(args); err == nil {
+		t.Fatalf("expected error for negative size limit, got nil")
+	}
+}
+
+func TestGetConfig_NegativeRateLimit(t *testing.T) {
+	args := []string{
+		"--node-id", "node1",
+		"--raft-port", "9000",
+		"--http-port", "8000",
+		"--client-rate", "-1",
+	}
+	if _, err := GetConfig(args); err == nil {
+		t.Fatalf("expected error for negative rate limit, got nil")
+	}
+}
+
+func TestGetConfig_InvalidBatching(t *testing.T) {
+	args := []string{
+		"--node-id", "node1",
+		"--raft-port", "9000",
+		"--http-port", "8000",
+		"--max-batch-size", "0",
+	}
+	if _, err := GetConfig(args); err == nil {
+		t.Fatalf("expected error for batch size of 0, got nil")
+	}
+}
+
+func TestGetConfig_Raft(t *testing.T) {
+	args := []string{
+		"--node-id", "node1",
+		"--raft-port", "9000",
+		"--http-port", "8000",
+		"--heartbeat-timeout", "200ms",
+		"--election-timeout", "400ms",
+		"--leader-lease-timeout", "100ms",
+		"--snapshots-retained", "5",
+	}
+	cfg, err := GetConfig(args)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if cfg.Raft.HeartbeatTimeout != 200*time.Millisecond || cfg.Raft.ElectionTimeout != 400*time.Millisecond ||
+		cfg.Raft.LeaderLeaseTimeout != 100*time.Millisecond || cfg.Raft.SnapshotsRetained != 5 {
+		t.Errorf("unexpected raft configuration %+v", cfg.Raft)
+	}
+	if cfg.Raft.ApplyTimeout != 5*time.Second || cfg.Raft.PoolSize != 10 {
+		t.Errorf("expected defaults for other settings, got %+v", cfg.Raft)
+	}
+
+	// The leader lease must not be longer than the heartbeat timeout
+	args = append(args, "--leader-lease-timeout", "300ms")
+	if _, err := GetConfig(args); err == nil {
+		t.Fatalf("expected error for invalid raft configuration, got nil")
+	}
 }
 
 func TestGetConfig_BootstrapAndJoinConflict(t *testing.T) {
@@ -86,3 +159,175 @@ func TestGetConfig_MissingRequired(t *testing.T) {
 		})
 	}
 }
+
+// writeFile writes a configuration file in a temporary directory.
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("could not write %s: %v", path, err)
+	}
+	return path
+}
+
+func TestGetConfig_File(t *testing.T) {
+	files := map[string]string{
+		"dbdb.yaml": "node-id: node1\nraft-port: 9000\nhttp-port: \"8000\"\nbootstrap: true\nmax-value-size: 2048\nheartbeat-timeout: 2s\nelection-timeout: 2s\n",
+		"dbdb.toml": "node-id = \"node1\"\nraft-port = 9000\nhttp-port = \"8000\"\nbootstrap = true\nmax-value-size = 2048\nheartbeat-timeout = \"2s\"\nelection-timeout = \"2s\"\n",
+		"dbdb.json": `{"node-id": "node1", "raft-port": 9000, "http-port": "8000", "bootstrap": true, "max-value-size": 2048, "heartbeat-timeout": "2s", "election-timeout": "2s"}`,
+	}
+	for name, content := range files {
+		cfg, err := GetConfig([]string{"--config", writeFile(t, name, content)})
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", name, err)
+			continue
+		}
+		if cfg.Id != "node1" || cfg.RaftPort != "9000" || cfg.HttpPort != "8000" || !cfg.Bootstrap ||
+			cfg.MaxValueSize != 2048 || cfg.Raft.HeartbeatTimeout != 2*time.Second {
+			t.Errorf("%s: unexpected configuration %+v", name, cfg)
+		}
+	}
+}
+
+func TestGetConfig_Precedence(t *testing.T) {
+	path := writeFile(t, "dbdb.yaml", "node-id: file\nraft-port: 9000\nhttp-port: 8000\nredis-port: 6379\n")
+	t.Setenv("DBDB_CONFIG", path)
+	t.Setenv("DBDB_NODE_ID", "env")
+	t.Setenv("DBDB_HTTP_PORT", "8001")
+
+	cfg, err := GetConfig([]string{"--node-id", "flag"})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if cfg.Id != "flag" || cfg.HttpPort != "8001" || cfg.RaftPort != "9000" || cfg.RedisPort != "6379" {
+		t.Errorf("expected flags over environment over file, got %+v", cfg)
+	}
+	if cfg.File != path {
+		t.Errorf("expected configuration file %s, got %s", path, cfg.File)
+	}
+}
+
+func TestGetConfig_Errors(t *testing.T) {
+	required := []string{"--node-id", "node1", "--raft-port", "9000", "--http-port", "8000"}
+	tests := map[string]func() []string{
+		"unknown flag": func() []string { return []string{"--unknown"} },
+		"invalid flag": func() []string { return append(required, "--max-key-size", "big") },
+		"unknown setting": func() []string {
+			return append(required, "--config", writeFile(t, "dbdb.yaml", "unknown: 1\n"))
+		},
+		"invalid setting": func() []string {
+			return append(required, "--config", writeFile(t, "dbdb.json", `{"bootstrap": "maybe"}`))
+		},
+		"nested setting": func() []string {
+			return append(required, "--config", writeFile(t, "dbdb.toml", "[raft]\nheartbeat-timeout = \"1s\"\n"))
+		},
+		"unknown format":  func() []string { return append(required, "--config", writeFile(t, "dbdb.ini", "")) },
+		"missing file":    func() []string { return append(required, "--config", filepath.Join(t.TempDir(), "missing.yaml")) },
+		"invalid env var": func() []string { t.Setenv("DBDB_MAX_BATCH_SIZE", "many"); return required },
+	}
+	for name, args := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := GetConfig(args()); err == nil {
+				t.Errorf("expected error, got nil")
+			}
+		})
+	}
+}
+
+func TestPrint(t *testing.T) {
+	cfg, err := GetConfig([]string{"--node-id", "node1", "--raft-port", "9000", "--http-port", "8000", "--admin-token", "secret", "--print-config"})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !cfg.PrintConfig {
+		t.Errorf("expected PrintConfig to be set")
+	}
+
+	var b strings.Builder
+	if err := Print(&b, cfg); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	out := b.String()
+	for _, want := range []string{"node-id: node1\n", "raft-port: \"9000\"\n", "heartbeat-timeout: 1s\n", "max-batch-size: 64\n", "admin-token: REDACTED\n"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected output to contain %q, got:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "secret") || strings.Contains(out, "print-config") {
+		t.Errorf("expected admin token and print-config to be left out, got:\n%s", out)
+	}
+
+	// The printed configuration can be read back
+	path := writeFile(t, "dbdb.yaml", strings.Replace(out, "admin-token: REDACTED\n", "", 1))
+	read, err := GetConfig([]string{"--config", path, "--admin-token", "secret", "--print-config"})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	read.File = ""
+	if read != cfg {
+		t.Errorf("expected %+v, got %+v", cfg, read)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	args := []string{"--node-id", "node1", "--raft-port", "9000", "--http-port", "8000"}
+	old, err := GetConfig(args)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	new, err := GetConfig(append(args, "--client-rate", "10", "--election-timeout", "2s", "--max-key-size", "100", "--print-config"))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	changes := Diff(old, new)
+	if !slices.Equal(changes.Reloaded, []string{"client-rate", "election-timeout"}) {
+		t.Errorf("expected client-rate and election-timeout to be reloaded, got %v", changes.Reloaded)
+	}
+	if !slices.Equal(changes.RestartRequired, []string{"max-key-size"}) {
+		t.Errorf("expected max-key-size to require a restart, got %v", changes.RestartRequired)
+	}
+
+	if changes := Diff(old, old); len(changes.Reloaded) != 0 || len(changes.RestartRequired) != 0 {
+		t.Errorf("expected no changes, got %+v", changes)
+	}
+}
+
+func TestGetConfig_Addrs(t *testing.T) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Skipf("no hostname: %v", err)
+	}
+
+	cfg, err := GetConfig([]string{"--node-id", "node1", "--raft-port", "9000", "--http-port", "8000"})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if cfg.DataDir != "data" || cfg.RaftBind != "0.0.0.0:9000" || cfg.RaftAdvertise != hostname+":9000" ||
+		cfg.HttpBind != ":8000" || cfg.HttpAdvertise != hostname+":8000" {
+		t.Errorf("expected default addresses, got %+v", cfg)
+	}
+
+	cfg, err = GetConfig([]string{"--node-id", "node1", "--data-dir", "/var/lib/dbdb",
+		"--raft-bind", "10.0.0.1:9000", "--raft-advertise", "203.0.113.1:19000",
+		"--http-bind", "10.0.0.1:8000", "--http-advertise", "db.example.com:18000"})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if cfg.DataDir != "/var/lib/dbdb" || cfg.RaftBind != "10.0.0.1:9000" || cfg.RaftAdvertise != "203.0.113.1:19000" ||
+		cfg.HttpBind != "10.0.0.1:8000" || cfg.HttpAdvertise != "db.example.com:18000" {
+		t.Errorf("expected given addresses, got %+v", cfg)
+	}
+
+	required := []string{"--node-id", "node1", "--raft-port", "9000", "--http-port", "8000"}
+	for _, args := range [][]string{
+		{"--raft-advertise", "0.0.0.0:9000"},
+		{"--http-advertise", ":8000"},
+		{"--raft-bind", "9000"},
+		{"--data-dir", ""},
+	} {
+		if _, err := GetConfig(append(required, args...)); err == nil {
+			t.Errorf("%v: expected error, got nil", args)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package conf
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// readFile reads the settings of a configuration file, in the YAML, TOML or
+// JSON format given by its extension. The file holds a single mapping from
+// setting names to scalar values.
+func readFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw map[string]any
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".toml":
+		err = toml.Unmarshal(data, &raw)
+	case ".json":
+		// Numbers are kept as written rather than converted to floats
+		d := json.NewDecoder(bytes.NewReader(data))
+		d.UseNumber()
+		err = d.Decode(&raw)
+	default:
+		return nil, fmt.Errorf("unknown format %q of %s, expected .yaml, .yml, .toml or .json", ext, path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not parse %s: %w", path, err)
+	}
+
+	settings := make(map[string]string, len(raw))
+	for name, v := range raw {
+		switch v.(type) {
+		case map[string]any, []any, nil:
+			return nil, fmt.Errorf("setting %q in %s must be a string, number or boolean", name, path)
+		}
+		settings[name] = fmt.Sprint(v)
+	}
+	return settings, nil
+}
+
+// writeYAML writes settings as a YAML mapping ordered by name.
+func writeYAML(w io.Writer, settings map[string]any) error {
+	e := yaml.NewEncoder(w)
+	defer e.Close()
+	return e.Encode(settings)
+}
@@ -0,0 +1,61 @@
+package conf
+
+import (
+	"flag"
+	"slices"
+)
+
+// Settings that take effect when the configuration is reloaded. The others
+// only take effect when the node is restarted.
+var reloadable = map[string]bool{
+	"admin-token":        true,
+	"client-rate":        true,
+	"client-burst":       true,
+	"namespace-rate":     true,
+	"namespace-burst":    true,
+	"heartbeat-timeout":  true,
+	"election-timeout":   true,
+	"snapshot-interval":  true,
+	"snapshot-threshold": true,
+	"trailing-logs":      true,
+	"apply-timeout":      true,
+	"log-level":          true,
+}
+
+// Changes lists the settings changed by reloading the configuration.
+type Changes struct {
+	// Settings that took effect
+	Reloaded []string `json:"reloaded"`
+
+	// Settings that only take effect once the node is restarted
+	RestartRequired []string `json:"restart_required"`
+}
+
+// Diff returns the settings that differ between two configurations, ordered
+// by name.
+func Diff(old, new Config) Changes {
+	values := func(cfg Config) map[string]string {
+		var c Config
+		fs := newFlagSet(&c)
+		c = cfg
+
+		values := make(map[string]string)
+		fs.VisitAll(func(f *flag.Flag) { values[f.Name] = f.Value.String() })
+		return values
+	}
+	before, after := values(old), values(new)
+
+	changes := Changes{Reloaded: []string{}, RestartRequired: []string{}}
+	for name, v := range after {
+		switch {
+		case name == "config" || name == "print-config" || before[name] == v:
+		case reloadable[name]:
+			changes.Reloaded = append(changes.Reloaded, name)
+		default:
+			changes.RestartRequired = append(changes.RestartRequired, name)
+		}
+	}
+	slices.Sort(changes.Reloaded)
+	slices.Sort(changes.RestartRequired)
+	return changes
+}
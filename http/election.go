@@ -0,0 +1,230 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thanhqng1510/dbdb/store"
+)
+
+const (
+	// Candidates are stored as keys under this prefix, followed by the name
+	// of the election and the lease of the candidate
+	electionKeyPrefix = "_elections/"
+
+	// How often blocked campaign and observe requests check the election
+	electionPollInterval = 100 * time.Millisecond
+)
+
+// electionRequest is the body of campaign and resign requests.
+type electionRequest struct {
+	Name  string `json:"name"`
+	Lease uint64 `json:"lease"`
+
+	// Value published by the candidate while it is the leader
+	Value []byte `json:"value,omitempty"`
+}
+
+// electionLeader describes the leader of an election.
+type electionLeader struct {
+	Name  string `json:"name"`
+	Lease uint64 `json:"lease"`
+	Value []byte `json:"value"`
+
+	// Revision at which the leader started campaigning. Leaders are elected
+	// in the order in which they started campaigning.
+	Revision uint64 `json:"revision"`
+}
+
+// electionPrefix returns the prefix of the keys of the candidates of an election.
+func electionPrefix(name string) string {
+	return electionKeyPrefix + name + "/"
+}
+
+// electionKey returns the key of the candidate with the given lease.
+func electionKey(name string, lease uint64) string {
+	return fmt.Sprintf("%s%016x", electionPrefix(name), lease)
+}
+
+// leader returns the leader of an election, which is the candidate that
+// started campaigning first, and whether the election has a candidate.
+func (s *Server) leader(name string) (electionLeader, bool, error) {
+	prefix := electionPrefix(name)
+
+	// Keys under the prefix are followed by a '/', which precedes '0'
+	lookups, _, err := s.store.Range(prefix, electionKeyPrefix+name+"0", 0, 0)
+	if err != nil || len(lookups) == 0 {
+		return electionLeader{}, false, err
+	}
+
+	first := lookups[0]
+	for _, l := range lookups[1:] {
+		if l.Entry.Version < first.Entry.Version {
+			first = l
+		}
+	}
+	return electionLeader{Name: name, Lease: first.Entry.Lease, Value: first.Entry.Value, Revision: first.Entry.Version}, true, nil
+}
+
+// readElectionRequest decodes and checks the body of an election request. It
+// writes an error response and returns false if the request is invalid.
+func (s *Server) readElectionRequest(w http.ResponseWriter, r *http.Request) (electionRequest, bool) {
+	var req electionRequest
+	if !s.readJSON(w, r, &req) {
+		return electionRequest{}, false
+	}
+
+	var fields []store.FieldError
+	switch {
+	case req.Name == "":
+		fields = append(fields, store.FieldError{Field: "name", Message: "is required"})
+	case strings.Contains(req.Name, "/"):
+		fields = append(fields, store.FieldError{Field: "name", Message: "must not contain '/'"})
+	}
+	if req.Lease == 0 {
+		fields = append(fields, store.FieldError{Field: "lease", Message: "is required"})
+	}
+	if len(fields) > 0 {
+		writeError(w, http.StatusBadRequest, apiError{Code: codeInvalidArgument, Message: "Invalid election request", Fields: fields})
+		return electionRequest{}, false
+	}
+	return req, true
+}
+
+// campaignHandler enters a candidate in an election with its lease and
+// blocks until it is the leader. The candidate leaves the election when it
+// resigns or its lease expires, handing leadership over to the next one.
+func (s *Server) campaignHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	req, ok := s.readElectionRequest(w, r)
+	if !ok {
+		return
+	}
+
+	// Campaigning again with the same lease keeps the candidate's place
+	key := electionKey(req.Name, req.Lease)
+	value := req.Value
+	if value == nil {
+		value = []byte{}
+	}
+	if _, ok := s.apply(w, store.Command{
+		Op:      store.OpTypeTxn,
+		Compare: []store.Compare{{Key: key, Target: store.CompareExists, Op: store.CompareEqual, Exists: false}},
+		Then:    []store.Command{{Op: store.OpTypeSet, Key: key, Value: value, Lease: req.Lease}},
+	}); !ok {
+		return
+	}
+
+	ticker := time.NewTicker(electionPollInterval)
+	defer ticker.Stop()
+
+	for {
+		leader, _, err := s.leader(req.Name)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		if leader.Lease == req.Lease {
+			writeJSON(w, leader)
+			return
+		}
+		if _, exist := s.store.Get(key); !exist {
+			writeError(w, http.StatusConflict, apiError{Code: codeConflict, Message: "Candidate left the election before being elected"})
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			writeError(w, http.StatusServiceUnavailable, apiError{Code: codeTimeout, Message: "Request ended before being elected"})
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// resignHandler removes a candidate from an election. If it was the leader,
+// the next candidate becomes the leader.
+func (s *Server) resignHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	req, ok := s.readElectionRequest(w, r)
+	if !ok {
+		return
+	}
+
+	res, ok := s.apply(w, store.Command{Op: store.OpTypeDelete, Key: electionKey(req.Name, req.Lease)})
+	if !ok {
+		return
+	}
+	if !res.Applied {
+		writeError(w, http.StatusConflict, apiError{Code: codeConflict, Message: fmt.Sprintf("Lease %d is not campaigning in election %s", req.Lease, req.Name)})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// observeHandler returns the current leader of an election. If the wait
+// query parameter holds the revision of a leader, it blocks until that
+// candidate is no longer the leader.
+func (s *Server) observeHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	name := r.URL.Query().Get("name")
+	if name == "" || strings.Contains(name, "/") {
+		writeError(w, http.StatusBadRequest, apiError{
+			Code:    codeInvalidArgument,
+			Message: "Name must not be empty nor contain '/'",
+			Fields:  []store.FieldError{{Field: "name", Message: "is required"}},
+		})
+		return
+	}
+
+	var wait uint64
+	if v := r.URL.Query().Get("wait"); v != "" {
+		var err error
+		if wait, err = strconv.ParseUint(v, 10, 64); err != nil {
+			writeError(w, http.StatusBadRequest, apiError{
+				Code:    codeInvalidArgument,
+				Message: "Wait must be the revision of a leader",
+				Fields:  []store.FieldError{{Field: "wait", Message: "must be a positive integer"}},
+			})
+			return
+		}
+	}
+
+	ticker := time.NewTicker(electionPollInterval)
+	defer ticker.Stop()
+
+	for {
+		leader, ok, err := s.leader(name)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		if wait == 0 || leader.Revision != wait {
+			if !ok {
+				writeError(w, http.StatusNotFound, apiError{Code: codeKeyNotFound, Message: fmt.Sprintf("Election %s has no leader", name)})
+				return
+			}
+			writeJSON(w, leader)
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			writeJSON(w, leader)
+			return
+		case <-ticker.C:
+		}
+	}
+}
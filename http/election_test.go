@@ -0,0 +1,97 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thanhqng1510/dbdb/store"
+	"github.com/thanhqng1510/dbdb/store/storetest"
+)
+
+func candidates() []store.Lookup {
+	return []store.Lookup{
+		{Key: electionKey("svc", 9), Entry: store.Entry{Value: []byte("b"), Version: 20, Lease: 9}, Found: true},
+		{Key: electionKey("svc", 12), Entry: store.Entry{Value: []byte("a"), Version: 14, Lease: 12}, Found: true},
+	}
+}
+
+func TestCampaignHandler(t *testing.T) {
+	s := &Server{store: &storetest.Fake{ApplyResult: &store.Result{Applied: true}, Lookups: candidates()}}
+
+	w := httptest.NewRecorder()
+	s.campaignHandler(w, httptest.NewRequest(http.MethodPost, "/election/campaign", strings.NewReader(`{"name": "svc", "lease": 12, "value": "YQ=="}`)))
+	if want := `{"name":"svc","lease":12,"value":"YQ==","revision":14}`; w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != want {
+		t.Errorf("expected 200 OK with body `%s`, got %d `%s`", want, w.Code, w.Body.String())
+	}
+}
+
+func TestCampaignHandler_Blocks(t *testing.T) {
+	s := &Server{store: &storetest.Fake{ApplyResult: &store.Result{Applied: true}, Lookups: candidates(), Entries: map[string]store.Entry{electionKey("svc", 9): {}}}}
+
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), 3*electionPollInterval)
+	defer cancel()
+	req := httptest.NewRequest(http.MethodPost, "/election/campaign", strings.NewReader(`{"name": "svc", "lease": 9}`)).WithContext(ctx)
+
+	w := httptest.NewRecorder()
+	s.campaignHandler(w, req)
+	if w.Code != http.StatusServiceUnavailable || time.Since(start) < 3*electionPollInterval {
+		t.Errorf("expected campaign to block until the request ends, got %d after %s", w.Code, time.Since(start))
+	}
+
+	// The candidate's key is gone, e.g. its lease expired
+	s = &Server{store: &storetest.Fake{ApplyResult: &store.Result{Applied: true}, Lookups: candidates()}}
+	w = httptest.NewRecorder()
+	s.campaignHandler(w, httptest.NewRequest(http.MethodPost, "/election/campaign", strings.NewReader(`{"name": "svc", "lease": 9}`)))
+	if w.Code != http.StatusConflict {
+		t.Errorf("expected 409 Conflict, got %d", w.Code)
+	}
+}
+
+func TestCampaignHandler_InvalidName(t *testing.T) {
+	s := &Server{store: &storetest.Fake{}}
+	w := httptest.NewRecorder()
+	s.campaignHandler(w, httptest.NewRequest(http.MethodPost, "/election/campaign", strings.NewReader(`{"name": "a/b", "lease": 1}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 Bad Request, got %d", w.Code)
+	}
+}
+
+func TestResignHandler(t *testing.T) {
+	s := &Server{store: &storetest.Fake{ApplyResult: &store.Result{Applied: false}}}
+	w := httptest.NewRecorder()
+	s.resignHandler(w, httptest.NewRequest(http.MethodPost, "/election/resign", strings.NewReader(`{"name": "svc", "lease": 12}`)))
+	if w.Code != http.StatusConflict {
+		t.Errorf("expected 409 Conflict for a lease not campaigning, got %d", w.Code)
+	}
+}
+
+func TestObserveHandler(t *testing.T) {
+	s := &Server{store: &storetest.Fake{Lookups: candidates()}}
+
+	w := httptest.NewRecorder()
+	s.observeHandler(w, httptest.NewRequest(http.MethodGet, "/election/observe?name=svc", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"revision":14`) {
+		t.Errorf("expected leader at revision 14, got %d `%s`", w.Code, w.Body.String())
+	}
+
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*electionPollInterval)
+	defer cancel()
+	w = httptest.NewRecorder()
+	s.observeHandler(w, httptest.NewRequest(http.MethodGet, "/election/observe?name=svc&wait=14", nil).WithContext(ctx))
+	if w.Code != http.StatusOK || time.Since(start) < 2*electionPollInterval {
+		t.Errorf("expected observe to block while the leader is unchanged, got %d after %s", w.Code, time.Since(start))
+	}
+
+	s = &Server{store: &storetest.Fake{}}
+	w = httptest.NewRecorder()
+	s.observeHandler(w, httptest.NewRequest(http.MethodGet, "/election/observe?name=svc", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for election without leader, got %d", w.Code)
+	}
+}
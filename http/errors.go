@@ -0,0 +1,111 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/thanhqng1510/dbdb/store"
+)
+
+// Machine-readable error codes returned in error responses.
+const (
+	codeInvalidArgument    = "invalid_argument"
+	codeKeyNotFound        = "key_not_found"
+	codeNotLeader          = "not_leader"
+	codeTimeout            = "timeout"
+	codeConflict           = "conflict"
+	codePreconditionFailed = "precondition_failed"
+	codeCompacted          = "compacted"
+	codeLeaseNotFound      = "lease_not_found"
+	codeIndexNotFound      = "index_not_found"
+	codeNamespaceNotFound  = "namespace_not_found"
+	codeQuotaExceeded      = "quota_exceeded"
+	codeUnauthenticated    = "unauthenticated"
+	codeMethodNotAllowed   = "method_not_allowed"
+	codeRateLimited        = "rate_limited"
+	codeInternal           = "internal"
+)
+
+// apiError is the body of every error response, wrapped in an "error" field.
+type apiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+
+	// Current leader, for not_leader errors
+	Leader *leaderHint `json:"leader,omitempty"`
+
+	// Invalid fields, for invalid_argument errors
+	Fields []store.FieldError `json:"fields,omitempty"`
+}
+
+type leaderHint struct {
+	Id   string `json:"id"`
+	Addr string `json:"addr"`
+
+	// HTTP address to send the request to, if known
+	HttpAddr string `json:"http_addr,omitempty"`
+}
+
+// writeError writes an error response with the given status.
+func writeError(w http.ResponseWriter, status int, e apiError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(struct {
+		Error apiError `json:"error"`
+	}{e}); err != nil {
+		log.Printf("Could not encode error response: %s", err)
+	}
+}
+
+// writeStoreError writes the error response for an error returned by the store.
+func writeStoreError(w http.ResponseWriter, err error) {
+	var verr *store.ValidationError
+	var nlerr *store.NotLeaderError
+	switch {
+	case errors.As(err, &verr):
+		writeError(w, http.StatusBadRequest, apiError{Code: codeInvalidArgument, Message: verr.Error(), Fields: verr.Fields})
+	case errors.As(err, &nlerr):
+		e := apiError{Code: codeNotLeader, Message: nlerr.Error()}
+		if nlerr.LeaderID != "" {
+			e.Leader = &leaderHint{Id: nlerr.LeaderID, Addr: nlerr.LeaderAddr, HttpAddr: nlerr.LeaderHttpAddr}
+		}
+		writeError(w, http.StatusMisdirectedRequest, e)
+	case errors.Is(err, store.ErrTimeout):
+		writeError(w, http.StatusServiceUnavailable, apiError{Code: codeTimeout, Message: err.Error()})
+	case errors.Is(err, store.ErrLeaseNotFound):
+		writeError(w, http.StatusNotFound, apiError{Code: codeLeaseNotFound, Message: err.Error()})
+	case errors.Is(err, store.ErrNamespaceNotFound):
+		writeError(w, http.StatusNotFound, apiError{Code: codeNamespaceNotFound, Message: err.Error()})
+	case errors.Is(err, store.ErrQuotaExceeded):
+		writeError(w, http.StatusInsufficientStorage, apiError{Code: codeQuotaExceeded, Message: err.Error()})
+	case errors.Is(err, store.ErrIndexNotFound):
+		writeError(w, http.StatusNotFound, apiError{Code: codeIndexNotFound, Message: err.Error()})
+	case errors.Is(err, store.ErrCompacted):
+		writeError(w, http.StatusGone, apiError{Code: codeCompacted, Message: err.Error()})
+	case errors.Is(err, store.ErrFutureRevision):
+		writeError(w, http.StatusBadRequest, apiError{
+			Code:    codeInvalidArgument,
+			Message: err.Error(),
+			Fields:  []store.FieldError{{Field: "revision", Message: "must not be after the current revision"}},
+		})
+	case errors.Is(err, store.ErrConflict):
+		writeError(w, http.StatusConflict, apiError{Code: codeConflict, Message: err.Error()})
+	default:
+		writeError(w, http.StatusInternalServerError, apiError{Code: codeInternal, Message: err.Error()})
+	}
+}
+
+// allowMethod writes an error response and returns false if the request
+// method is not the given one.
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+
+	w.Header().Set("Allow", method)
+	writeError(w, http.StatusMethodNotAllowed, apiError{Code: codeMethodNotAllowed, Message: "Only " + method + " method is allowed"})
+	return false
+}
@@ -1,12 +1,16 @@
 package http
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"sync/atomic"
 
+	"github.com/thanhqng1510/dbdb/conf"
 	"github.com/thanhqng1510/dbdb/store"
 )
 
@@ -14,111 +18,237 @@ import (
 type Server struct {
 	addr  string
 	store store.IStore
+
+	// Token required by administration requests, which also grants access
+	// to every namespace. Empty to allow requests without a token. It is
+	// shared by the copies of the server scoped to namespaces and can be
+	// replaced at runtime.
+	adminToken *atomic.Pointer[string]
+
+	// Rate limits on key requests, adjustable at runtime
+	limiter *limiter
+
+	// Reloads the configuration of the node, nil if not supported
+	reload func() (conf.Changes, error)
+
+	// Maximum sizes in bytes of the values put to /v1/kv and of the commands
+	// sent to /apply, zero for no limit
+	maxValueSize   int64
+	maxCommandSize int64
 }
 
+// Room for the fields of a command other than its key and value
+const commandOverhead = 64 * 1024
+
 // NewServer creates a new HTTP server.
-func NewServer(addr string, store store.IStore) *Server {
-	return &Server{
-		addr:  addr,
-		store: store,
+func NewServer(addr string, store store.IStore, adminToken string) *Server {
+	s := &Server{
+		addr:       addr,
+		store:      store,
+		adminToken: new(atomic.Pointer[string]),
+		limiter:    newLimiter(RateLimits{}),
+	}
+	s.SetAdminToken(adminToken)
+	return s
+}
+
+// SetAdminToken replaces the admin token.
+func (s *Server) SetAdminToken(token string) {
+	s.adminToken.Store(&token)
+}
+
+// SetSizeLimits sets the maximum sizes in bytes of keys and values, zero for
+// no limit, so that request bodies carrying larger ones are rejected before
+// being read whole. Commands sent to /apply can carry one key and value of
+// the maximum sizes, the value being base64 encoded.
+func (s *Server) SetSizeLimits(maxKeySize, maxValueSize int) {
+	s.maxValueSize = int64(maxValueSize)
+	s.maxCommandSize = 0
+	if maxKeySize > 0 && maxValueSize > 0 {
+		s.maxCommandSize = int64(maxKeySize+base64.StdEncoding.EncodedLen(maxValueSize)) + commandOverhead
 	}
 }
 
+// SetReloadFunc sets the function reloading the configuration of the node on
+// requests to /config/reload.
+func (s *Server) SetReloadFunc(reload func() (conf.Changes, error)) {
+	s.reload = reload
+}
+
+// keyRoutes are the endpoints on the keys of a namespace. They are served at
+// the root for the namespace named by the namespace header, or the default
+// namespace without one, and under /ns/{namespace}/.
+var keyRoutes = map[string]func(*Server, http.ResponseWriter, *http.Request){
+	"/apply":             (*Server).applyHandler,
+	"/get":               (*Server).getHandler,
+	"/mget":              (*Server).mgetHandler,
+	"/range":             (*Server).rangeHandler,
+	"/v1/kv/":            (*Server).kvHandler,
+	"/kv/":               (*Server).kvHandler,
+	"/lease/grant":       (*Server).leaseGrantHandler,
+	"/lease/keepalive":   (*Server).leaseKeepAliveHandler,
+	"/lease/revoke":      (*Server).leaseRevokeHandler,
+	"/lock/acquire":      (*Server).lockAcquireHandler,
+	"/lock/release":      (*Server).lockReleaseHandler,
+	"/election/campaign": (*Server).campaignHandler,
+	"/election/resign":   (*Server).resignHandler,
+	"/election/observe":  (*Server).observeHandler,
+	"/index/create":      (*Server).indexCreateHandler,
+	"/index/drop":        (*Server).indexDropHandler,
+	"/index/list":        (*Server).indexListHandler,
+	"/index/query":       (*Server).indexQueryHandler,
+}
+
+// adminKeyRoutes are the key endpoints that also require the admin token, as
+// every later write pays to maintain what they create.
+var adminKeyRoutes = map[string]bool{
+	"/index/create": true,
+	"/index/drop":   true,
+}
+
 // Start starts the HTTP server. This is a blocking call.
 func (s *Server) Start() error {
 	log.Printf("Starting HTTP server on %s", s.addr)
+	go s.limiter.sweepBuckets()
+	return http.ListenAndServe(s.addr, s.routes())
+}
 
+// routes returns the handler of every endpoint.
+func (s *Server) routes() *http.ServeMux {
 	mux := http.NewServeMux()
-	mux.HandleFunc("/apply", s.applyHandler)
-	mux.HandleFunc("/get", s.getHandler)
+	keys := http.NewServeMux()
+	for path, h := range keyRoutes {
+		handler := s.scoped(h)
+		if adminKeyRoutes[path] {
+			handler = s.admin(handler)
+		}
+		mux.HandleFunc(path, handler)
+		keys.HandleFunc(path, handler)
+	}
+	mux.HandleFunc("/ns/", namespaceRoute(keys))
+	mux.HandleFunc("/namespace/create", s.admin(s.namespaceCreateHandler))
+	mux.HandleFunc("/namespace/update", s.admin(s.namespaceUpdateHandler))
+	mux.HandleFunc("/namespace/delete", s.admin(s.namespaceDeleteHandler))
+	mux.HandleFunc("/namespace/list", s.admin(s.namespaceListHandler))
+	mux.HandleFunc("/ratelimit", s.admin(s.rateLimitsHandler))
+	mux.HandleFunc("/config/reload", s.admin(s.reloadHandler))
 	mux.HandleFunc("/add-node", s.addNodeHandler)
 	mux.HandleFunc("/remove-node", s.removeNodeHandler)
-	return http.ListenAndServe(s.addr, mux)
+	return mux
 }
 
 func (s *Server) applyHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+	if !allowMethod(w, r, http.MethodPost) {
 		return
 	}
 
 	defer r.Body.Close()
 
+	limitBody(w, r, s.maxCommandSize)
 	bodyBytes, err := io.ReadAll(r.Body)
 	if err != nil {
 		log.Printf("Could not read request body for apply operation: %s", err)
-		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		writeBodyError(w, err)
 		return
 	}
 
-	if err := s.store.Apply(bodyBytes); err != nil {
+	if !s.allowApply(w, r, bodyBytes) {
+		return
+	}
+
+	res, err := s.store.Apply(bodyBytes)
+	if err != nil {
 		log.Printf("Error applying operation: %s", err)
-		http.Error(w, fmt.Sprintf("Failed to apply operation: %s", err), http.StatusInternalServerError)
+		writeStoreError(w, err)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
+	if res != nil && res.Conflict {
+		if res.Version == 0 {
+			writeError(w, http.StatusNotFound, apiError{Code: codeKeyNotFound, Message: "Condition not met, key does not exist"})
+			return
+		}
+		writeError(w, http.StatusConflict, apiError{
+			Code:    codeConflict,
+			Message: fmt.Sprintf("Condition not met, current version of key is %d", res.Version),
+		})
+		return
+	}
+
+	// The result carries the outcome of each command of a transaction
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		log.Printf("Could not encode apply response: %s", err)
+	}
 }
 
 func (s *Server) getHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+	if !allowMethod(w, r, http.MethodGet) {
 		return
 	}
 
 	key := r.URL.Query().Get("key")
 	if key == "" {
-		http.Error(w, "Key parameter must not be empty", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, apiError{
+			Code:    codeInvalidArgument,
+			Message: "Key parameter must not be empty",
+			Fields:  []store.FieldError{{Field: "key", Message: "is required"}},
+		})
 		return
 	}
 
-	value, exist := s.store.Get(key)
-	if !exist {
-		http.Error(w, fmt.Sprintf("Key %s not found", key), http.StatusBadRequest)
+	revision, ok := parseRevision(w, r)
+	if !ok {
 		return
 	}
 
-	var valStr string
-	if value == nil {
-		valStr = ""
-	} else {
-		valStr = value.(string)
+	entry, exist, err := s.store.GetAt(key, revision)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if !exist {
+		writeError(w, http.StatusNotFound, apiError{Code: codeKeyNotFound, Message: fmt.Sprintf("Key %s not found", key)})
+		return
+	}
+	switch entry.Type {
+	case store.TypeString:
+	case store.TypeJSON:
+		writeDocument(w, r, key, entry)
+		return
+	default:
+		writeCollection(w, r, key, entry)
+		return
 	}
 
+	// Values are returned as base64
 	rsp := struct {
-		Data string `json:"data"`
-	}{valStr}
+		Data []byte `json:"data"`
+	}{entry.Value}
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(rsp); err != nil {
 		log.Printf("Could not encode get response: %s", err)
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 	}
 }
 
 func (s *Server) addNodeHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+	if !allowMethod(w, r, http.MethodPost) {
 		return
 	}
 
 	followerId := r.URL.Query().Get("followerId")
 	followerAddr := r.URL.Query().Get("followerAddr")
+	followerHttpAddr := r.URL.Query().Get("followerHttpAddr")
 
 	if followerId == "" || followerAddr == "" {
-		http.Error(w, "Missing followerId or followerAddr query parameters", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, apiError{Code: codeInvalidArgument, Message: "Missing followerId or followerAddr query parameters"})
 		return
 	}
 
-	if err := s.store.AddFollower(followerId, followerAddr); err != nil {
+	if err := s.store.AddFollower(followerId, followerAddr, followerHttpAddr); err != nil {
 		log.Printf("Failed to add follower: %s", err)
-
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		
-		json.NewEncoder(w).Encode(struct {
-			Error string `json:"error"`
-		}{err.Error()})
+		writeStoreError(w, err)
 		return
 	}
 
@@ -127,30 +257,110 @@ func (s *Server) addNodeHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) removeNodeHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+	if !allowMethod(w, r, http.MethodPost) {
 		return
 	}
 
 	followerId := r.URL.Query().Get("followerId")
 
 	if followerId == "" {
-		http.Error(w, "Missing followerId query parameter", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, apiError{Code: codeInvalidArgument, Message: "Missing followerId query parameter"})
 		return
 	}
 
 	if err := s.store.RemoveFollower(followerId); err != nil {
 		log.Printf("Failed to remove follower: %s", err)
-
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		
-		json.NewEncoder(w).Encode(struct {
-			Error string `json:"error"`
-		}{err.Error()})
+		writeStoreError(w, err)
 		return
 	}
 
 	log.Printf("Successfully remove voter %s to the cluster", followerId)
 	w.WriteHeader(http.StatusOK)
 }
+
+// reloadHandler reloads the configuration of the node and reports the
+// settings that changed, split between those that took effect and those
+// that need a restart.
+func (s *Server) reloadHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	if s.reload == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	changes, err := s.reload()
+	if err != nil {
+		log.Printf("Could not reload configuration: %s", err)
+		writeError(w, http.StatusBadRequest, apiError{Code: codeInvalidArgument, Message: "Could not reload configuration: " + err.Error()})
+		return
+	}
+	writeJSON(w, changes)
+}
+
+// apply sends a command to the store. It writes an error response and
+// returns false if the command failed.
+func (s *Server) apply(w http.ResponseWriter, c store.Command) (*store.Result, bool) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		writeStoreError(w, err)
+		return nil, false
+	}
+
+	res, err := s.store.Apply(data)
+	if err != nil {
+		log.Printf("Error applying %s operation: %s", c.Op, err)
+		writeStoreError(w, err)
+		return nil, false
+	}
+	return res, true
+}
+
+// readJSON decodes the JSON body of a request, which is limited to the size
+// of a command. It writes an error response and returns false if the body is
+// malformed or too large.
+func (s *Server) readJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	defer r.Body.Close()
+
+	limitBody(w, r, s.maxCommandSize)
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			writeBodyError(w, err)
+			return false
+		}
+		writeError(w, http.StatusBadRequest, apiError{Code: codeInvalidArgument, Message: "Malformed JSON: " + err.Error()})
+		return false
+	}
+	return true
+}
+
+// limitBody limits the size of the body of a request, if limit is not zero.
+func limitBody(w http.ResponseWriter, r *http.Request, limit int64) {
+	if limit > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+	}
+}
+
+// writeBodyError writes the error response for a request body that could not
+// be read, which is 413 if it is over its size limit.
+func writeBodyError(w http.ResponseWriter, err error) {
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		writeError(w, http.StatusRequestEntityTooLarge, apiError{
+			Code:    codeInvalidArgument,
+			Message: fmt.Sprintf("Request body exceeds %d bytes", maxErr.Limit),
+		})
+		return
+	}
+	writeError(w, http.StatusBadRequest, apiError{Code: codeInvalidArgument, Message: "Failed to read request body"})
+}
+
+// writeJSON writes a JSON response.
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Could not encode response: %s", err)
+	}
+}
@@ -2,28 +2,19 @@ package http
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
-)
-
-// MockStore implements the minimal methods needed for testing.
-type MockStore struct {
-	ApplyErr          error
-	GetValueExists    bool
-	GetValue          interface{}
-	AddFollowerErr    error
-	RemoveFollowerErr error
-}
 
-func (m *MockStore) Apply([]byte) error                 { return m.ApplyErr }
-func (m *MockStore) Get(key string) (interface{}, bool) { return m.GetValue, m.GetValueExists }
-func (m *MockStore) AddFollower(id, addr string) error  { return m.AddFollowerErr }
-func (m *MockStore) RemoveFollower(id string) error     { return m.RemoveFollowerErr }
+	"github.com/thanhqng1510/dbdb/conf"
+	"github.com/thanhqng1510/dbdb/store"
+	"github.com/thanhqng1510/dbdb/store/storetest"
+)
 
 func TestApplyHandler_OnlyPost(t *testing.T) {
-	s := &Server{store: &MockStore{}}
+	s := &Server{store: &storetest.Fake{}}
 
 	req := httptest.NewRequest(http.MethodGet, "/apply", nil)
 	w := httptest.NewRecorder()
@@ -41,7 +32,7 @@ func TestApplyHandler_OnlyPost(t *testing.T) {
 }
 
 func TestApplyHandler_Success(t *testing.T) {
-	s := &Server{store: &MockStore{}}
+	s := &Server{store: &storetest.Fake{}}
 	req := httptest.NewRequest(http.MethodPost, "/apply", strings.NewReader("test"))
 	w := httptest.NewRecorder()
 	s.applyHandler(w, req)
@@ -51,7 +42,7 @@ func TestApplyHandler_Success(t *testing.T) {
 }
 
 func TestApplyHandler_Error(t *testing.T) {
-	s := &Server{store: &MockStore{ApplyErr: errors.New("fail")}}
+	s := &Server{store: &storetest.Fake{ApplyErr: errors.New("fail")}}
 	req := httptest.NewRequest(http.MethodPost, "/apply", strings.NewReader("test"))
 	w := httptest.NewRecorder()
 	s.applyHandler(w, req)
@@ -60,8 +51,22 @@ func TestApplyHandler_Error(t *testing.T) {
 	}
 }
 
+func TestApplyHandler_ValidationError(t *testing.T) {
+	verr := &store.ValidationError{Fields: []store.FieldError{{Field: "key", Message: "is required"}}}
+	s := &Server{store: &storetest.Fake{ApplyErr: verr}}
+	req := httptest.NewRequest(http.MethodPost, "/apply", strings.NewReader(`{"op": "set"}`))
+	w := httptest.NewRecorder()
+	s.applyHandler(w, req)
+	if w.Result().StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 Bad Request, got %d", w.Result().StatusCode)
+	}
+	if !strings.Contains(w.Body.String(), `"fields":[{"field":"key","message":"is required"}]`) {
+		t.Errorf("expected field errors in response body, got `%s`", w.Body.String())
+	}
+}
+
 func TestGetHandler_OnlyGet(t *testing.T) {
-	s := &Server{store: &MockStore{GetValueExists: true, GetValue: "bar"}}
+	s := &Server{store: &storetest.Fake{Entries: map[string]store.Entry{"foo": {Value: []byte("bar")}}}}
 
 	req := httptest.NewRequest(http.MethodPost, "/get", nil)
 	w := httptest.NewRecorder()
@@ -79,7 +84,7 @@ func TestGetHandler_OnlyGet(t *testing.T) {
 }
 
 func TestGetHandler_KeyQueryParamMustNotEmpty(t *testing.T) {
-	s := &Server{store: &MockStore{}}
+	s := &Server{store: &storetest.Fake{}}
 
 	req := httptest.NewRequest(http.MethodGet, "/get", nil)
 	w := httptest.NewRecorder()
@@ -97,24 +102,193 @@ func TestGetHandler_KeyQueryParamMustNotEmpty(t *testing.T) {
 }
 
 func TestGetHandler_Success(t *testing.T) {
-	s := &Server{store: &MockStore{GetValue: "bar", GetValueExists: true}}
+	s := &Server{store: &storetest.Fake{Entries: map[string]store.Entry{"foo": {Value: []byte("bar")}}}}
 	req := httptest.NewRequest(http.MethodGet, "/get?key=foo", nil)
 	w := httptest.NewRecorder()
 	s.getHandler(w, req)
 	if w.Result().StatusCode != http.StatusOK {
 		t.Errorf("expected 200 OK, got %d", w.Result().StatusCode)
 	}
-	if strings.Trim(w.Body.String(), " \n") != `{"data":"bar"}` {
-		t.Errorf("expected response body to be `{\"data\":\"bar\"}`, got `%s`", w.Body.String())
+	if strings.Trim(w.Body.String(), " \n") != `{"data":"YmFy"}` {
+		t.Errorf("expected response body to be `{\"data\":\"YmFy\"}`, got `%s`", w.Body.String())
 	}
 }
 
 func TestGetHandler_ErrorIfKeyNotExist(t *testing.T) {
-	s := &Server{store: &MockStore{GetValue: nil, GetValueExists: false}}
+	s := &Server{store: &storetest.Fake{}}
 	req := httptest.NewRequest(http.MethodGet, "/get?key=nonexistent", nil)
 	w := httptest.NewRecorder()
 	s.getHandler(w, req)
+	if w.Result().StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 Not Found, got %d", w.Result().StatusCode)
+	}
+	if !strings.Contains(w.Body.String(), `"code":"key_not_found"`) {
+		t.Errorf("expected key_not_found error code, got `%s`", w.Body.String())
+	}
+}
+
+func TestGetHandler_Revision(t *testing.T) {
+	m := &storetest.Fake{Entries: map[string]store.Entry{"foo": {Value: []byte("bar")}}}
+	s := &Server{store: m}
+	req := httptest.NewRequest(http.MethodGet, "/get?key=foo&revision=5", nil)
+	w := httptest.NewRecorder()
+	s.getHandler(w, req)
+	if w.Result().StatusCode != http.StatusOK || m.GetRevision != 5 {
+		t.Errorf("expected 200 OK at revision 5, got %d at revision %d", w.Result().StatusCode, m.GetRevision)
+	}
+
+	s = &Server{store: &storetest.Fake{ReadErr: store.ErrCompacted}}
+	w = httptest.NewRecorder()
+	s.getHandler(w, req)
+	if w.Result().StatusCode != http.StatusGone || !strings.Contains(w.Body.String(), `"code":"compacted"`) {
+		t.Errorf("expected 410 compacted error, got %d `%s`", w.Result().StatusCode, w.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/get?key=foo&revision=abc", nil)
+	w = httptest.NewRecorder()
+	s.getHandler(w, req)
 	if w.Result().StatusCode != http.StatusBadRequest {
-		t.Errorf("expected 400 Bad Request, got %d", w.Result().StatusCode)
+		t.Errorf("expected 400 for invalid revision, got %d", w.Result().StatusCode)
+	}
+}
+
+func TestApplyHandler_Result(t *testing.T) {
+	s := &Server{store: &storetest.Fake{ApplyResult: &store.Result{
+		Applied:   true,
+		Succeeded: true,
+		Results:   []store.Result{{Applied: true, Version: 4}, {Applied: true, Value: []byte("1"), Version: 4}},
+	}}}
+	req := httptest.NewRequest(http.MethodPost, "/apply", strings.NewReader("test"))
+	w := httptest.NewRecorder()
+	s.applyHandler(w, req)
+
+	want := `{"applied":true,"succeeded":true,"results":[{"applied":true,"version":4},{"applied":true,"value":"MQ==","version":4}]}`
+	if w.Result().StatusCode != http.StatusOK || strings.TrimSpace(w.Body.String()) != want {
+		t.Errorf("expected 200 OK with body `%s`, got %d `%s`", want, w.Result().StatusCode, w.Body.String())
+	}
+}
+
+func TestApplyHandler_ErrorStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		body   string
+	}{
+		{
+			name:   "not leader",
+			err:    &store.NotLeaderError{LeaderID: "node1", LeaderAddr: "node1:2221"},
+			status: http.StatusMisdirectedRequest,
+			body:   `{"error":{"code":"not_leader","message":"not the leader, leader is node1 at node1:2221","leader":{"id":"node1","addr":"node1:2221"}}}`,
+		},
+		{
+			name:   "not leader with http address",
+			err:    &store.NotLeaderError{LeaderID: "node1", LeaderAddr: "node1:2221", LeaderHttpAddr: "node1:8221"},
+			status: http.StatusMisdirectedRequest,
+			body:   `{"error":{"code":"not_leader","message":"not the leader, leader is node1 at node1:2221","leader":{"id":"node1","addr":"node1:2221","http_addr":"node1:8221"}}}`,
+		},
+		{
+			name:   "timeout",
+			err:    fmt.Errorf("could not perform apply command via Raft: %w", store.ErrTimeout),
+			status: http.StatusServiceUnavailable,
+			body:   `{"error":{"code":"timeout","message":"could not perform apply command via Raft: timed out committing command"}}`,
+		},
+		{
+			name:   "conflict",
+			err:    fmt.Errorf("FSM error on apply command: %w", store.ErrNotInteger),
+			status: http.StatusConflict,
+			body:   `{"error":{"code":"conflict","message":"FSM error on apply command: conflict with current value: value is not an integer"}}`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &Server{store: &storetest.Fake{ApplyErr: tc.err}}
+			req := httptest.NewRequest(http.MethodPost, "/apply", strings.NewReader("test"))
+			w := httptest.NewRecorder()
+			s.applyHandler(w, req)
+			if w.Result().StatusCode != tc.status {
+				t.Errorf("expected %d, got %d", tc.status, w.Result().StatusCode)
+			}
+			if strings.TrimSpace(w.Body.String()) != tc.body {
+				t.Errorf("expected response body to be `%s`, got `%s`", tc.body, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestApplyHandler_ConditionNotMet(t *testing.T) {
+	s := &Server{store: &storetest.Fake{ApplyResult: &store.Result{Conflict: true, Version: 3}}}
+	req := httptest.NewRequest(http.MethodPost, "/apply", strings.NewReader("test"))
+	w := httptest.NewRecorder()
+	s.applyHandler(w, req)
+	if w.Result().StatusCode != http.StatusConflict {
+		t.Errorf("expected 409 Conflict, got %d", w.Result().StatusCode)
+	}
+
+	s = &Server{store: &storetest.Fake{ApplyResult: &store.Result{Conflict: true}}}
+	w = httptest.NewRecorder()
+	s.applyHandler(w, httptest.NewRequest(http.MethodPost, "/apply", strings.NewReader("test")))
+	if w.Result().StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 Not Found, got %d", w.Result().StatusCode)
+	}
+}
+
+func TestReloadHandler(t *testing.T) {
+	s := NewServer("", &storetest.Fake{}, "admin")
+	mux := s.routes()
+
+	serve := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/config/reload", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := serve("admin"); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 Not Found without a reload function, got %d", w.Code)
+	}
+
+	var err error
+	s.SetReloadFunc(func() (conf.Changes, error) {
+		return conf.Changes{Reloaded: []string{"client-rate"}, RestartRequired: []string{"http-port"}}, err
+	})
+	if w := serve(""); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 Unauthorized without the admin token, got %d", w.Code)
+	}
+
+	w := serve("admin")
+	want := `{"reloaded":["client-rate"],"restart_required":["http-port"]}` + "\n"
+	if w.Code != http.StatusOK || w.Body.String() != want {
+		t.Errorf("expected 200 OK with body %q, got %d %q", want, w.Code, w.Body.String())
+	}
+
+	err = errors.New("invalid setting")
+	if w := serve("admin"); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "invalid setting") {
+		t.Errorf("expected 400 with the reload error, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestApplyHandler_TooLarge(t *testing.T) {
+	m := &storetest.Fake{}
+	s := &Server{store: m}
+	s.SetSizeLimits(16, 4)
+
+	body := `{"op": "set", "key": "a", "value": "` + strings.Repeat("A", commandOverhead) + `"}`
+	req := httptest.NewRequest(http.MethodPost, "/apply", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	s.applyHandler(w, req)
+	if w.Code != http.StatusRequestEntityTooLarge || !strings.Contains(w.Body.String(), `"code":"invalid_argument"`) || m.ApplyData != nil {
+		t.Errorf("expected 413 invalid_argument without applying, got %d %s", w.Code, w.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/apply", strings.NewReader(`{"op": "set", "key": "a", "value": "MTIzNA=="}`))
+	w = httptest.NewRecorder()
+	s.applyHandler(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected 200 OK for a command within the limit, got %d", w.Code)
 	}
 }
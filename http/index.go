@@ -0,0 +1,176 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/thanhqng1510/dbdb/store"
+)
+
+// indexRequest is the body of index create and drop requests.
+type indexRequest struct {
+	Name string `json:"name"`
+
+	// Prefix of the indexed keys and JSON Pointer to the indexed field of
+	// their values, for create requests
+	Prefix string `json:"prefix,omitempty"`
+	Path   string `json:"path,omitempty"`
+}
+
+// indexQueryRequest is the body of index query requests. Values are JSON
+// strings, numbers or booleans.
+type indexQueryRequest struct {
+	Name  string          `json:"name"`
+	Value json.RawMessage `json:"value,omitempty"`
+	Start json.RawMessage `json:"start,omitempty"`
+	End   json.RawMessage `json:"end,omitempty"`
+	Limit int             `json:"limit,omitempty"`
+}
+
+// writeIndexNameRequired writes the error response for an index request without a name.
+func writeIndexNameRequired(w http.ResponseWriter) {
+	writeError(w, http.StatusBadRequest, apiError{
+		Code:    codeInvalidArgument,
+		Message: "Index name must not be empty",
+		Fields:  []store.FieldError{{Field: "name", Message: "is required"}},
+	})
+}
+
+// indexCreateHandler creates a secondary index on a field of the JSON values
+// of the keys with a prefix. The index is built from the current keys and
+// kept up to date by every write.
+func (s *Server) indexCreateHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	var req indexRequest
+	if !s.readJSON(w, r, &req) {
+		return
+	}
+	if req.Name == "" {
+		writeIndexNameRequired(w)
+		return
+	}
+
+	res, ok := s.apply(w, store.Command{Op: store.OpTypeCreateIndex, Key: req.Prefix, Path: req.Path, Index: req.Name})
+	if !ok {
+		return
+	}
+	if !res.Applied {
+		writeError(w, http.StatusConflict, apiError{Code: codeConflict, Message: fmt.Sprintf("Index %s already exists", req.Name)})
+		return
+	}
+	writeJSON(w, store.IndexInfo{Name: req.Name, Prefix: req.Prefix, Path: req.Path, Count: res.Count})
+}
+
+// indexDropHandler drops a secondary index.
+func (s *Server) indexDropHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	var req indexRequest
+	if !s.readJSON(w, r, &req) {
+		return
+	}
+	if req.Name == "" {
+		writeIndexNameRequired(w)
+		return
+	}
+
+	res, ok := s.apply(w, store.Command{Op: store.OpTypeDropIndex, Index: req.Name})
+	if !ok {
+		return
+	}
+	if !res.Applied {
+		writeStoreError(w, store.ErrIndexNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// indexListHandler returns the secondary indexes known to the node.
+func (s *Server) indexListHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	writeJSON(w, struct {
+		Indexes []store.IndexInfo `json:"indexes"`
+	}{s.store.Indexes()})
+}
+
+// indexQueryHandler returns the keys of an index whose indexed value equals
+// value, or lies in [start, end), ordered by value then key. The query is
+// given as query parameters of a GET request, or as a JSON body of a POST
+// request:
+//
+//	{"name": "by_age", "start": 18, "end": 65, "limit": 100}
+//
+// Query parameters that are not valid JSON are taken as strings, so that
+// value=a@example.com and value="a@example.com" are the same query.
+func (s *Server) indexQueryHandler(w http.ResponseWriter, r *http.Request) {
+	var req indexQueryRequest
+	switch r.Method {
+	case http.MethodGet:
+		query := r.URL.Query()
+		req.Name = query.Get("name")
+		req.Value = queryLiteral(r, "value")
+		req.Start = queryLiteral(r, "start")
+		req.End = queryLiteral(r, "end")
+
+		var ok bool
+		if req.Limit, ok = parseLimit(w, r); !ok {
+			return
+		}
+	case http.MethodPost:
+		if !s.readJSON(w, r, &req) {
+			return
+		}
+		if req.Limit < 0 {
+			writeLimitError(w)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeError(w, http.StatusMethodNotAllowed, apiError{Code: codeMethodNotAllowed, Message: "Only GET and POST methods are allowed"})
+		return
+	}
+
+	if req.Name == "" {
+		writeIndexNameRequired(w)
+		return
+	}
+
+	lookups, revision, err := s.store.QueryIndex(req.Name, store.IndexQuery{Value: req.Value, Start: req.Start, End: req.End, Limit: req.Limit})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	rsp := struct {
+		Revision uint64       `json:"revision"`
+		Results  []readResult `json:"results"`
+	}{Revision: revision, Results: make([]readResult, len(lookups))}
+	for i, l := range lookups {
+		rsp.Results[i] = readResult{Key: l.Key, Found: true, Data: l.Entry.Value, Version: l.Entry.Version}
+	}
+	writeJSON(w, rsp)
+}
+
+// queryLiteral returns a query parameter as JSON, nil if it is absent.
+func queryLiteral(r *http.Request, name string) json.RawMessage {
+	query := r.URL.Query()
+	if !query.Has(name) {
+		return nil
+	}
+
+	v := query.Get(name)
+	if json.Valid([]byte(v)) {
+		return json.RawMessage(v)
+	}
+	data, _ := json.Marshal(v)
+	return data
+}
@@ -0,0 +1,130 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/thanhqng1510/dbdb/store"
+	"github.com/thanhqng1510/dbdb/store/storetest"
+)
+
+func TestIndexCreateHandler(t *testing.T) {
+	m := &storetest.Fake{ApplyResult: &store.Result{Applied: true, Count: 3}}
+	s := &Server{store: m}
+
+	req := httptest.NewRequest(http.MethodPost, "/index/create", strings.NewReader(`{"name": "by_email", "prefix": "users/", "path": "/email"}`))
+	w := httptest.NewRecorder()
+	s.indexCreateHandler(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected 200 OK, got %d", w.Code)
+	}
+	if want := `{"name":"by_email","prefix":"users/","path":"/email","count":3}` + "\n"; w.Body.String() != want {
+		t.Errorf("expected body %q, got %q", want, w.Body.String())
+	}
+
+	var c store.Command
+	json.Unmarshal(m.ApplyData, &c)
+	if c.Op != store.OpTypeCreateIndex || c.Key != "users/" || c.Path != "/email" || c.Index != "by_email" {
+		t.Errorf("unexpected command %+v", c)
+	}
+
+	m.ApplyResult = &store.Result{Applied: false}
+	req = httptest.NewRequest(http.MethodPost, "/index/create", strings.NewReader(`{"name": "by_email", "path": "/email"}`))
+	w = httptest.NewRecorder()
+	s.indexCreateHandler(w, req)
+	if w.Code != http.StatusConflict {
+		t.Errorf("expected 409 Conflict for existing index, got %d", w.Code)
+	}
+}
+
+func TestIndexDropHandler(t *testing.T) {
+	m := &storetest.Fake{ApplyResult: &store.Result{Applied: false}}
+	s := &Server{store: m}
+
+	req := httptest.NewRequest(http.MethodPost, "/index/drop", strings.NewReader(`{"name": "missing"}`))
+	w := httptest.NewRecorder()
+	s.indexDropHandler(w, req)
+	if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), codeIndexNotFound) {
+		t.Errorf("expected 404 index_not_found, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestIndexQueryHandler(t *testing.T) {
+	m := &storetest.Fake{
+		Lookups:  []store.Lookup{{Key: "users/a", Entry: store.Entry{Value: []byte("1"), Version: 3}, Found: true}},
+		Revision: 9,
+	}
+	s := &Server{store: m}
+
+	req := httptest.NewRequest(http.MethodGet, "/index/query?name=by_email&value=a@x&limit=5", nil)
+	w := httptest.NewRecorder()
+	s.indexQueryHandler(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected 200 OK, got %d", w.Code)
+	}
+	want := `{"revision":9,"results":[{"key":"users/a","found":true,"data":"MQ==","version":3}]}` + "\n"
+	if got := w.Body.String(); got != want {
+		t.Errorf("expected body %q, got %q", want, got)
+	}
+	if m.QueryName != "by_email" || string(m.Query.Value) != `"a@x"` || m.Query.Limit != 5 {
+		t.Errorf("unexpected query %s %+v", m.QueryName, m.Query)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/index/query", strings.NewReader(`{"name": "by_age", "start": 18, "end": 65}`))
+	w = httptest.NewRecorder()
+	s.indexQueryHandler(w, req)
+	if w.Code != http.StatusOK || string(m.Query.Start) != "18" || string(m.Query.End) != "65" || m.Query.Value != nil {
+		t.Errorf("unexpected query %+v (status %d)", m.Query, w.Code)
+	}
+}
+
+func TestIndexQueryHandler_Errors(t *testing.T) {
+	tests := []struct {
+		url    string
+		err    error
+		status int
+	}{
+		{"/index/query", nil, http.StatusBadRequest},
+		{"/index/query?name=i&limit=-1", nil, http.StatusBadRequest},
+		{"/index/query?name=missing", store.ErrIndexNotFound, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		s := &Server{store: &storetest.Fake{ReadErr: tt.err}}
+
+		req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+		w := httptest.NewRecorder()
+		s.indexQueryHandler(w, req)
+		if w.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.url, tt.status, w.Code)
+		}
+	}
+}
+
+func TestIndexHandlers_RequireAdmin(t *testing.T) {
+	m := &storetest.Fake{ApplyResult: &store.Result{Applied: true}}
+	mux := NewServer("", m, "admin").routes()
+
+	for _, tc := range []struct{ path, body string }{
+		{"/index/create", `{"name": "by_email", "path": "/email"}`},
+		{"/index/drop", `{"name": "by_email"}`},
+		{"/ns/a/index/create", `{"name": "by_email", "path": "/email"}`},
+	} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, tc.path, strings.NewReader(tc.body)))
+		if w.Code != http.StatusUnauthorized || m.ApplyData != nil {
+			t.Errorf("%s: expected 401 Unauthorized without applying, got %d", tc.path, w.Code)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/index/create", strings.NewReader(`{"name": "by_email", "path": "/email"}`))
+	req.Header.Set("Authorization", "Bearer admin")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected 200 OK with the admin token, got %d %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,30 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/thanhqng1510/dbdb/store"
+)
+
+// writeDocument writes the response of a get request on a JSON document. The
+// document is returned as JSON rather than base64, in whole or at the JSON
+// Pointer given by the path query parameter.
+func writeDocument(w http.ResponseWriter, r *http.Request, key string, entry store.Entry) {
+	path := r.URL.Query().Get("path")
+	doc, ok, err := store.ResolvePointer(entry.Value, path)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if !ok {
+		writeError(w, http.StatusNotFound, apiError{Code: codeKeyNotFound, Message: fmt.Sprintf("Path %s of key %s not found", path, key)})
+		return
+	}
+
+	writeJSON(w, struct {
+		Type store.ValueType `json:"type"`
+		Doc  json.RawMessage `json:"doc"`
+	}{entry.Type, doc})
+}
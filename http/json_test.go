@@ -0,0 +1,39 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/thanhqng1510/dbdb/store"
+	"github.com/thanhqng1510/dbdb/store/storetest"
+)
+
+func TestGetHandler_Document(t *testing.T) {
+	entry := store.Entry{Type: store.TypeJSON, Value: []byte(`{"a":{"b":[1,2]}}`)}
+
+	tests := []struct {
+		url    string
+		status int
+		want   string
+	}{
+		{"/get?key=doc", http.StatusOK, `{"type":"json","doc":{"a":{"b":[1,2]}}}`},
+		{"/get?key=doc&path=/a/b/1", http.StatusOK, `{"type":"json","doc":2}`},
+		{"/get?key=doc&path=/a/c", http.StatusNotFound, ""},
+		{"/get?key=doc&path=a", http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		s := &Server{store: &storetest.Fake{Entries: map[string]store.Entry{"doc": entry}}}
+
+		req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+		w := httptest.NewRecorder()
+		s.getHandler(w, req)
+		if w.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.url, tt.status, w.Code)
+		}
+		if tt.want != "" && w.Body.String() != tt.want+"\n" {
+			t.Errorf("%s: expected body %s, got %s", tt.url, tt.want, w.Body.String())
+		}
+	}
+}
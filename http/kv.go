@@ -0,0 +1,222 @@
+package http
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/thanhqng1510/dbdb/store"
+)
+
+// kvHandler serves keys as resources at /v1/kv/{key} (and /kv/{key}), with
+// the raw value as the body. The ETag of a key is its version, which can be
+// used in If-Match and If-None-Match headers for conditional requests.
+//
+//	GET, HEAD  read the value
+//	PUT        store the request body as the value, with an optional ttl query parameter in milliseconds
+//	DELETE     delete the key
+func (s *Server) kvHandler(w http.ResponseWriter, r *http.Request) {
+	key, ok := strings.CutPrefix(r.URL.Path, "/v1/kv/")
+	if !ok {
+		key = strings.TrimPrefix(r.URL.Path, "/kv/")
+	}
+	if key == "" {
+		writeError(w, http.StatusBadRequest, apiError{
+			Code:    codeInvalidArgument,
+			Message: "Key must not be empty",
+			Fields:  []store.FieldError{{Field: "key", Message: "is required"}},
+		})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		s.kvGet(w, r, key)
+	case http.MethodPut:
+		s.kvPut(w, r, key)
+	case http.MethodDelete:
+		s.kvDelete(w, r, key)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT, DELETE")
+		writeError(w, http.StatusMethodNotAllowed, apiError{Code: codeMethodNotAllowed, Message: "Only GET, HEAD, PUT and DELETE methods are allowed"})
+	}
+}
+
+func (s *Server) kvGet(w http.ResponseWriter, r *http.Request, key string) {
+	entry, exist := s.store.Get(key)
+	if !exist {
+		writeError(w, http.StatusNotFound, apiError{Code: codeKeyNotFound, Message: fmt.Sprintf("Key %s not found", key)})
+		return
+	}
+	if entry.Type != store.TypeString {
+		writeStoreError(w, store.ErrWrongType)
+		return
+	}
+
+	w.Header().Set("ETag", etag(entry.Version))
+	if inm := r.Header.Get("If-None-Match"); inm != "" && matchETag(inm, entry.Version, true) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(len(entry.Value)))
+	if r.Method == http.MethodHead {
+		return
+	}
+	w.Write(entry.Value)
+}
+
+func (s *Server) kvPut(w http.ResponseWriter, r *http.Request, key string) {
+	defer r.Body.Close()
+
+	c := store.Command{Op: store.OpTypeSet, Key: key}
+	if !setPreconditions(w, r, &c) {
+		return
+	}
+
+	if ttl := r.URL.Query().Get("ttl"); ttl != "" {
+		var err error
+		if c.Ttl, err = strconv.ParseInt(ttl, 10, 64); err != nil {
+			writeError(w, http.StatusBadRequest, apiError{
+				Code:    codeInvalidArgument,
+				Message: "Invalid ttl parameter",
+				Fields:  []store.FieldError{{Field: "ttl", Message: "must be an integer"}},
+			})
+			return
+		}
+	}
+
+	limitBody(w, r, s.maxValueSize)
+	value, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("Could not read request body for put operation: %s", err)
+		writeBodyError(w, err)
+		return
+	}
+	c.Value = value
+
+	res, ok := s.kvApply(w, c)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("ETag", etag(res.Version))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) kvDelete(w http.ResponseWriter, r *http.Request, key string) {
+	c := store.Command{Op: store.OpTypeDelete, Key: key}
+	if !setPreconditions(w, r, &c) {
+		return
+	}
+
+	res, ok := s.kvApply(w, c)
+	if !ok {
+		return
+	}
+	if !res.Applied {
+		writeError(w, http.StatusNotFound, apiError{Code: codeKeyNotFound, Message: fmt.Sprintf("Key %s not found", key)})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// kvApply applies a command, writing the error response and returning
+// false if it fails or its precondition does not hold.
+func (s *Server) kvApply(w http.ResponseWriter, c store.Command) (*store.Result, bool) {
+	res, ok := s.apply(w, c)
+	if !ok {
+		return nil, false
+	}
+
+	if res.Conflict {
+		msg := "Precondition failed, key does not exist"
+		if res.Version != 0 {
+			w.Header().Set("ETag", etag(res.Version))
+			msg = fmt.Sprintf("Precondition failed, current version of key is %d", res.Version)
+		}
+		writeError(w, http.StatusPreconditionFailed, apiError{Code: codePreconditionFailed, Message: msg})
+		return nil, false
+	}
+	return res, true
+}
+
+// setPreconditions translates the If-Match and If-None-Match headers of a
+// write into the condition of the command. It writes an error response and
+// returns false if they cannot be expressed as a condition.
+func setPreconditions(w http.ResponseWriter, r *http.Request, c *store.Command) bool {
+	im, inm := r.Header.Get("If-Match"), r.Header.Get("If-None-Match")
+
+	switch {
+	case im != "" && inm != "":
+		writeError(w, http.StatusBadRequest, apiError{Code: codeInvalidArgument, Message: "If-Match and If-None-Match cannot be combined"})
+		return false
+	case im == "*":
+		c.Cond = store.CondExists
+	case im != "":
+		version, ok := parseETag(im, false)
+		if !ok {
+			// No current version can match a weak or malformed entity tag
+			writeError(w, http.StatusPreconditionFailed, apiError{Code: codePreconditionFailed, Message: "If-Match must be a single strong entity tag or *"})
+			return false
+		}
+		c.Version = version
+	case inm == "*":
+		c.Cond = store.CondNotExists
+	case inm != "":
+		writeError(w, http.StatusBadRequest, apiError{Code: codeInvalidArgument, Message: "Only If-None-Match: * is supported for writes"})
+		return false
+	}
+
+	// The condition of delete commands is expressed with versions only
+	if c.Op == store.OpTypeDelete && c.Cond != "" {
+		if c.Cond == store.CondNotExists {
+			writeError(w, http.StatusBadRequest, apiError{Code: codeInvalidArgument, Message: "If-None-Match is not supported for deletes"})
+			return false
+		}
+		c.Cond = ""
+	}
+	return true
+}
+
+// etag returns the entity tag of a key version.
+func etag(version uint64) string {
+	return `"` + strconv.FormatUint(version, 10) + `"`
+}
+
+// parseETag parses a single entity tag into a key version. Weak entity tags
+// are only accepted if weak is true.
+func parseETag(s string, weak bool) (uint64, bool) {
+	s = strings.TrimSpace(s)
+	if rest, ok := strings.CutPrefix(s, "W/"); ok {
+		if !weak {
+			return 0, false
+		}
+		s = rest
+	}
+
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return 0, false
+	}
+	version, err := strconv.ParseUint(s[1:len(s)-1], 10, 64)
+	return version, err == nil
+}
+
+// matchETag reports whether a comma separated list of entity tags, or *,
+// matches the given version.
+func matchETag(list string, version uint64, weak bool) bool {
+	if strings.TrimSpace(list) == "*" {
+		return true
+	}
+	for _, tag := range strings.Split(list, ",") {
+		if v, ok := parseETag(tag, weak); ok && v == version {
+			return true
+		}
+	}
+	return false
+}
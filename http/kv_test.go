@@ -0,0 +1,204 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/thanhqng1510/dbdb/store"
+	"github.com/thanhqng1510/dbdb/store/storetest"
+)
+
+func TestKvHandler_Get(t *testing.T) {
+	s := &Server{store: &storetest.Fake{Entries: map[string]store.Entry{"foo": {Value: []byte("\x00\xffbin")}}}}
+	req := httptest.NewRequest(http.MethodGet, "/kv/foo", nil)
+	w := httptest.NewRecorder()
+	s.kvHandler(w, req)
+	if w.Result().StatusCode != http.StatusOK {
+		t.Errorf("expected 200 OK, got %d", w.Result().StatusCode)
+	}
+	if w.Body.String() != "\x00\xffbin" {
+		t.Errorf("expected raw value in response body, got %q", w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/octet-stream" {
+		t.Errorf("expected application/octet-stream content type, got %q", ct)
+	}
+}
+
+func TestKvHandler_Put(t *testing.T) {
+	m := &storetest.Fake{}
+	s := &Server{store: m}
+	req := httptest.NewRequest(http.MethodPut, "/kv/a%2Fb", strings.NewReader("\x00\xffbin"))
+	req.Header.Set("Content-Type", "application/octet-stream")
+	w := httptest.NewRecorder()
+	s.kvHandler(w, req)
+	if w.Result().StatusCode != http.StatusNoContent {
+		t.Errorf("expected 204 No Content, got %d", w.Result().StatusCode)
+	}
+
+	var c store.Command
+	if err := json.Unmarshal(m.ApplyData, &c); err != nil {
+		t.Fatalf("could not decode applied command: %v", err)
+	}
+	if c.Op != store.OpTypeSet || c.Key != "a/b" || string(c.Value) != "\x00\xffbin" {
+		t.Errorf("unexpected applied command %+v", c)
+	}
+}
+
+func TestKvHandler_Errors(t *testing.T) {
+	s := &Server{store: &storetest.Fake{}}
+
+	w := httptest.NewRecorder()
+	s.kvHandler(w, httptest.NewRequest(http.MethodGet, "/kv/missing", nil))
+	if w.Result().StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 Not Found, got %d", w.Result().StatusCode)
+	}
+
+	w = httptest.NewRecorder()
+	s.kvHandler(w, httptest.NewRequest(http.MethodGet, "/kv/", nil))
+	if w.Result().StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 Bad Request, got %d", w.Result().StatusCode)
+	}
+
+	w = httptest.NewRecorder()
+	s.kvHandler(w, httptest.NewRequest(http.MethodPost, "/kv/foo", nil))
+	if w.Result().StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("expected 405 Method Not Allowed, got %d", w.Result().StatusCode)
+	}
+}
+
+func TestKvHandler_V1Prefix(t *testing.T) {
+	m := &storetest.Fake{}
+	s := &Server{store: m}
+	w := httptest.NewRecorder()
+	s.kvHandler(w, httptest.NewRequest(http.MethodPut, "/v1/kv/foo", strings.NewReader("bar")))
+	if w.Result().StatusCode != http.StatusNoContent {
+		t.Errorf("expected 204 No Content, got %d", w.Result().StatusCode)
+	}
+
+	var c store.Command
+	json.Unmarshal(m.ApplyData, &c)
+	if c.Key != "foo" {
+		t.Errorf("expected key 'foo', got '%s'", c.Key)
+	}
+}
+
+func TestKvHandler_ETag(t *testing.T) {
+	s := &Server{store: &storetest.Fake{Entries: map[string]store.Entry{"foo": {Value: []byte("bar"), Version: 7}}}}
+
+	w := httptest.NewRecorder()
+	s.kvHandler(w, httptest.NewRequest(http.MethodHead, "/v1/kv/foo", nil))
+	if w.Result().StatusCode != http.StatusOK {
+		t.Errorf("expected 200 OK, got %d", w.Result().StatusCode)
+	}
+	if etag := w.Header().Get("ETag"); etag != `"7"` {
+		t.Errorf(`expected ETag "7", got %s`, etag)
+	}
+	if w.Body.Len() != 0 || w.Header().Get("Content-Length") != "3" {
+		t.Errorf("expected empty body with Content-Length 3, got %q and %s", w.Body.String(), w.Header().Get("Content-Length"))
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/kv/foo", nil)
+	req.Header.Set("If-None-Match", `"6", W/"7"`)
+	w = httptest.NewRecorder()
+	s.kvHandler(w, req)
+	if w.Result().StatusCode != http.StatusNotModified {
+		t.Errorf("expected 304 Not Modified, got %d", w.Result().StatusCode)
+	}
+}
+
+func TestKvHandler_ConditionalWrites(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		header  string
+		value   string
+		want    store.Command
+		wantErr int
+	}{
+		{name: "if-match version", method: http.MethodPut, header: "If-Match", value: `"3"`,
+			want: store.Command{Op: store.OpTypeSet, Key: "foo", Value: []byte{}, Version: 3}},
+		{name: "if-match any", method: http.MethodPut, header: "If-Match", value: "*",
+			want: store.Command{Op: store.OpTypeSet, Key: "foo", Value: []byte{}, Cond: store.CondExists}},
+		{name: "if-none-match any", method: http.MethodPut, header: "If-None-Match", value: "*",
+			want: store.Command{Op: store.OpTypeSet, Key: "foo", Value: []byte{}, Cond: store.CondNotExists}},
+		{name: "delete if-match version", method: http.MethodDelete, header: "If-Match", value: `"3"`,
+			want: store.Command{Op: store.OpTypeDelete, Key: "foo", Version: 3}},
+		{name: "weak if-match", method: http.MethodPut, header: "If-Match", value: `W/"3"`, wantErr: http.StatusPreconditionFailed},
+		{name: "if-none-match version", method: http.MethodPut, header: "If-None-Match", value: `"3"`, wantErr: http.StatusBadRequest},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			m := &storetest.Fake{}
+			s := &Server{store: m}
+			req := httptest.NewRequest(tc.method, "/v1/kv/foo", nil)
+			req.Header.Set(tc.header, tc.value)
+			w := httptest.NewRecorder()
+			s.kvHandler(w, req)
+
+			if tc.wantErr != 0 {
+				if w.Result().StatusCode != tc.wantErr {
+					t.Errorf("expected %d, got %d", tc.wantErr, w.Result().StatusCode)
+				}
+				if m.ApplyData != nil {
+					t.Errorf("expected no command applied, got %s", m.ApplyData)
+				}
+				return
+			}
+
+			var c store.Command
+			if err := json.Unmarshal(m.ApplyData, &c); err != nil {
+				t.Fatalf("could not decode applied command: %v", err)
+			}
+			if c.Op != tc.want.Op || c.Version != tc.want.Version || c.Cond != tc.want.Cond {
+				t.Errorf("expected command %+v, got %+v", tc.want, c)
+			}
+		})
+	}
+}
+
+func TestKvHandler_PreconditionFailed(t *testing.T) {
+	s := &Server{store: &storetest.Fake{ApplyResult: &store.Result{Conflict: true, Version: 9}}}
+	req := httptest.NewRequest(http.MethodPut, "/v1/kv/foo", strings.NewReader("bar"))
+	req.Header.Set("If-Match", `"3"`)
+	w := httptest.NewRecorder()
+	s.kvHandler(w, req)
+	if w.Result().StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("expected 412 Precondition Failed, got %d", w.Result().StatusCode)
+	}
+	if etag := w.Header().Get("ETag"); etag != `"9"` {
+		t.Errorf(`expected current ETag "9", got %s`, etag)
+	}
+}
+
+func TestKvHandler_DeleteMissing(t *testing.T) {
+	s := &Server{store: &storetest.Fake{ApplyResult: &store.Result{Applied: false}}}
+	w := httptest.NewRecorder()
+	s.kvHandler(w, httptest.NewRequest(http.MethodDelete, "/v1/kv/foo", nil))
+	if w.Result().StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 Not Found, got %d", w.Result().StatusCode)
+	}
+}
+
+func TestKvHandler_PutTooLarge(t *testing.T) {
+	m := &storetest.Fake{}
+	s := &Server{store: m}
+	s.SetSizeLimits(16, 4)
+
+	req := httptest.NewRequest(http.MethodPut, "/kv/a", strings.NewReader("12345"))
+	w := httptest.NewRecorder()
+	s.kvHandler(w, req)
+	if w.Code != http.StatusRequestEntityTooLarge || !strings.Contains(w.Body.String(), `"code":"invalid_argument"`) || m.ApplyData != nil {
+		t.Errorf("expected 413 invalid_argument without applying, got %d %s", w.Code, w.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodPut, "/kv/a", strings.NewReader("1234"))
+	w = httptest.NewRecorder()
+	s.kvHandler(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Errorf("expected 204 No Content for a value of the maximum size, got %d", w.Code)
+	}
+}
@@ -0,0 +1,73 @@
+package http
+
+import (
+	"net/http"
+
+	"github.com/thanhqng1510/dbdb/store"
+)
+
+// leaseRequest is the body of lease requests.
+type leaseRequest struct {
+	// Time to live in milliseconds, for grant requests
+	Ttl int64 `json:"ttl,omitempty"`
+
+	Lease uint64 `json:"lease,omitempty"`
+}
+
+// leaseGrantHandler grants a lease with a time to live in milliseconds.
+// Keys set with the lease are deleted when it expires or is revoked.
+func (s *Server) leaseGrantHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	var req leaseRequest
+	if !s.readJSON(w, r, &req) {
+		return
+	}
+
+	res, ok := s.apply(w, store.Command{Op: store.OpTypeGrant, Ttl: req.Ttl})
+	if !ok {
+		return
+	}
+	writeJSON(w, leaseRequest{Ttl: req.Ttl, Lease: res.Lease})
+}
+
+// leaseKeepAliveHandler extends a lease by its time to live.
+func (s *Server) leaseKeepAliveHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	var req leaseRequest
+	if !s.readJSON(w, r, &req) {
+		return
+	}
+
+	if _, ok := s.apply(w, store.Command{Op: store.OpTypeKeepAlive, Lease: req.Lease}); !ok {
+		return
+	}
+	writeJSON(w, leaseRequest{Lease: req.Lease})
+}
+
+// leaseRevokeHandler revokes a lease and deletes the keys attached to it.
+func (s *Server) leaseRevokeHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	var req leaseRequest
+	if !s.readJSON(w, r, &req) {
+		return
+	}
+
+	res, ok := s.apply(w, store.Command{Op: store.OpTypeRevoke, Lease: req.Lease})
+	if !ok {
+		return
+	}
+	if !res.Applied {
+		writeError(w, http.StatusNotFound, apiError{Code: codeLeaseNotFound, Message: store.ErrLeaseNotFound.Error()})
+		return
+	}
+	writeJSON(w, leaseRequest{Lease: req.Lease})
+}
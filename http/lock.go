@@ -0,0 +1,117 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/thanhqng1510/dbdb/store"
+)
+
+// Locks are stored as keys with this prefix, attached to the lease of
+// their holder so that they are released if the holder dies.
+const lockKeyPrefix = "_locks/"
+
+// lockRequest is the body of lock requests.
+type lockRequest struct {
+	Name  string `json:"name"`
+	Lease uint64 `json:"lease"`
+}
+
+// lockResponse is the body of a successful lock acquisition.
+type lockResponse struct {
+	Name  string `json:"name"`
+	Lease uint64 `json:"lease"`
+
+	// Fencing token, the Raft index at which the lock was acquired. Tokens
+	// increase with every acquisition, so that resources can reject
+	// requests from holders that lost the lock.
+	Token uint64 `json:"token"`
+}
+
+// readLockRequest decodes and checks the body of a lock request. It writes
+// an error response and returns false if the request is invalid.
+func (s *Server) readLockRequest(w http.ResponseWriter, r *http.Request) (lockRequest, bool) {
+	var req lockRequest
+	if !s.readJSON(w, r, &req) {
+		return lockRequest{}, false
+	}
+
+	var fields []store.FieldError
+	if req.Name == "" {
+		fields = append(fields, store.FieldError{Field: "name", Message: "is required"})
+	}
+	if req.Lease == 0 {
+		fields = append(fields, store.FieldError{Field: "lease", Message: "is required"})
+	}
+	if len(fields) > 0 {
+		writeError(w, http.StatusBadRequest, apiError{Code: codeInvalidArgument, Message: "Name and lease are required", Fields: fields})
+		return lockRequest{}, false
+	}
+	return req, true
+}
+
+// lockAcquireHandler acquires a lock for a lease, if it is not held by
+// another lease. Acquiring a lock already held by the lease returns the
+// same fencing token.
+func (s *Server) lockAcquireHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	req, ok := s.readLockRequest(w, r)
+	if !ok {
+		return
+	}
+
+	key := lockKeyPrefix + req.Name
+	holder := strconv.AppendUint(nil, req.Lease, 10)
+
+	res, ok := s.apply(w, store.Command{
+		Op:      store.OpTypeTxn,
+		Compare: []store.Compare{{Key: key, Target: store.CompareExists, Op: store.CompareEqual, Exists: false}},
+		Then:    []store.Command{{Op: store.OpTypeSet, Key: key, Value: holder, Lease: req.Lease}},
+	})
+	if !ok {
+		return
+	}
+
+	if res.Succeeded {
+		writeJSON(w, lockResponse{Name: req.Name, Lease: req.Lease, Token: res.Results[0].Version})
+		return
+	}
+
+	if e, exist := s.store.Get(key); exist && string(e.Value) == string(holder) {
+		writeJSON(w, lockResponse{Name: req.Name, Lease: req.Lease, Token: e.Version})
+		return
+	}
+	writeError(w, http.StatusConflict, apiError{Code: codeConflict, Message: fmt.Sprintf("Lock %s is held by another lease", req.Name)})
+}
+
+// lockReleaseHandler releases a lock held by a lease.
+func (s *Server) lockReleaseHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	req, ok := s.readLockRequest(w, r)
+	if !ok {
+		return
+	}
+
+	key := lockKeyPrefix + req.Name
+	res, ok := s.apply(w, store.Command{
+		Op:      store.OpTypeTxn,
+		Compare: []store.Compare{{Key: key, Target: store.CompareValue, Op: store.CompareEqual, Value: strconv.AppendUint(nil, req.Lease, 10)}},
+		Then:    []store.Command{{Op: store.OpTypeDelete, Key: key}},
+	})
+	if !ok {
+		return
+	}
+
+	if !res.Succeeded {
+		writeError(w, http.StatusConflict, apiError{Code: codeConflict, Message: fmt.Sprintf("Lock %s is not held by lease %d", req.Name, req.Lease)})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,103 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/thanhqng1510/dbdb/store"
+	"github.com/thanhqng1510/dbdb/store/storetest"
+)
+
+func TestLockAcquireHandler(t *testing.T) {
+	m := &storetest.Fake{ApplyResult: &store.Result{Applied: true, Succeeded: true, Results: []store.Result{{Applied: true, Version: 12}}}}
+	s := &Server{store: m}
+
+	w := httptest.NewRecorder()
+	s.lockAcquireHandler(w, httptest.NewRequest(http.MethodPost, "/lock/acquire", strings.NewReader(`{"name": "jobs", "lease": 7}`)))
+	if want := `{"name":"jobs","lease":7,"token":12}`; w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != want {
+		t.Errorf("expected 200 OK with body `%s`, got %d `%s`", want, w.Code, w.Body.String())
+	}
+
+	var c store.Command
+	if err := json.Unmarshal(m.ApplyData, &c); err != nil {
+		t.Fatalf("could not decode applied command: %v", err)
+	}
+	want := store.Command{
+		Op:      store.OpTypeTxn,
+		Compare: []store.Compare{{Key: "_locks/jobs", Target: store.CompareExists, Op: store.CompareEqual}},
+		Then:    []store.Command{{Op: store.OpTypeSet, Key: "_locks/jobs", Value: []byte("7"), Lease: 7}},
+	}
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("expected command %+v, got %+v", want, c)
+	}
+}
+
+func TestLockAcquireHandler_Held(t *testing.T) {
+	m := &storetest.Fake{ApplyResult: &store.Result{Applied: true}, Entries: map[string]store.Entry{
+		lockKeyPrefix + "jobs": {Value: []byte("7"), Version: 10},
+	}}
+	s := &Server{store: m}
+
+	// Held by the same lease
+	w := httptest.NewRecorder()
+	s.lockAcquireHandler(w, httptest.NewRequest(http.MethodPost, "/lock/acquire", strings.NewReader(`{"name": "jobs", "lease": 7}`)))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"token":10`) {
+		t.Errorf("expected 200 OK with token 10, got %d `%s`", w.Code, w.Body.String())
+	}
+
+	// Held by another lease
+	w = httptest.NewRecorder()
+	s.lockAcquireHandler(w, httptest.NewRequest(http.MethodPost, "/lock/acquire", strings.NewReader(`{"name": "jobs", "lease": 8}`)))
+	if w.Code != http.StatusConflict {
+		t.Errorf("expected 409 Conflict, got %d", w.Code)
+	}
+}
+
+func TestLockReleaseHandler(t *testing.T) {
+	s := &Server{store: &storetest.Fake{ApplyResult: &store.Result{Applied: true, Succeeded: true}}}
+	w := httptest.NewRecorder()
+	s.lockReleaseHandler(w, httptest.NewRequest(http.MethodPost, "/lock/release", strings.NewReader(`{"name": "jobs", "lease": 7}`)))
+	if w.Code != http.StatusNoContent {
+		t.Errorf("expected 204 No Content, got %d", w.Code)
+	}
+
+	s = &Server{store: &storetest.Fake{ApplyResult: &store.Result{Applied: true}}}
+	w = httptest.NewRecorder()
+	s.lockReleaseHandler(w, httptest.NewRequest(http.MethodPost, "/lock/release", strings.NewReader(`{"name": "jobs", "lease": 7}`)))
+	if w.Code != http.StatusConflict {
+		t.Errorf("expected 409 Conflict, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	s.lockReleaseHandler(w, httptest.NewRequest(http.MethodPost, "/lock/release", strings.NewReader(`{"name": "jobs"}`)))
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `{"field":"lease","message":"is required"}`) {
+		t.Errorf("expected 400 for missing lease, got %d `%s`", w.Code, w.Body.String())
+	}
+}
+
+func TestLeaseHandlers(t *testing.T) {
+	s := &Server{store: &storetest.Fake{ApplyResult: &store.Result{Applied: true, Lease: 5}}}
+	w := httptest.NewRecorder()
+	s.leaseGrantHandler(w, httptest.NewRequest(http.MethodPost, "/lease/grant", strings.NewReader(`{"ttl": 10000}`)))
+	if want := `{"ttl":10000,"lease":5}`; w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != want {
+		t.Errorf("expected 200 OK with body `%s`, got %d `%s`", want, w.Code, w.Body.String())
+	}
+
+	s = &Server{store: &storetest.Fake{ApplyErr: store.ErrLeaseNotFound}}
+	w = httptest.NewRecorder()
+	s.leaseKeepAliveHandler(w, httptest.NewRequest(http.MethodPost, "/lease/keepalive", strings.NewReader(`{"lease": 5}`)))
+	if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), `"code":"lease_not_found"`) {
+		t.Errorf("expected 404 lease_not_found, got %d `%s`", w.Code, w.Body.String())
+	}
+
+	s = &Server{store: &storetest.Fake{ApplyResult: &store.Result{Applied: false}}}
+	w = httptest.NewRecorder()
+	s.leaseRevokeHandler(w, httptest.NewRequest(http.MethodPost, "/lease/revoke", strings.NewReader(`{"lease": 5}`)))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for missing lease, got %d", w.Code)
+	}
+}
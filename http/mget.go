@@ -0,0 +1,84 @@
+package http
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/thanhqng1510/dbdb/store"
+)
+
+// readResult is the result for one key of an mget or range request.
+type readResult struct {
+	Key   string `json:"key"`
+	Found bool   `json:"found"`
+
+	// Values are returned as base64
+	Data    []byte `json:"data,omitempty"`
+	Version uint64 `json:"version,omitempty"`
+}
+
+// mgetHandler reads several keys at once. The keys are given as repeated key
+// query parameters of a GET request, or as a JSON body of a POST request:
+//
+//	{"keys": ["a", "b"]}
+//
+// All values are read at the same applied Raft index, which is returned
+// along with one result per requested key, in order.
+func (s *Server) mgetHandler(w http.ResponseWriter, r *http.Request) {
+	var keys []string
+	switch r.Method {
+	case http.MethodGet:
+		keys = r.URL.Query()["key"]
+	case http.MethodPost:
+		var req struct {
+			Keys []string `json:"keys"`
+		}
+		if !s.readJSON(w, r, &req) {
+			return
+		}
+		keys = req.Keys
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeError(w, http.StatusMethodNotAllowed, apiError{Code: codeMethodNotAllowed, Message: "Only GET and POST methods are allowed"})
+		return
+	}
+
+	if len(keys) == 0 {
+		writeError(w, http.StatusBadRequest, apiError{
+			Code:    codeInvalidArgument,
+			Message: "At least one key is required",
+			Fields:  []store.FieldError{{Field: "keys", Message: "is required"}},
+		})
+		return
+	}
+	for _, key := range keys {
+		if key == "" {
+			writeError(w, http.StatusBadRequest, apiError{
+				Code:    codeInvalidArgument,
+				Message: "Keys must not be empty",
+				Fields:  []store.FieldError{{Field: "keys", Message: "must not contain empty keys"}},
+			})
+			return
+		}
+	}
+
+	lookups, index := s.store.MGet(keys)
+
+	rsp := struct {
+		Index   uint64       `json:"index"`
+		Results []readResult `json:"results"`
+	}{Index: index, Results: make([]readResult, len(lookups))}
+	for i, l := range lookups {
+		rsp.Results[i] = readResult{Key: l.Key, Found: l.Found}
+		if l.Found {
+			rsp.Results[i].Data = l.Entry.Value
+			rsp.Results[i].Version = l.Entry.Version
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(rsp); err != nil {
+		log.Printf("Could not encode mget response: %s", err)
+	}
+}
@@ -0,0 +1,66 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/thanhqng1510/dbdb/store"
+	"github.com/thanhqng1510/dbdb/store/storetest"
+)
+
+func TestMgetHandler(t *testing.T) {
+	s := &Server{store: &storetest.Fake{Entries: map[string]store.Entry{"a": {Value: []byte("1"), Version: 1}}, Revision: 7}}
+
+	want := `{"index":7,"results":[{"key":"a","found":true,"data":"MQ==","version":1},{"key":"b","found":false}]}` + "\n"
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/mget?key=a&key=b", nil),
+		httptest.NewRequest(http.MethodPost, "/mget", strings.NewReader(`{"keys": ["a", "b"]}`)),
+	} {
+		w := httptest.NewRecorder()
+		s.mgetHandler(w, req)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: expected 200 OK, got %d", req.Method, w.Code)
+		}
+		if got := w.Body.String(); got != want {
+			t.Errorf("%s: expected body %q, got %q", req.Method, want, got)
+		}
+	}
+}
+
+func TestMgetHandler_Errors(t *testing.T) {
+	s := &Server{store: &storetest.Fake{}}
+
+	tests := []struct {
+		req    *http.Request
+		status int
+	}{
+		{httptest.NewRequest(http.MethodGet, "/mget", nil), http.StatusBadRequest},
+		{httptest.NewRequest(http.MethodGet, "/mget?key=a&key=", nil), http.StatusBadRequest},
+		{httptest.NewRequest(http.MethodPost, "/mget", strings.NewReader(`{"keys":`)), http.StatusBadRequest},
+		{httptest.NewRequest(http.MethodDelete, "/mget?key=a", nil), http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		s.mgetHandler(w, tt.req)
+		if w.Code != tt.status {
+			t.Errorf("%s %s: expected %d, got %d", tt.req.Method, tt.req.URL, tt.status, w.Code)
+		}
+	}
+}
+
+func TestReadJSON_TooLarge(t *testing.T) {
+	s := &Server{store: &storetest.Fake{}}
+	s.SetSizeLimits(16, 4)
+
+	// Every JSON body is limited to the size of a command
+	body := `{"keys": ["` + strings.Repeat("a", 2*commandOverhead) + `"]}`
+	for _, h := range []http.HandlerFunc{s.mgetHandler, s.leaseGrantHandler, s.lockAcquireHandler, s.campaignHandler} {
+		w := httptest.NewRecorder()
+		h(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+		if w.Code != http.StatusRequestEntityTooLarge || !strings.Contains(w.Body.String(), `"code":"invalid_argument"`) {
+			t.Errorf("expected 413 invalid_argument, got %d", w.Code)
+		}
+	}
+}
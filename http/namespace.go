@@ -0,0 +1,294 @@
+package http
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/thanhqng1510/dbdb/store"
+)
+
+// namespaceHeader names the namespace of the keys of a request, if the
+// request is not made under /ns/{namespace}/.
+const namespaceHeader = "X-Dbdb-Namespace"
+
+type namespaceKey struct{}
+
+// namespaceRoute serves the key endpoints under /ns/{namespace}/ in the
+// namespace named by the first segment of the path.
+func namespaceRoute(keys http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ns, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/ns/"), "/")
+		if ns == "" {
+			http.NotFound(w, r)
+			return
+		}
+
+		r2 := r.Clone(context.WithValue(r.Context(), namespaceKey{}, ns))
+		r2.URL.Path = "/" + rest
+		r2.URL.RawPath = ""
+		keys.ServeHTTP(w, r2)
+	}
+}
+
+// scoped runs a key handler in the namespace of the request after checking
+// that the request is allowed in it and within the rate limits. Requests
+// without a namespace run in the default namespace, which is open to every
+// client.
+func (s *Server) scoped(h func(*Server, http.ResponseWriter, *http.Request)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ns, _ := r.Context().Value(namespaceKey{}).(string)
+		if ns == "" {
+			ns = r.Header.Get(namespaceHeader)
+		}
+		if ns == "" {
+			if s.allowRequest(w, r, ns) {
+				h(s, w, r)
+			}
+			return
+		}
+
+		info, ok := s.store.Namespace(ns)
+		if !ok {
+			writeError(w, http.StatusNotFound, apiError{Code: codeNamespaceNotFound, Message: fmt.Sprintf("Namespace %s does not exist", ns)})
+			return
+		}
+		token := bearerToken(r)
+		if info.TokenHash != "" && !s.isAdmin(token) && !tokenMatches(token, info.TokenHash) {
+			writeUnauthenticated(w, "Missing or invalid token for namespace "+ns)
+			return
+		}
+		if !s.allowRequest(w, r, ns) {
+			return
+		}
+
+		scoped := *s
+		scoped.store = s.store.In(ns)
+		h(&scoped, w, r)
+	}
+}
+
+// admin requires the admin token for a handler, if one is configured.
+func (s *Server) admin(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.isAdmin(bearerToken(r)) {
+			writeUnauthenticated(w, "Missing or invalid admin token")
+			return
+		}
+		h(w, r)
+	}
+}
+
+// allowApply requires the admin token for the cluster-wide namespace and
+// compact commands sent to /apply, and for the index commands.
+// It writes an error response and returns false if the request is not
+// allowed.
+func (s *Server) allowApply(w http.ResponseWriter, r *http.Request, body []byte) bool {
+	var c struct {
+		Op store.OpType `json:"op"`
+	}
+	// Malformed commands are reported by the store
+	if json.Unmarshal(body, &c) != nil {
+		return true
+	}
+
+	switch c.Op {
+	case store.OpTypeNsCreate, store.OpTypeNsUpdate, store.OpTypeNsDelete, store.OpTypeCompact,
+		store.OpTypeCreateIndex, store.OpTypeDropIndex:
+		if !s.isAdmin(bearerToken(r)) {
+			writeUnauthenticated(w, "Missing or invalid admin token")
+			return false
+		}
+	}
+	return true
+}
+
+// isAdmin reports whether a token is the admin token. Every token is when no
+// admin token is configured.
+func (s *Server) isAdmin(token string) bool {
+	admin := *s.adminToken.Load()
+	return admin == "" || subtle.ConstantTimeCompare([]byte(token), []byte(admin)) == 1
+}
+
+// bearerToken returns the bearer token of the Authorization header of a
+// request, or an empty string.
+func bearerToken(r *http.Request) string {
+	scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// tokenMatches reports whether a token has the given hex encoded SHA-256 hash.
+func tokenMatches(token, hash string) bool {
+	if token == "" {
+		return false
+	}
+	sum := sha256.Sum256([]byte(token))
+	return subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum[:])), []byte(hash)) == 1
+}
+
+// newToken generates a random token and returns it along with its hash.
+func newToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token := hex.EncodeToString(b)
+	sum := sha256.Sum256([]byte(token))
+	return token, hex.EncodeToString(sum[:]), nil
+}
+
+func writeUnauthenticated(w http.ResponseWriter, message string) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="dbdb"`)
+	writeError(w, http.StatusUnauthorized, apiError{Code: codeUnauthenticated, Message: message})
+}
+
+// namespaceRequest is the body of namespace create, update and delete requests.
+type namespaceRequest struct {
+	Name string `json:"name"`
+
+	// Quotas on the number of keys and bytes of keys and values, zero for no
+	// limit. Updates replace both quotas.
+	MaxKeys  int64 `json:"max_keys,omitempty"`
+	MaxBytes int64 `json:"max_bytes,omitempty"`
+
+	// Whether to replace the token of the namespace, for update requests
+	RotateToken bool `json:"rotate_token,omitempty"`
+}
+
+// namespaceResponse describes a namespace, along with its token when it is
+// created or its token is rotated. Tokens are only ever returned then.
+type namespaceResponse struct {
+	store.NamespaceInfo
+	Token string `json:"token,omitempty"`
+}
+
+// writeNamespaceNameRequired writes the error response for a namespace request without a name.
+func writeNamespaceNameRequired(w http.ResponseWriter) {
+	writeError(w, http.StatusBadRequest, apiError{
+		Code:    codeInvalidArgument,
+		Message: "Namespace name must not be empty",
+		Fields:  []store.FieldError{{Field: "name", Message: "is required"}},
+	})
+}
+
+// namespaceCreateHandler creates a namespace with a new token, which clients
+// send as a bearer token to access its keys.
+func (s *Server) namespaceCreateHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	var req namespaceRequest
+	if !s.readJSON(w, r, &req) {
+		return
+	}
+	if req.Name == "" {
+		writeNamespaceNameRequired(w)
+		return
+	}
+
+	token, hash, err := newToken()
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	res, ok := s.apply(w, store.Command{Op: store.OpTypeNsCreate, Key: req.Name, MaxKeys: req.MaxKeys, MaxBytes: req.MaxBytes, TokenHash: hash})
+	if !ok {
+		return
+	}
+	if !res.Applied {
+		writeError(w, http.StatusConflict, apiError{Code: codeConflict, Message: fmt.Sprintf("Namespace %s already exists", req.Name)})
+		return
+	}
+	writeJSON(w, namespaceResponse{
+		NamespaceInfo: store.NamespaceInfo{Name: req.Name, MaxKeys: req.MaxKeys, MaxBytes: req.MaxBytes},
+		Token:         token,
+	})
+}
+
+// namespaceUpdateHandler changes the quotas of a namespace and optionally
+// rotates its token.
+func (s *Server) namespaceUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	var req namespaceRequest
+	if !s.readJSON(w, r, &req) {
+		return
+	}
+	if req.Name == "" {
+		writeNamespaceNameRequired(w)
+		return
+	}
+
+	c := store.Command{Op: store.OpTypeNsUpdate, Key: req.Name, MaxKeys: req.MaxKeys, MaxBytes: req.MaxBytes}
+	var token string
+	if req.RotateToken {
+		var err error
+		if token, c.TokenHash, err = newToken(); err != nil {
+			writeStoreError(w, err)
+			return
+		}
+	}
+	res, ok := s.apply(w, c)
+	if !ok {
+		return
+	}
+	if !res.Applied {
+		writeError(w, http.StatusNotFound, apiError{Code: codeNamespaceNotFound, Message: fmt.Sprintf("Namespace %s does not exist", req.Name)})
+		return
+	}
+
+	// Usage is only known once the update is applied locally
+	info, _ := s.store.Namespace(req.Name)
+	info.Name, info.MaxKeys, info.MaxBytes = req.Name, req.MaxKeys, req.MaxBytes
+	writeJSON(w, namespaceResponse{NamespaceInfo: info, Token: token})
+}
+
+// namespaceDeleteHandler deletes a namespace along with its keys, queues and
+// indexes.
+func (s *Server) namespaceDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	var req namespaceRequest
+	if !s.readJSON(w, r, &req) {
+		return
+	}
+	if req.Name == "" {
+		writeNamespaceNameRequired(w)
+		return
+	}
+
+	res, ok := s.apply(w, store.Command{Op: store.OpTypeNsDelete, Key: req.Name})
+	if !ok {
+		return
+	}
+	if !res.Applied {
+		writeError(w, http.StatusNotFound, apiError{Code: codeNamespaceNotFound, Message: fmt.Sprintf("Namespace %s does not exist", req.Name)})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// namespaceListHandler lists the namespaces and the usage of their quotas.
+func (s *Server) namespaceListHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	writeJSON(w, struct {
+		Namespaces []store.NamespaceInfo `json:"namespaces"`
+	}{s.store.Namespaces()})
+}
@@ -0,0 +1,178 @@
+package http
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/thanhqng1510/dbdb/store"
+	"github.com/thanhqng1510/dbdb/store/storetest"
+)
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestScoped(t *testing.T) {
+	m := &storetest.Fake{
+		Entries:        map[string]store.Entry{"foo": {Value: []byte("bar")}},
+		NamespaceInfos: map[string]store.NamespaceInfo{"a": {Name: "a", TokenHash: hashToken("secret")}},
+	}
+	mux := NewServer("", m, "admin").routes()
+
+	tests := []struct {
+		name   string
+		path   string
+		header string
+		token  string
+		code   int
+		ns     string
+	}{
+		{"default namespace", "/kv/foo", "", "", http.StatusOK, ""},
+		{"path", "/ns/a/kv/foo", "", "secret", http.StatusOK, "a"},
+		{"header", "/get?key=foo", "a", "secret", http.StatusOK, "a"},
+		{"admin token", "/ns/a/get?key=foo", "", "admin", http.StatusOK, "a"},
+		{"missing token", "/ns/a/kv/foo", "", "", http.StatusUnauthorized, ""},
+		{"wrong token", "/kv/foo", "a", "other", http.StatusUnauthorized, ""},
+		{"missing namespace", "/ns/b/kv/foo", "", "secret", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		m.InNamespace = ""
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		if tt.header != "" {
+			req.Header.Set(namespaceHeader, tt.header)
+		}
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != tt.code {
+			t.Errorf("%s: expected %d, got %d %s", tt.name, tt.code, w.Code, w.Body.String())
+		}
+		if m.InNamespace != tt.ns {
+			t.Errorf("%s: expected namespace %q, got %q", tt.name, tt.ns, m.InNamespace)
+		}
+	}
+}
+
+func TestNamespaceCreateHandler(t *testing.T) {
+	m := &storetest.Fake{ApplyResult: &store.Result{Applied: true}}
+	mux := NewServer("", m, "admin").routes()
+
+	body := `{"name": "a", "max_keys": 10}`
+	req := httptest.NewRequest(http.MethodPost, "/namespace/create", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("expected 401 Unauthorized without the admin token, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/namespace/create", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer admin")
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 OK, got %d %s", w.Code, w.Body.String())
+	}
+
+	var res struct {
+		Name    string `json:"name"`
+		MaxKeys int64  `json:"max_keys"`
+		Token   string `json:"token"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &res)
+	var c store.Command
+	json.Unmarshal(m.ApplyData, &c)
+	if c.Op != store.OpTypeNsCreate || c.Key != "a" || c.MaxKeys != 10 {
+		t.Errorf("unexpected command %+v", c)
+	}
+	if res.Name != "a" || res.MaxKeys != 10 || res.Token == "" || c.TokenHash != hashToken(res.Token) {
+		t.Errorf("expected the token of the namespace, got %+v", res)
+	}
+
+	m.ApplyResult = &store.Result{Applied: false}
+	req = httptest.NewRequest(http.MethodPost, "/namespace/create", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer admin")
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusConflict {
+		t.Errorf("expected 409 Conflict for existing namespace, got %d", w.Code)
+	}
+}
+
+func TestNamespaceDeleteHandler(t *testing.T) {
+	m := &storetest.Fake{ApplyResult: &store.Result{Applied: false}}
+	s := &Server{store: m}
+
+	req := httptest.NewRequest(http.MethodPost, "/namespace/delete", strings.NewReader(`{"name": "a"}`))
+	w := httptest.NewRecorder()
+	s.namespaceDeleteHandler(w, req)
+	if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), codeNamespaceNotFound) {
+		t.Errorf("expected 404 namespace_not_found, got %d %s", w.Code, w.Body.String())
+	}
+
+	m.ApplyResult = &store.Result{Applied: true}
+	w = httptest.NewRecorder()
+	s.namespaceDeleteHandler(w, httptest.NewRequest(http.MethodPost, "/namespace/delete", strings.NewReader(`{"name": "a"}`)))
+	if w.Code != http.StatusNoContent {
+		t.Errorf("expected 204 No Content, got %d", w.Code)
+	}
+}
+
+func TestApplyHandler_ClusterOpRequiresAdmin(t *testing.T) {
+	for _, body := range []string{
+		`{"op": "nsdelete", "key": "a"}`,
+		`{"op": "compact", "revision": 2}`,
+		`{"op": "createindex", "key": "users/", "index": "by_email", "path": "/email"}`,
+		`{"op": "dropindex", "index": "by_email"}`,
+	} {
+		m := &storetest.Fake{ApplyResult: &store.Result{Applied: true}}
+		s := NewServer("", m, "admin")
+
+		req := httptest.NewRequest(http.MethodPost, "/apply", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		s.applyHandler(w, req)
+		if w.Code != http.StatusUnauthorized || m.ApplyData != nil {
+			t.Errorf("%s: expected 401 Unauthorized without applying, got %d", body, w.Code)
+		}
+
+		req = httptest.NewRequest(http.MethodPost, "/apply", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer admin")
+		w = httptest.NewRecorder()
+		s.applyHandler(w, req)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: expected 200 OK with the admin token, got %d", body, w.Code)
+		}
+	}
+}
+
+func TestNamespace_DefaultCannotReadOthers(t *testing.T) {
+	st := storetest.NewStore(t)
+	storetest.Apply(t, st, `{"op": "nscreate", "key": "team", "token_hash": "`+hashToken("secret")+`"}`)
+	storetest.Apply(t, st.In("team"), `{"op": "set", "key": "secret", "value": "MQ=="}`)
+	mux := NewServer("", st, "admin").routes()
+
+	tests := []struct {
+		target string
+		status int
+		body   string
+	}{
+		{"/get?key=%00team%00secret", http.StatusNotFound, "key_not_found"},
+		{"/get?key=%00team%00secret&revision=1", http.StatusNotFound, "key_not_found"},
+		{"/v1/kv/%00team%00secret", http.StatusNotFound, "key_not_found"},
+		{"/mget?key=%00team%00secret", http.StatusOK, `"found":false`},
+	}
+	for _, tc := range tests {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tc.target, nil))
+		if w.Code != tc.status || !strings.Contains(w.Body.String(), tc.body) {
+			t.Errorf("%s: expected %d with %s, got %d %s", tc.target, tc.status, tc.body, w.Code, w.Body.String())
+		}
+	}
+}
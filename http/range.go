@@ -0,0 +1,95 @@
+package http
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/thanhqng1510/dbdb/store"
+)
+
+// rangeHandler scans the keys in [start, end) in lexical order. The end and
+// limit query parameters are optional, and the revision parameter reads the
+// keys as of an earlier revision. The revision the keys were read at is
+// returned, so that further pages can be read at the same revision.
+func (s *Server) rangeHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	query := r.URL.Query()
+
+	revision, ok := parseRevision(w, r)
+	if !ok {
+		return
+	}
+
+	limit, ok := parseLimit(w, r)
+	if !ok {
+		return
+	}
+
+	lookups, revision, err := s.store.Range(query.Get("start"), query.Get("end"), revision, limit)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	rsp := struct {
+		Revision uint64       `json:"revision"`
+		Results  []readResult `json:"results"`
+	}{Revision: revision, Results: make([]readResult, len(lookups))}
+	for i, l := range lookups {
+		rsp.Results[i] = readResult{Key: l.Key, Found: true, Data: l.Entry.Value, Version: l.Entry.Version}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(rsp); err != nil {
+		log.Printf("Could not encode range response: %s", err)
+	}
+}
+
+// parseRevision returns the revision query parameter, zero if absent. It writes
+// an error response and returns false if the parameter is not a valid revision.
+func parseRevision(w http.ResponseWriter, r *http.Request) (uint64, bool) {
+	v := r.URL.Query().Get("revision")
+	if v == "" {
+		return 0, true
+	}
+
+	revision, err := strconv.ParseUint(v, 10, 64)
+	if err != nil || revision == 0 {
+		writeError(w, http.StatusBadRequest, apiError{
+			Code:    codeInvalidArgument,
+			Message: "Revision must be a positive integer",
+			Fields:  []store.FieldError{{Field: "revision", Message: "must be a positive integer"}},
+		})
+		return 0, false
+	}
+	return revision, true
+}
+
+// parseLimit returns the limit query parameter, zero if absent. It writes an
+// error response and returns false if the parameter is not a valid limit.
+func parseLimit(w http.ResponseWriter, r *http.Request) (int, bool) {
+	v := r.URL.Query().Get("limit")
+	if v == "" {
+		return 0, true
+	}
+
+	limit, err := strconv.Atoi(v)
+	if err != nil || limit < 0 {
+		writeLimitError(w)
+		return 0, false
+	}
+	return limit, true
+}
+
+func writeLimitError(w http.ResponseWriter) {
+	writeError(w, http.StatusBadRequest, apiError{
+		Code:    codeInvalidArgument,
+		Message: "Limit must be a non-negative integer",
+		Fields:  []store.FieldError{{Field: "limit", Message: "must be a non-negative integer"}},
+	})
+}
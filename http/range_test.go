@@ -0,0 +1,50 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/thanhqng1510/dbdb/store"
+	"github.com/thanhqng1510/dbdb/store/storetest"
+)
+
+func TestRangeHandler(t *testing.T) {
+	m := &storetest.Fake{
+		Lookups:  []store.Lookup{{Key: "a", Entry: store.Entry{Value: []byte("1"), Version: 3}, Found: true}},
+		Revision: 9,
+	}
+	s := &Server{store: m}
+
+	req := httptest.NewRequest(http.MethodGet, "/range?start=a&end=b&limit=10", nil)
+	w := httptest.NewRecorder()
+	s.rangeHandler(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected 200 OK, got %d", w.Code)
+	}
+	want := `{"revision":9,"results":[{"key":"a","found":true,"data":"MQ==","version":3}]}` + "\n"
+	if got := w.Body.String(); got != want {
+		t.Errorf("expected body %q, got %q", want, got)
+	}
+}
+
+func TestRangeHandler_Errors(t *testing.T) {
+	tests := []struct {
+		url    string
+		err    error
+		status int
+	}{
+		{"/range?limit=-1", nil, http.StatusBadRequest},
+		{"/range?revision=0", nil, http.StatusBadRequest},
+		{"/range?revision=3", store.ErrCompacted, http.StatusGone},
+		{"/range?revision=100", store.ErrFutureRevision, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		s := &Server{store: &storetest.Fake{ReadErr: tt.err}}
+		w := httptest.NewRecorder()
+		s.rangeHandler(w, httptest.NewRequest(http.MethodGet, tt.url, nil))
+		if w.Code != tt.status {
+			t.Errorf("%s: expected %d, got %d", tt.url, tt.status, w.Code)
+		}
+	}
+}
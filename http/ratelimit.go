@@ -0,0 +1,260 @@
+package http
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/thanhqng1510/dbdb/store"
+)
+
+// How often the buckets that have refilled, which behave as new ones, are
+// dropped.
+const sweepInterval = time.Minute
+
+// RateLimit is a token bucket limit: requests are allowed at Rate per second
+// on average, in bursts of up to Burst requests. A zero rate means no limit,
+// and a zero burst a burst of one second of requests.
+type RateLimit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst,omitempty"`
+}
+
+func (l RateLimit) burst() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return max(1, math.Ceil(l.Rate))
+}
+
+// RateLimits are the limits on the key requests of each client, identified by
+// its IP address, and of each namespace.
+type RateLimits struct {
+	Client    RateLimit `json:"client"`
+	Namespace RateLimit `json:"namespace"`
+
+	// Limits of specific namespaces, overriding Namespace. The default
+	// namespace is named "".
+	Namespaces map[string]RateLimit `json:"namespaces,omitempty"`
+}
+
+// validate returns a ValidationError listing the negative limits.
+func (ls RateLimits) validate() error {
+	var fields []store.FieldError
+	check := func(field string, l RateLimit) {
+		if l.Rate < 0 {
+			fields = append(fields, store.FieldError{Field: field + ".rate", Message: "must not be negative"})
+		}
+		if l.Burst < 0 {
+			fields = append(fields, store.FieldError{Field: field + ".burst", Message: "must not be negative"})
+		}
+	}
+	check("client", ls.Client)
+	check("namespace", ls.Namespace)
+	for ns, l := range ls.Namespaces {
+		check("namespaces."+ns, l)
+	}
+
+	if len(fields) > 0 {
+		return &store.ValidationError{Fields: fields}
+	}
+	return nil
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// refill adds the tokens accrued since the last request to a bucket.
+func (b *bucket) refill(l RateLimit, now time.Time) {
+	b.tokens = min(l.burst(), b.tokens+now.Sub(b.last).Seconds()*l.Rate)
+	b.last = now
+}
+
+// wait returns how long until a bucket holds a token.
+func (b *bucket) wait(l RateLimit) time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / l.Rate * float64(time.Second))
+}
+
+// limiter holds the token buckets of the clients and namespaces.
+type limiter struct {
+	mu         sync.Mutex
+	limits     RateLimits
+	clients    map[string]*bucket
+	namespaces map[string]*bucket
+
+	// Current time, replaced in tests
+	now func() time.Time
+}
+
+func newLimiter(limits RateLimits) *limiter {
+	return &limiter{
+		limits:     limits,
+		clients:    make(map[string]*bucket),
+		namespaces: make(map[string]*bucket),
+		now:        time.Now,
+	}
+}
+
+func (lm *limiter) get() RateLimits {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	return lm.limits
+}
+
+// set replaces the limits. Buckets keep their tokens, up to the new bursts.
+func (lm *limiter) set(limits RateLimits) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	lm.limits = limits
+}
+
+// allow takes a token from the buckets of a client and a namespace if both
+// hold one. Otherwise it takes none and returns how long until both do.
+func (lm *limiter) allow(client, ns string) (time.Duration, bool) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	now := lm.now()
+	var wait time.Duration
+	var taken []*bucket
+	for _, b := range []struct {
+		buckets map[string]*bucket
+		key     string
+		limit   RateLimit
+	}{{lm.clients, client, lm.limits.Client}, {lm.namespaces, ns, lm.namespaceLimit(ns)}} {
+		if b.limit.Rate == 0 {
+			continue
+		}
+
+		bk, ok := b.buckets[b.key]
+		if !ok {
+			bk = &bucket{tokens: b.limit.burst(), last: now}
+			b.buckets[b.key] = bk
+		}
+		bk.refill(b.limit, now)
+		wait = max(wait, bk.wait(b.limit))
+		taken = append(taken, bk)
+	}
+
+	if wait > 0 {
+		return wait, false
+	}
+	for _, bk := range taken {
+		bk.tokens--
+	}
+	return 0, true
+}
+
+// namespaceLimit returns the limit of a namespace.
+func (lm *limiter) namespaceLimit(ns string) RateLimit {
+	if l, ok := lm.limits.Namespaces[ns]; ok {
+		return l
+	}
+	return lm.limits.Namespace
+}
+
+// sweep drops the buckets that have refilled and those no longer limited.
+func (lm *limiter) sweep() {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	now := lm.now()
+	full := func(bk *bucket, l RateLimit) bool {
+		return l.Rate == 0 || bk.tokens+now.Sub(bk.last).Seconds()*l.Rate >= l.burst()
+	}
+	for client, bk := range lm.clients {
+		if full(bk, lm.limits.Client) {
+			delete(lm.clients, client)
+		}
+	}
+	for ns, bk := range lm.namespaces {
+		if full(bk, lm.namespaceLimit(ns)) {
+			delete(lm.namespaces, ns)
+		}
+	}
+}
+
+// sweepBuckets sweeps the buckets every sweepInterval. This is a blocking call.
+func (lm *limiter) sweepBuckets() {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		lm.sweep()
+	}
+}
+
+// clientID identifies the client of a request by its IP address.
+func clientID(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// allowRequest takes a token for a request of a client in a namespace. It
+// writes an error response and returns false if the request is over a limit.
+func (s *Server) allowRequest(w http.ResponseWriter, r *http.Request, ns string) bool {
+	if s.limiter == nil {
+		return true
+	}
+
+	wait, ok := s.limiter.allow(clientID(r), ns)
+	if ok {
+		return true
+	}
+
+	seconds := int(math.Ceil(wait.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	writeError(w, http.StatusTooManyRequests, apiError{
+		Code:    codeRateLimited,
+		Message: fmt.Sprintf("Rate limit exceeded, retry in %d seconds", seconds),
+	})
+	return false
+}
+
+// RateLimits returns the rate limits of the server.
+func (s *Server) RateLimits() RateLimits {
+	return s.limiter.get()
+}
+
+// SetRateLimits replaces the rate limits of the server.
+func (s *Server) SetRateLimits(limits RateLimits) error {
+	if err := limits.validate(); err != nil {
+		return err
+	}
+	s.limiter.set(limits)
+	return nil
+}
+
+// rateLimitsHandler returns the rate limits on GET and replaces them on POST.
+// Limits are local to the node receiving the request.
+func (s *Server) rateLimitsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var limits RateLimits
+		if !s.readJSON(w, r, &limits) {
+			return
+		}
+		if err := s.SetRateLimits(limits); err != nil {
+			writeStoreError(w, err)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeError(w, http.StatusMethodNotAllowed, apiError{Code: codeMethodNotAllowed, Message: "Only GET and POST methods are allowed"})
+		return
+	}
+
+	writeJSON(w, s.limiter.get())
+}
@@ -0,0 +1,138 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thanhqng1510/dbdb/store"
+	"github.com/thanhqng1510/dbdb/store/storetest"
+)
+
+func TestLimiter(t *testing.T) {
+	now := time.Unix(0, 0)
+	lm := newLimiter(RateLimits{
+		Client:     RateLimit{Rate: 2, Burst: 2},
+		Namespace:  RateLimit{Rate: 100},
+		Namespaces: map[string]RateLimit{"a": {Rate: 1}},
+	})
+	lm.now = func() time.Time { return now }
+
+	for i := range 2 {
+		if _, ok := lm.allow("c1", ""); !ok {
+			t.Fatalf("request %d: expected burst to be allowed", i)
+		}
+	}
+	if wait, ok := lm.allow("c1", ""); ok || wait != 500*time.Millisecond {
+		t.Errorf("expected client to wait 500ms, got %s (allowed %t)", wait, ok)
+	}
+	if _, ok := lm.allow("c2", ""); !ok {
+		t.Errorf("expected other client to be allowed")
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if _, ok := lm.allow("c1", ""); !ok {
+		t.Errorf("expected client to be allowed after refill")
+	}
+
+	// The namespace limit overrides the default one
+	if _, ok := lm.allow("c3", "a"); !ok {
+		t.Fatalf("expected first request to the namespace to be allowed")
+	}
+	if wait, ok := lm.allow("c4", "a"); ok || wait != time.Second {
+		t.Errorf("expected namespace to wait 1s, got %s (allowed %t)", wait, ok)
+	}
+
+	// Denied requests do not take tokens from the other bucket
+	if _, ok := lm.allow("c4", ""); !ok {
+		t.Errorf("expected client to keep its tokens")
+	}
+
+	lm.set(RateLimits{})
+	for range 10 {
+		if _, ok := lm.allow("c1", "a"); !ok {
+			t.Fatalf("expected requests to be allowed without limits")
+		}
+	}
+}
+
+func TestLimiter_Sweep(t *testing.T) {
+	now := time.Unix(0, 0)
+	lm := newLimiter(RateLimits{Client: RateLimit{Rate: 1}, Namespace: RateLimit{Rate: 1}})
+	lm.now = func() time.Time { return now }
+
+	lm.allow("c1", "a")
+	now = now.Add(500 * time.Millisecond)
+	lm.allow("c2", "b")
+	now = now.Add(500 * time.Millisecond)
+
+	lm.sweep()
+	if _, ok := lm.clients["c1"]; ok || len(lm.clients) != 1 {
+		t.Errorf("expected only the refilled client bucket to be dropped, got %v", lm.clients)
+	}
+	if _, ok := lm.namespaces["a"]; ok || len(lm.namespaces) != 1 {
+		t.Errorf("expected only the refilled namespace bucket to be dropped, got %v", lm.namespaces)
+	}
+
+	lm.set(RateLimits{Client: RateLimit{Rate: 1}})
+	lm.sweep()
+	if len(lm.namespaces) != 0 {
+		t.Errorf("expected the buckets of unlimited namespaces to be dropped, got %v", lm.namespaces)
+	}
+}
+
+func TestScoped_RateLimited(t *testing.T) {
+	s := NewServer("", &storetest.Fake{Entries: map[string]store.Entry{"foo": {Value: []byte("bar")}}}, "")
+	s.SetRateLimits(RateLimits{Client: RateLimit{Rate: 0.5}})
+	mux := s.routes()
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/kv/foo", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 OK, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/kv/foo", nil))
+	if w.Code != http.StatusTooManyRequests || !strings.Contains(w.Body.String(), codeRateLimited) {
+		t.Errorf("expected 429 rate_limited, got %d %s", w.Code, w.Body.String())
+	}
+	if retry := w.Header().Get("Retry-After"); retry != "2" {
+		t.Errorf("expected Retry-After 2, got %q", retry)
+	}
+}
+
+func TestRateLimitsHandler(t *testing.T) {
+	s := NewServer("", &storetest.Fake{}, "admin")
+	mux := s.routes()
+
+	body := `{"client": {"rate": 10, "burst": 20}, "namespaces": {"a": {"rate": 5}}}`
+	req := httptest.NewRequest(http.MethodPost, "/ratelimit", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 Unauthorized without the admin token, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/ratelimit", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer admin")
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	want := `{"client":{"rate":10,"burst":20},"namespace":{"rate":0},"namespaces":{"a":{"rate":5}}}` + "\n"
+	if w.Code != http.StatusOK || w.Body.String() != want {
+		t.Errorf("expected 200 OK with body %q, got %d %q", want, w.Code, w.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/ratelimit", strings.NewReader(`{"namespace": {"rate": -1}}`))
+	req.Header.Set("Authorization", "Bearer admin")
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `"field":"namespace.rate"`) {
+		t.Errorf("expected 400 on namespace.rate, got %d %s", w.Code, w.Body.String())
+	}
+	if got := s.limiter.get(); got.Client.Rate != 10 {
+		t.Errorf("expected invalid limits not to be applied, got %+v", got)
+	}
+}
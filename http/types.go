@@ -0,0 +1,108 @@
+package http
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/thanhqng1510/dbdb/store"
+)
+
+// writeCollection writes the response of a get request on a hash, list or set.
+//
+//	hash  all fields, or the value of the field query parameter
+//	list  all elements, or those between the start and stop query parameters
+//	set   all members, or whether the member query parameter is a member
+func writeCollection(w http.ResponseWriter, r *http.Request, key string, entry store.Entry) {
+	query := r.URL.Query()
+
+	// Values are returned as base64
+	switch entry.Type {
+	case store.TypeHash:
+		if query.Has("field") {
+			field := query.Get("field")
+			v, ok := entry.Hash[field]
+			if !ok {
+				writeError(w, http.StatusNotFound, apiError{Code: codeKeyNotFound, Message: fmt.Sprintf("Field %s of key %s not found", field, key)})
+				return
+			}
+			writeJSON(w, struct {
+				Data []byte `json:"data"`
+			}{v})
+			return
+		}
+		writeJSON(w, struct {
+			Type store.ValueType   `json:"type"`
+			Hash map[string][]byte `json:"hash"`
+		}{entry.Type, entry.Hash})
+	case store.TypeList:
+		start, stop, ok := listBounds(w, r, len(entry.List))
+		if !ok {
+			return
+		}
+		writeJSON(w, struct {
+			Type store.ValueType `json:"type"`
+			List [][]byte        `json:"list"`
+		}{entry.Type, entry.List[start:stop]})
+	case store.TypeSet:
+		if query.Has("member") {
+			_, ok := entry.Set[query.Get("member")]
+			writeJSON(w, struct {
+				Member bool `json:"member"`
+			}{ok})
+			return
+		}
+
+		members := make([][]byte, 0, len(entry.Set))
+		for _, m := range slices.Sorted(maps.Keys(entry.Set)) {
+			members = append(members, []byte(m))
+		}
+		writeJSON(w, struct {
+			Type store.ValueType `json:"type"`
+			Set  [][]byte        `json:"set"`
+		}{entry.Type, members})
+	default:
+		writeStoreError(w, store.ErrWrongType)
+	}
+}
+
+// listBounds returns the slice bounds of a list of length n for the start and
+// stop query parameters, which are inclusive indexes that count from the end
+// of the list if negative, as in Redis LRANGE. It writes an error response and
+// returns false if they are not integers.
+func listBounds(w http.ResponseWriter, r *http.Request, n int) (int, int, bool) {
+	start, stop := 0, -1
+	for _, p := range []struct {
+		name string
+		v    *int
+	}{{"start", &start}, {"stop", &stop}} {
+		s := r.URL.Query().Get(p.name)
+		if s == "" {
+			continue
+		}
+		v, err := strconv.Atoi(s)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, apiError{
+				Code:    codeInvalidArgument,
+				Message: fmt.Sprintf("Parameter %s must be an integer", p.name),
+				Fields:  []store.FieldError{{Field: p.name, Message: "must be an integer"}},
+			})
+			return 0, 0, false
+		}
+		*p.v = v
+	}
+
+	if start < 0 {
+		start = max(n+start, 0)
+	}
+	if stop < 0 {
+		stop += n
+	}
+	stop = min(stop+1, n)
+	if start >= stop {
+		return 0, 0, true
+	}
+	return start, stop, true
+}
@@ -0,0 +1,106 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/thanhqng1510/dbdb/store"
+	"github.com/thanhqng1510/dbdb/store/storetest"
+)
+
+func TestGetHandler_Collections(t *testing.T) {
+	tests := []struct {
+		entry  store.Entry
+		url    string
+		status int
+		want   string
+	}{
+		{
+			entry:  store.Entry{Type: store.TypeHash, Hash: map[string][]byte{"a": []byte("1")}},
+			url:    "/get?key=h",
+			status: http.StatusOK,
+			want:   `{"type":"hash","hash":{"a":"MQ=="}}`,
+		},
+		{
+			entry:  store.Entry{Type: store.TypeHash, Hash: map[string][]byte{"a": []byte("1")}},
+			url:    "/get?key=h&field=a",
+			status: http.StatusOK,
+			want:   `{"data":"MQ=="}`,
+		},
+		{
+			entry:  store.Entry{Type: store.TypeHash, Hash: map[string][]byte{"a": []byte("1")}},
+			url:    "/get?key=h&field=b",
+			status: http.StatusNotFound,
+		},
+		{
+			entry:  store.Entry{Type: store.TypeList, List: [][]byte{[]byte("a"), []byte("b"), []byte("c")}},
+			url:    "/get?key=l&start=1&stop=-1",
+			status: http.StatusOK,
+			want:   `{"type":"list","list":["Yg==","Yw=="]}`,
+		},
+		{
+			entry:  store.Entry{Type: store.TypeList, List: [][]byte{[]byte("a")}},
+			url:    "/get?key=l&start=x",
+			status: http.StatusBadRequest,
+		},
+		{
+			entry:  store.Entry{Type: store.TypeSet, Set: map[string]struct{}{"b": {}, "a": {}}},
+			url:    "/get?key=s",
+			status: http.StatusOK,
+			want:   `{"type":"set","set":["YQ==","Yg=="]}`,
+		},
+		{
+			entry:  store.Entry{Type: store.TypeSet, Set: map[string]struct{}{"a": {}}},
+			url:    "/get?key=s&member=a",
+			status: http.StatusOK,
+			want:   `{"member":true}`,
+		},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+		s := &Server{store: &storetest.Fake{Entries: map[string]store.Entry{req.URL.Query().Get("key"): tt.entry}}}
+
+		w := httptest.NewRecorder()
+		s.getHandler(w, req)
+		if w.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.url, tt.status, w.Code)
+		}
+		if tt.want != "" && w.Body.String() != tt.want+"\n" {
+			t.Errorf("%s: expected body %s, got %s", tt.url, tt.want, w.Body.String())
+		}
+	}
+}
+
+func TestListBounds(t *testing.T) {
+	tests := []struct {
+		query       string
+		start, stop int
+	}{
+		{"", 0, 5},
+		{"?start=1&stop=2", 1, 3},
+		{"?start=-2", 3, 5},
+		{"?start=-10&stop=100", 0, 5},
+		{"?start=3&stop=1", 0, 0},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/get"+tt.query, nil)
+		start, stop, ok := listBounds(httptest.NewRecorder(), req, 5)
+		if !ok || start != tt.start || stop != tt.stop {
+			t.Errorf("%q: expected [%d:%d], got [%d:%d]", tt.query, tt.start, tt.stop, start, stop)
+		}
+	}
+}
+
+func TestKvHandler_WrongType(t *testing.T) {
+	s := &Server{store: &storetest.Fake{Entries: map[string]store.Entry{"h": {Type: store.TypeHash}}}}
+
+	req := httptest.NewRequest(http.MethodGet, "/kv/h", nil)
+	w := httptest.NewRecorder()
+	s.kvHandler(w, req)
+	if w.Code != http.StatusConflict {
+		t.Errorf("expected 409 Conflict, got %d", w.Code)
+	}
+}
@@ -1,39 +1,56 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"path"
+	"sync"
+	"syscall"
 
 	"github.com/thanhqng1510/dbdb/conf"
 	"github.com/thanhqng1510/dbdb/http"
+	"github.com/thanhqng1510/dbdb/memcache"
+	"github.com/thanhqng1510/dbdb/resp"
 	"github.com/thanhqng1510/dbdb/store"
 )
 
 func main() {
 	cfg, err := conf.GetConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
 	if err != nil {
 		log.Fatalf("Failed to get configuration: %v", err)
 	}
-	
-	raftDataDir := path.Join("data", fmt.Sprintf("%s-raft", cfg.Id))
-
-	hostname, err := os.Hostname()
-	if err != nil {
-		log.Fatalf("Failed to get hostname: %v", err)
+	if cfg.PrintConfig {
+		if err := conf.Print(os.Stdout, cfg); err != nil {
+			log.Fatalf("Failed to print configuration: %v", err)
+		}
+		return
 	}
-	if hostname == "" {
-		log.Fatal("Hostname is empty. Please set the hostname for this machine.")
+	if cfg.File != "" {
+		log.Printf("Read configuration file %s", cfg.File)
 	}
+	
+	raftDataDir := path.Join(cfg.DataDir, fmt.Sprintf("%s-raft", cfg.Id))
 
 	storeCfg := store.Config{
 		NodeID:            cfg.Id,
 		RaftDir:           raftDataDir,
-		RaftAddr:          "0.0.0.0:"+cfg.RaftPort,
-		RaftAdvertiseAddr: hostname + ":" + cfg.RaftPort,
+		RaftAddr:          cfg.RaftBind,
+		RaftAdvertiseAddr: cfg.RaftAdvertise,
 		Bootstrap:         cfg.Bootstrap,
 		JoinAddr:          cfg.JoinAddr,
+		HttpAdvertiseAddr: cfg.HttpAdvertise,
+		MaxKeySize:        cfg.MaxKeySize,
+		MaxValueSize:      cfg.MaxValueSize,
+		MaxBatchSize:      cfg.MaxBatchSize,
+		BatchLinger:       cfg.BatchLinger,
+		Raft:              cfg.Raft,
 	}
 
 	store, err := store.NewStore(storeCfg)
@@ -41,19 +58,16 @@ func main() {
 		log.Fatalf("Failed to create dbdb store: %v", err)
 	}
 	
-	log.Printf("Starting dbdb node %s. Raft: %s. Bootstrap: %t. Join: %s",
-		storeCfg.NodeID, storeCfg.RaftAddr, storeCfg.Bootstrap, storeCfg.JoinAddr)
+	log.Printf("Starting dbdb node %s. Raft: %s (advertised as %s). HTTP: %s (advertised as %s). Bootstrap: %t. Join: %s",
+		storeCfg.NodeID, storeCfg.RaftAddr, storeCfg.RaftAdvertiseAddr, cfg.HttpBind, cfg.HttpAdvertise, storeCfg.Bootstrap, storeCfg.JoinAddr)
 
 	// TODO: unit tests and integration tests
 	// TODO: sharding support
 	// TODO: support multiple raft clusters
 	// TODO: automate cluster membership using service discovery
-	// TODO: authentication
-
 	// TODO: backup and restore
 	// TODO: issue leader remove itself
 	// TODO: multiple keys in a single Raft request
-	// TODO: do not allow set empty key
 	// TODO: allow to send request to any nodes
 	// TODO: option to get data from all nodes or just the leader
 	// TODO: support read-index protocol
@@ -64,9 +78,83 @@ func main() {
 	Leader			Yes											Only if partitioned or lost leadership
 	*/
 
-	httpServer := http.NewServer(":"+cfg.HttpPort, store)
+	if cfg.RedisPort != "" {
+		respServer := resp.NewServer(":"+cfg.RedisPort, store)
+		respServer.SetSizeLimits(cfg.MaxKeySize, cfg.MaxValueSize)
+		go func() {
+			if err := respServer.Start(); err != nil {
+				log.Fatalf("RESP server failed: %v", err)
+			}
+		}()
+	}
+
+	if cfg.MemcachedPort != "" {
+		memcacheServer := memcache.NewServer(":"+cfg.MemcachedPort, store, cfg.MaxValueSize)
+		go func() {
+			if err := memcacheServer.Start(); err != nil {
+				log.Fatalf("Memcached server failed: %v", err)
+			}
+		}()
+	}
+
+	httpServer := http.NewServer(cfg.HttpBind, store, cfg.AdminToken)
+	httpServer.SetSizeLimits(cfg.MaxKeySize, cfg.MaxValueSize)
+	if err := httpServer.SetRateLimits(http.RateLimits{
+		Client:    http.RateLimit{Rate: cfg.ClientRate, Burst: cfg.ClientBurst},
+		Namespace: http.RateLimit{Rate: cfg.NamespaceRate, Burst: cfg.NamespaceBurst},
+	}); err != nil {
+		log.Fatalf("Invalid rate limits: %v", err)
+	}
+
+	// Reload the configuration on SIGHUP and on requests to /config/reload
+	var reloadMu sync.Mutex
+	running := cfg
+	reload := func() (conf.Changes, error) {
+		reloadMu.Lock()
+		defer reloadMu.Unlock()
+
+		next, err := conf.GetConfig(os.Args[1:])
+		if err != nil {
+			return conf.Changes{}, err
+		}
+		// Reloading Raft is the step that can fail, so it goes first and
+		// nothing is applied if it does. Rate limits were validated by
+		// GetConfig.
+		if err := store.Reload(next.Raft); err != nil {
+			return conf.Changes{}, err
+		}
+		limits := httpServer.RateLimits()
+		limits.Client = http.RateLimit{Rate: next.ClientRate, Burst: next.ClientBurst}
+		limits.Namespace = http.RateLimit{Rate: next.NamespaceRate, Burst: next.NamespaceBurst}
+		if err := httpServer.SetRateLimits(limits); err != nil {
+			return conf.Changes{}, err
+		}
+		httpServer.SetAdminToken(next.AdminToken)
+
+		// Settings needing a restart keep their values from startup
+		changes := conf.Diff(running, next)
+		changes.RestartRequired = conf.Diff(cfg, next).RestartRequired
+		running = next
+		return changes, nil
+	}
+	httpServer.SetReloadFunc(reload)
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			changes, err := reload()
+			if err != nil {
+				log.Printf("Failed to reload configuration: %v", err)
+				continue
+			}
+			log.Printf("Reloaded configuration. Changed: %v. Restart required: %v",
+				changes.Reloaded, changes.RestartRequired)
+		}
+	}()
+
 	if err := httpServer.Start(); err != nil {
 		log.Fatalf("HTTP server failed: %v", err)
 	}
-	log.Printf("HTTP server started on port %s", cfg.HttpPort)
+	log.Printf("HTTP server started on %s", cfg.HttpBind)
 }
@@ -0,0 +1,363 @@
+package memcache
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thanhqng1510/dbdb/store"
+)
+
+const (
+	// Longest key accepted, as in memcached
+	maxKeyLen = 250
+
+	// Expiration times above this many seconds are absolute unix timestamps
+	maxRelativeExptime = 60 * 60 * 24 * 30
+
+	// Attempts at a compare-and-swap for incr/decr before giving up
+	maxCasRetries = 10
+)
+
+var errBadFormat = errors.New("bad command line format")
+
+// Server represents a TCP server speaking the memcached text protocol
+// so that memcached clients can communicate with store.
+type Server struct {
+	addr  string
+	store store.IStore
+
+	// Largest value accepted in bytes, zero for no limit
+	maxValueLen int
+}
+
+// NewServer creates a new memcached protocol server accepting values of up
+// to the maximum value size of the store, zero for no limit.
+func NewServer(addr string, store store.IStore, maxValueSize int) *Server {
+	return &Server{
+		addr:        addr,
+		store:       store,
+		maxValueLen: maxValueSize,
+	}
+}
+
+// Start starts the memcached protocol server. This is a blocking call.
+func (s *Server) Start() error {
+	log.Printf("Starting memcached server on %s", s.addr)
+
+	ln, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serve(conn)
+	}
+}
+
+func (s *Server) serve(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("Could not read memcached command from %s: %s", conn.RemoteAddr(), err)
+			}
+			return
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			w.WriteString("ERROR\r\n")
+			w.Flush()
+			continue
+		}
+
+		var reply string
+		switch cmd := fields[0]; cmd {
+		case "get", "gets":
+			reply = s.get(fields[1:], cmd == "gets")
+		case "set", "add", "replace", "cas":
+			reply, err = s.storage(r, fields)
+			if err != nil {
+				return
+			}
+		case "delete":
+			reply = s.delete(fields[1:])
+		case "incr", "decr":
+			reply = s.incr(fields[1:], cmd == "incr")
+		case "version":
+			reply = "VERSION dbdb\r\n"
+		case "quit":
+			w.Flush()
+			return
+		default:
+			reply = "ERROR\r\n"
+		}
+
+		// Replies are suppressed for commands sent with noreply
+		if fields[len(fields)-1] != "noreply" {
+			w.WriteString(reply)
+		}
+
+		// Only flush once all pipelined commands have been answered
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// apply sends a command to the store.
+func (s *Server) apply(c store.Command) (*store.Result, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode command: %w", err)
+	}
+	return s.store.Apply(data)
+}
+
+// serverError returns the reply for an error returned by the store.
+func serverError(err error) string {
+	log.Printf("Error applying memcached command: %s", err)
+	return fmt.Sprintf("SERVER_ERROR %s\r\n", strings.NewReplacer("\r", " ", "\n", " ").Replace(err.Error()))
+}
+
+func clientError(err error) string {
+	return fmt.Sprintf("CLIENT_ERROR %s\r\n", err)
+}
+
+// get handles get <key>* and gets <key>*. All keys are read at the same applied index.
+func (s *Server) get(keys []string, withCas bool) string {
+	if len(keys) == 0 {
+		return "ERROR\r\n"
+	}
+
+	lookups, _ := s.store.MGet(keys)
+
+	var sb strings.Builder
+	for _, l := range lookups {
+		key, e := l.Key, l.Entry
+		if !l.Found || e.Type != store.TypeString {
+			continue
+		}
+
+		if withCas {
+			fmt.Fprintf(&sb, "VALUE %s %d %d %d\r\n", key, e.Flags, len(e.Value), e.Version)
+		} else {
+			fmt.Fprintf(&sb, "VALUE %s %d %d\r\n", key, e.Flags, len(e.Value))
+		}
+		sb.Write(e.Value)
+		sb.WriteString("\r\n")
+	}
+	sb.WriteString("END\r\n")
+	return sb.String()
+}
+
+// storage handles the storage commands:
+//
+//	<set|add|replace> <key> <flags> <exptime> <bytes> [noreply]
+//	cas <key> <flags> <exptime> <bytes> <cas unique> [noreply]
+//
+// It returns an error if the data block could not be read from the connection.
+func (s *Server) storage(r *bufio.Reader, fields []string) (string, error) {
+	cmd := fields[0]
+
+	c, size, err := parseStorage(fields)
+	if err != nil {
+		// Without a valid length, the data block cannot be skipped
+		return clientError(err), nil
+	}
+
+	if s.maxValueLen > 0 && size > s.maxValueLen {
+		if _, err := r.Discard(size + 2); err != nil {
+			return "", err
+		}
+		return "SERVER_ERROR object too large for cache\r\n", nil
+	}
+
+	data := make([]byte, size+2)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return "", err
+	}
+	if data[size] != '\r' || data[size+1] != '\n' {
+		return "CLIENT_ERROR bad data chunk\r\n", nil
+	}
+	c.Value = data[:size]
+
+	switch cmd {
+	case "add":
+		c.Cond = store.CondNotExists
+	case "replace":
+		c.Cond = store.CondExists
+	case "cas":
+		// Versions start at 1, so a zero cas unique can never match
+		if c.Version == 0 {
+			if _, ok := s.store.Get(c.Key); ok {
+				return "EXISTS\r\n", nil
+			}
+			return "NOT_FOUND\r\n", nil
+		}
+	}
+
+	res, err := s.apply(c)
+	if err != nil {
+		return serverError(err), nil
+	}
+
+	switch {
+	case res.Applied:
+		return "STORED\r\n", nil
+	case cmd == "cas" && res.Version == 0:
+		return "NOT_FOUND\r\n", nil
+	case cmd == "cas":
+		return "EXISTS\r\n", nil
+	}
+	return "NOT_STORED\r\n", nil
+}
+
+// parseStorage parses the command line of a storage command into a set
+// command without its value, and the size of the data block that follows.
+func parseStorage(fields []string) (store.Command, int, error) {
+	n := 5
+	if fields[0] == "cas" {
+		n = 6
+	}
+	if len(fields) != n && (len(fields) != n+1 || fields[n] != "noreply") {
+		return store.Command{}, 0, errBadFormat
+	}
+
+	key := fields[1]
+	if len(key) > maxKeyLen {
+		return store.Command{}, 0, errBadFormat
+	}
+
+	flags, err := strconv.ParseUint(fields[2], 10, 32)
+	if err != nil {
+		return store.Command{}, 0, errBadFormat
+	}
+
+	exptime, err := strconv.ParseInt(fields[3], 10, 64)
+	if err != nil {
+		return store.Command{}, 0, errBadFormat
+	}
+
+	size, err := strconv.Atoi(fields[4])
+	if err != nil || size < 0 {
+		return store.Command{}, 0, errBadFormat
+	}
+
+	c := store.Command{Op: store.OpTypeSet, Key: key, Flags: uint32(flags), Ttl: ttl(exptime)}
+	if fields[0] == "cas" {
+		if c.Version, err = strconv.ParseUint(fields[5], 10, 64); err != nil {
+			return store.Command{}, 0, errBadFormat
+		}
+	}
+	return c, size, nil
+}
+
+// ttl converts a memcached expiration time to a time to live in milliseconds.
+func ttl(exptime int64) int64 {
+	switch {
+	case exptime == 0:
+		return 0
+	case exptime < 0:
+		// Negative expiration times expire the item immediately
+		return 1
+	case exptime > maxRelativeExptime:
+		return max(exptime*1000-time.Now().UnixMilli(), 1)
+	}
+	return exptime * 1000
+}
+
+// delete handles delete <key> [noreply].
+func (s *Server) delete(args []string) string {
+	if len(args) != 1 && (len(args) != 2 || args[1] != "noreply") {
+		return clientError(errBadFormat)
+	}
+
+	res, err := s.apply(store.Command{Op: store.OpTypeDelete, Key: args[0]})
+	if err != nil {
+		return serverError(err)
+	}
+	if !res.Applied {
+		return "NOT_FOUND\r\n"
+	}
+	return "DELETED\r\n"
+}
+
+// incr handles incr/decr <key> <value> [noreply]. Values are unsigned 64-bit
+// integers: incr wraps around on overflow and decr stops at zero. The new
+// value is written with a compare-and-swap on the key's version, retried
+// if the key was modified concurrently.
+func (s *Server) incr(args []string, up bool) string {
+	if len(args) != 2 && (len(args) != 3 || args[2] != "noreply") {
+		return clientError(errBadFormat)
+	}
+
+	delta, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		return clientError(errors.New("invalid numeric delta argument"))
+	}
+
+	for range maxCasRetries {
+		e, ok := s.store.Get(args[0])
+		if !ok {
+			return "NOT_FOUND\r\n"
+		}
+
+		n, err := strconv.ParseUint(string(e.Value), 10, 64)
+		if err != nil {
+			return clientError(errors.New("cannot increment or decrement non-numeric value"))
+		}
+
+		switch {
+		case up:
+			n += delta
+		case delta > n:
+			n = 0
+		default:
+			n -= delta
+		}
+
+		// Keep the item's flags and expiration time
+		c := store.Command{
+			Op:      store.OpTypeSet,
+			Key:     args[0],
+			Value:   strconv.AppendUint(nil, n, 10),
+			Flags:   e.Flags,
+			Version: e.Version,
+		}
+		if e.ExpireAt != 0 {
+			c.Ttl = max(e.ExpireAt-time.Now().UnixMilli(), 1)
+		}
+
+		res, err := s.apply(c)
+		if err != nil {
+			return serverError(err)
+		}
+		if res.Applied {
+			return string(c.Value) + "\r\n"
+		}
+		if res.Version == 0 {
+			return "NOT_FOUND\r\n"
+		}
+	}
+	return "SERVER_ERROR too many concurrent updates\r\n"
+}
@@ -0,0 +1,203 @@
+package memcache
+
+import (
+	"bufio"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/thanhqng1510/dbdb/store"
+	"github.com/thanhqng1510/dbdb/store/storetest"
+)
+
+// roundTrip sends a raw request to a server without a value size limit and
+// returns the raw reply.
+func roundTrip(t *testing.T, m store.IStore, req string) string {
+	t.Helper()
+	return serverRoundTrip(t, NewServer("", m, 0), req)
+}
+
+// serverRoundTrip sends a raw request to a server and returns the raw reply.
+func serverRoundTrip(t *testing.T, s *Server, req string) string {
+	t.Helper()
+
+	client, conn := net.Pipe()
+	go s.serve(conn)
+	defer client.Close()
+
+	go client.Write([]byte(req))
+
+	r := bufio.NewReader(client)
+	var sb strings.Builder
+	// Read until a complete reply has been received
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("could not read reply: %s", err)
+		}
+		sb.WriteString(line)
+		if r.Buffered() == 0 {
+			return sb.String()
+		}
+	}
+}
+
+func TestGet(t *testing.T) {
+	m := &storetest.Fake{Entries: map[string]store.Entry{"foo": {Value: []byte("bar"), Flags: 3, Version: 7}}}
+
+	if got := roundTrip(t, m, "get foo missing\r\n"); got != "VALUE foo 3 3\r\nbar\r\nEND\r\n" {
+		t.Errorf("unexpected reply %q", got)
+	}
+	if got := roundTrip(t, m, "gets foo\r\n"); got != "VALUE foo 3 3 7\r\nbar\r\nEND\r\n" {
+		t.Errorf("unexpected reply %q", got)
+	}
+}
+
+func TestSet(t *testing.T) {
+	m := &storetest.Fake{}
+	if got := roundTrip(t, m, "set foo 5 10 3\r\nbar\r\n"); got != "STORED\r\n" {
+		t.Errorf("unexpected reply %q", got)
+	}
+
+	want := store.Command{Op: store.OpTypeSet, Key: "foo", Value: []byte("bar"), Flags: 5, Ttl: 10000}
+	if len(m.Applied) != 1 || !reflect.DeepEqual(m.Applied[0], want) {
+		t.Errorf("expected command %+v, got %+v", want, m.Applied)
+	}
+}
+
+func TestSet_BadDataChunk(t *testing.T) {
+	m := &storetest.Fake{}
+	if got := roundTrip(t, m, "set foo 0 0 2\r\nbar\r\n"); !strings.HasPrefix(got, "CLIENT_ERROR bad data chunk") {
+		t.Errorf("unexpected reply %q", got)
+	}
+	if len(m.Applied) != 0 {
+		t.Errorf("expected no command applied, got %+v", m.Applied)
+	}
+}
+
+func TestSet_TooLarge(t *testing.T) {
+	m := &storetest.Fake{}
+	s := NewServer("", m, 2)
+	if got := serverRoundTrip(t, s, "set foo 0 0 3\r\nbar\r\n"); got != "SERVER_ERROR object too large for cache\r\n" {
+		t.Errorf("unexpected reply %q", got)
+	}
+	if got := serverRoundTrip(t, s, "set foo 0 0 2\r\nba\r\n"); got != "STORED\r\n" {
+		t.Errorf("expected value within the limit to be stored, got %q", got)
+	}
+	if len(m.Applied) != 1 {
+		t.Errorf("expected only the value within the limit to be applied, got %+v", m.Applied)
+	}
+}
+
+func TestAddAndReplace(t *testing.T) {
+	m := &storetest.Fake{ApplyResult: &store.Result{Applied: false, Version: 4}}
+	if got := roundTrip(t, m, "add foo 0 0 1\r\nx\r\n"); got != "NOT_STORED\r\n" {
+		t.Errorf("unexpected reply %q", got)
+	}
+	if got := roundTrip(t, m, "replace foo 0 0 1\r\nx\r\n"); got != "NOT_STORED\r\n" {
+		t.Errorf("unexpected reply %q", got)
+	}
+	if m.Applied[0].Cond != store.CondNotExists || m.Applied[1].Cond != store.CondExists {
+		t.Errorf("expected nx then xx conditions, got %+v", m.Applied)
+	}
+}
+
+func TestCas(t *testing.T) {
+	m := &storetest.Fake{ApplyResult: &store.Result{Applied: false, Version: 4}}
+	if got := roundTrip(t, m, "cas foo 0 0 1 3\r\nx\r\n"); got != "EXISTS\r\n" {
+		t.Errorf("unexpected reply %q", got)
+	}
+	if m.Applied[0].Version != 3 {
+		t.Errorf("expected version 3, got %d", m.Applied[0].Version)
+	}
+
+	m = &storetest.Fake{ApplyResult: &store.Result{Applied: false}}
+	if got := roundTrip(t, m, "cas foo 0 0 1 3\r\nx\r\n"); got != "NOT_FOUND\r\n" {
+		t.Errorf("unexpected reply %q", got)
+	}
+}
+
+func TestNoreply(t *testing.T) {
+	m := &storetest.Fake{}
+	if got := roundTrip(t, m, "set foo 0 0 1 noreply\r\nx\r\nversion\r\n"); got != "VERSION dbdb\r\n" {
+		t.Errorf("unexpected reply %q", got)
+	}
+}
+
+func TestIncr(t *testing.T) {
+	m := &storetest.Fake{Entries: map[string]store.Entry{"n": {Value: []byte("5"), Flags: 1, Version: 2}}}
+	if got := roundTrip(t, m, "decr n 10\r\n"); got != "0\r\n" {
+		t.Errorf("expected decr to stop at zero, got %q", got)
+	}
+
+	want := store.Command{Op: store.OpTypeSet, Key: "n", Value: []byte("0"), Flags: 1, Version: 2}
+	if len(m.Applied) != 1 || !reflect.DeepEqual(m.Applied[0], want) {
+		t.Errorf("expected command %+v, got %+v", want, m.Applied)
+	}
+
+	m = &storetest.Fake{Entries: map[string]store.Entry{"n": {Value: []byte("18446744073709551615"), Version: 2}}}
+	if got := roundTrip(t, m, "incr n 2\r\n"); got != "1\r\n" {
+		t.Errorf("expected incr to wrap around, got %q", got)
+	}
+}
+
+func TestIncr_Errors(t *testing.T) {
+	m := &storetest.Fake{Entries: map[string]store.Entry{"s": {Value: []byte("abc"), Version: 2}}}
+	if got := roundTrip(t, m, "incr missing 1\r\n"); got != "NOT_FOUND\r\n" {
+		t.Errorf("unexpected reply %q", got)
+	}
+	if got := roundTrip(t, m, "incr s 1\r\n"); !strings.HasPrefix(got, "CLIENT_ERROR") {
+		t.Errorf("unexpected reply %q", got)
+	}
+	if got := roundTrip(t, m, "incr s -1\r\n"); !strings.HasPrefix(got, "CLIENT_ERROR") {
+		t.Errorf("unexpected reply %q", got)
+	}
+}
+
+func TestIncr_Contention(t *testing.T) {
+	m := &storetest.Fake{
+		Entries:     map[string]store.Entry{"n": {Value: []byte("1"), Version: 2}},
+		ApplyResult: &store.Result{Applied: false, Version: 3},
+	}
+	if got := roundTrip(t, m, "incr n 1\r\n"); !strings.HasPrefix(got, "SERVER_ERROR") {
+		t.Errorf("unexpected reply %q", got)
+	}
+	if len(m.Applied) != maxCasRetries {
+		t.Errorf("expected %d attempts, got %d", maxCasRetries, len(m.Applied))
+	}
+}
+
+func TestTtl(t *testing.T) {
+	if got := ttl(0); got != 0 {
+		t.Errorf("expected no expiry, got %d", got)
+	}
+	if got := ttl(60); got != 60000 {
+		t.Errorf("expected 60000, got %d", got)
+	}
+	if got := ttl(-1); got != 1 {
+		t.Errorf("expected immediate expiry, got %d", got)
+	}
+	if got := ttl(maxRelativeExptime + 1); got != 1 {
+		t.Errorf("expected past timestamp to expire immediately, got %d", got)
+	}
+}
+
+func TestUnknownCommand(t *testing.T) {
+	if got := roundTrip(t, &storetest.Fake{}, "flush_all\r\n"); got != "ERROR\r\n" {
+		t.Errorf("unexpected reply %q", got)
+	}
+}
+
+func TestGet_OtherNamespace(t *testing.T) {
+	st := storetest.NewStore(t)
+	storetest.Apply(t, st, `{"op": "nscreate", "key": "team"}`)
+	storetest.Apply(t, st.In("team"), `{"op": "set", "key": "secret", "value": "MQ=="}`)
+
+	if got := roundTrip(t, st, "get \x00team\x00secret\r\n"); got != "END\r\n" {
+		t.Errorf("expected no value for a key of another namespace, got %q", got)
+	}
+	if got := roundTrip(t, st, "gets \x00team\x00secret\r\n"); got != "END\r\n" {
+		t.Errorf("expected no value for a key of another namespace, got %q", got)
+	}
+}
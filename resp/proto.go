@@ -0,0 +1,119 @@
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// maxBulkLen bounds the size of a single bulk string a client may send.
+const maxBulkLen = 512 * 1024 * 1024
+
+var (
+	errProtocol = errors.New("protocol error")
+
+	// errTooLarge is returned for commands with an argument over the size
+	// limit, which are read and discarded
+	errTooLarge = errors.New("argument exceeds the maximum size")
+)
+
+// readCommand reads a single command from the connection. Commands are
+// either RESP arrays of bulk strings, as sent by client libraries, or
+// inline space separated commands, as typed into a telnet session. Bulk
+// strings longer than maxArgLen, if not zero, are skipped without being
+// buffered, and the command fails with errTooLarge once read.
+func readCommand(r *bufio.Reader, maxArgLen int) ([]string, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasPrefix(line, "*") {
+		return strings.Fields(line), nil
+	}
+
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n > 1024*1024 {
+		return nil, fmt.Errorf("%w: invalid multibulk length", errProtocol)
+	}
+
+	args := make([]string, 0, max(n, 0))
+	tooLarge := false
+	for range n {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(line, "$") {
+			return nil, fmt.Errorf("%w: expected '$', got '%.1s'", errProtocol, line)
+		}
+
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 || size > maxBulkLen {
+			return nil, fmt.Errorf("%w: invalid bulk length", errProtocol)
+		}
+		if maxArgLen > 0 && size > maxArgLen {
+			if _, err := r.Discard(size + 2); err != nil {
+				return nil, err
+			}
+			tooLarge = true
+			continue
+		}
+
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		if buf[size] != '\r' || buf[size+1] != '\n' {
+			return nil, fmt.Errorf("%w: bulk string is not terminated by CRLF", errProtocol)
+		}
+		args = append(args, string(buf[:size]))
+	}
+	if tooLarge {
+		return nil, errTooLarge
+	}
+	return args, nil
+}
+
+// readLine reads a line terminated by LF and strips the trailing CRLF.
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), nil
+}
+
+// writer encodes RESP replies.
+type writer struct {
+	*bufio.Writer
+}
+
+func (w writer) simple(s string) {
+	fmt.Fprintf(w, "+%s\r\n", s)
+}
+
+func (w writer) error(s string) {
+	// Error replies must fit on a single line
+	s = strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+	fmt.Fprintf(w, "-%s\r\n", s)
+}
+
+func (w writer) integer(n int64) {
+	fmt.Fprintf(w, ":%d\r\n", n)
+}
+
+func (w writer) bulk(s string) {
+	fmt.Fprintf(w, "$%d\r\n%s\r\n", len(s), s)
+}
+
+func (w writer) null() {
+	w.WriteString("$-1\r\n")
+}
+
+func (w writer) array(n int) {
+	fmt.Fprintf(w, "*%d\r\n", n)
+}
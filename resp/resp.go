@@ -0,0 +1,520 @@
+package resp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/thanhqng1510/dbdb/store"
+)
+
+// Server represents a TCP server speaking a subset of the Redis protocol (RESP)
+// so that existing Redis clients can communicate with store.
+type Server struct {
+	addr  string
+	store store.IStore
+
+	// Maximum size in bytes of an argument, zero for the protocol limit
+	maxArgLen int
+}
+
+// NewServer creates a new RESP server.
+func NewServer(addr string, store store.IStore) *Server {
+	return &Server{
+		addr:  addr,
+		store: store,
+	}
+}
+
+// SetSizeLimits sets the maximum sizes in bytes of keys and values, zero for
+// no limit, so that arguments larger than both are discarded before being
+// read whole. It must be called before Start.
+func (s *Server) SetSizeLimits(maxKeySize, maxValueSize int) {
+	s.maxArgLen = 0
+	if maxKeySize > 0 && maxValueSize > 0 {
+		s.maxArgLen = max(maxKeySize, maxValueSize)
+	}
+}
+
+// command describes a supported Redis command.
+type command struct {
+	handler func(s *Server, w writer, args []string)
+
+	// Minimum number of arguments, including the command name
+	arity int
+}
+
+var commands = map[string]command{
+	"PING":   {(*Server).ping, 1},
+	"ECHO":   {(*Server).echo, 2},
+	"GET":    {(*Server).get, 2},
+	"SET":    {(*Server).set, 3},
+	"DEL":    {(*Server).del, 2},
+	"EXISTS": {(*Server).exists, 2},
+	"MGET":   {(*Server).mget, 2},
+	"MSET":   {(*Server).mset, 3},
+	"KEYS":   {(*Server).keys, 2},
+	"SCAN":   {(*Server).scan, 2},
+	"INCR":   {(*Server).incr, 2},
+	"DECR":   {(*Server).decr, 2},
+	"INCRBY": {(*Server).incrby, 3},
+	"DECRBY": {(*Server).decrby, 3},
+
+	"HSET":      {(*Server).hset, 4},
+	"HGET":      {(*Server).hget, 3},
+	"HDEL":      {(*Server).hdel, 3},
+	"HGETALL":   {(*Server).hgetall, 2},
+	"LPUSH":     {(*Server).lpush, 3},
+	"RPUSH":     {(*Server).rpush, 3},
+	"LPOP":      {(*Server).lpop, 2},
+	"RPOP":      {(*Server).rpop, 2},
+	"LRANGE":    {(*Server).lrange, 4},
+	"SADD":      {(*Server).sadd, 3},
+	"SREM":      {(*Server).srem, 3},
+	"SMEMBERS":  {(*Server).smembers, 2},
+	"SISMEMBER": {(*Server).sismember, 3},
+}
+
+// Start starts the RESP server. This is a blocking call.
+func (s *Server) Start() error {
+	log.Printf("Starting RESP server on %s", s.addr)
+
+	ln, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serve(conn)
+	}
+}
+
+func (s *Server) serve(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	w := writer{bufio.NewWriter(conn)}
+	for {
+		args, err := readCommand(r, s.maxArgLen)
+		if errors.Is(err, errTooLarge) {
+			// The connection is still in sync, as the command was read whole
+			w.error("ERR " + err.Error())
+			if r.Buffered() == 0 && w.Flush() != nil {
+				return
+			}
+			continue
+		}
+		if err != nil {
+			if errors.Is(err, errProtocol) {
+				w.error("ERR " + err.Error())
+				w.Flush()
+			} else if err != io.EOF {
+				log.Printf("Could not read RESP command from %s: %s", conn.RemoteAddr(), err)
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		name := strings.ToUpper(args[0])
+		if name == "QUIT" {
+			w.simple("OK")
+			w.Flush()
+			return
+		}
+
+		cmd, ok := commands[name]
+		switch {
+		case !ok:
+			w.error(fmt.Sprintf("ERR unknown command '%s'", args[0]))
+		case len(args) < cmd.arity:
+			w.error(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(args[0])))
+		default:
+			cmd.handler(s, w, args)
+		}
+
+		// Only flush once all pipelined commands have been answered
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// apply sends a command to the store.
+func (s *Server) apply(c store.Command) (*store.Result, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode command: %w", err)
+	}
+	return s.store.Apply(data)
+}
+
+// applyError writes the reply for an error returned by the store.
+func applyError(w writer, err error) {
+	switch {
+	case errors.Is(err, store.ErrNotInteger):
+		w.error("ERR value is not an integer or out of range")
+	case errors.Is(err, store.ErrOverflow):
+		w.error("ERR increment or decrement would overflow")
+	case errors.Is(err, store.ErrWrongType):
+		w.error(errWrongType)
+	case errors.Is(err, store.ErrCollectionFull):
+		w.error("ERR collection is full")
+	default:
+		log.Printf("Error applying RESP command: %s", err)
+		w.error("ERR " + err.Error())
+	}
+}
+
+func (s *Server) ping(w writer, args []string) {
+	if len(args) > 1 {
+		w.bulk(args[1])
+		return
+	}
+	w.simple("PONG")
+}
+
+func (s *Server) echo(w writer, args []string) {
+	w.bulk(args[1])
+}
+
+func (s *Server) get(w writer, args []string) {
+	entry, exist := s.store.Get(args[1])
+	if !exist {
+		w.null()
+		return
+	}
+	if entry.Type != store.TypeString {
+		w.error(errWrongType)
+		return
+	}
+	w.bulk(string(entry.Value))
+}
+
+// set handles SET key value [EX seconds | PX milliseconds] [NX | XX].
+func (s *Server) set(w writer, args []string) {
+	c := store.Command{Op: store.OpTypeSet, Key: args[1], Value: []byte(args[2])}
+
+	for i := 3; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i]); opt {
+		case "NX", "XX":
+			if c.Cond != "" {
+				w.error("ERR syntax error")
+				return
+			}
+			c.Cond = store.CondType(strings.ToLower(opt))
+		case "EX", "PX":
+			if c.Ttl != 0 || i+1 == len(args) {
+				w.error("ERR syntax error")
+				return
+			}
+			i++
+
+			ttl, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil {
+				w.error("ERR value is not an integer or out of range")
+				return
+			}
+			if ttl <= 0 || (opt == "EX" && ttl > (1<<63-1)/1000) {
+				w.error("ERR invalid expire time in 'set' command")
+				return
+			}
+			if opt == "EX" {
+				ttl *= 1000
+			}
+			c.Ttl = ttl
+		default:
+			w.error("ERR syntax error")
+			return
+		}
+	}
+
+	res, err := s.apply(c)
+	if err != nil {
+		applyError(w, err)
+		return
+	}
+	if !res.Applied {
+		w.null()
+		return
+	}
+	w.simple("OK")
+}
+
+// del handles DEL key [key ...]. All keys are deleted in one transaction.
+func (s *Server) del(w writer, args []string) {
+	txn := store.Command{Op: store.OpTypeTxn}
+	for _, key := range args[1:] {
+		txn.Then = append(txn.Then, store.Command{Op: store.OpTypeDelete, Key: key})
+	}
+
+	res, err := s.apply(txn)
+	if err != nil {
+		applyError(w, err)
+		return
+	}
+
+	var n int64
+	for _, r := range res.Results {
+		if r.Applied {
+			n++
+		}
+	}
+	w.integer(n)
+}
+
+func (s *Server) exists(w writer, args []string) {
+	var n int64
+	for _, key := range args[1:] {
+		if _, exist := s.store.Get(key); exist {
+			n++
+		}
+	}
+	w.integer(n)
+}
+
+// mget handles MGET key [key ...]. All keys are read at the same applied index.
+func (s *Server) mget(w writer, args []string) {
+	lookups, _ := s.store.MGet(args[1:])
+
+	// Keys holding another type than strings are nil, as in Redis
+	w.array(len(lookups))
+	for _, l := range lookups {
+		if !l.Found || l.Entry.Type != store.TypeString {
+			w.null()
+			continue
+		}
+		w.bulk(string(l.Entry.Value))
+	}
+}
+
+// mset handles MSET key value [key value ...]. All keys are set in one
+// transaction, so either all or none of them are set.
+func (s *Server) mset(w writer, args []string) {
+	if len(args)%2 == 0 {
+		w.error("ERR wrong number of arguments for 'mset' command")
+		return
+	}
+
+	txn := store.Command{Op: store.OpTypeTxn}
+	for i := 1; i < len(args); i += 2 {
+		txn.Then = append(txn.Then, store.Command{Op: store.OpTypeSet, Key: args[i], Value: []byte(args[i+1])})
+	}
+
+	if _, err := s.apply(txn); err != nil {
+		applyError(w, err)
+		return
+	}
+	w.simple("OK")
+}
+
+func (s *Server) keys(w writer, args []string) {
+	var matched []string
+	for _, key := range s.store.Keys() {
+		if match(args[1], key) {
+			matched = append(matched, key)
+		}
+	}
+
+	w.array(len(matched))
+	for _, key := range matched {
+		w.bulk(key)
+	}
+}
+
+// scan handles SCAN cursor [MATCH pattern] [COUNT count]. The cursor is the
+// position in the ordered key space, so keys deleted during an iteration may
+// cause keys after them to be skipped.
+func (s *Server) scan(w writer, args []string) {
+	cursor, err := strconv.Atoi(args[1])
+	if err != nil || cursor < 0 {
+		w.error("ERR invalid cursor")
+		return
+	}
+
+	pattern, count := "*", 10
+	for i := 2; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			w.error("ERR syntax error")
+			return
+		}
+		switch strings.ToUpper(args[i]) {
+		case "MATCH":
+			pattern = args[i+1]
+		case "COUNT":
+			if count, err = strconv.Atoi(args[i+1]); err != nil || count < 1 {
+				w.error("ERR value is not an integer or out of range")
+				return
+			}
+		default:
+			w.error("ERR syntax error")
+			return
+		}
+	}
+
+	keys := s.store.Keys()
+	end := min(cursor+count, len(keys))
+
+	var matched []string
+	for i := cursor; i < end; i++ {
+		if match(pattern, keys[i]) {
+			matched = append(matched, keys[i])
+		}
+	}
+
+	next := end
+	if next >= len(keys) {
+		next = 0
+	}
+
+	w.array(2)
+	w.bulk(strconv.Itoa(next))
+	w.array(len(matched))
+	for _, key := range matched {
+		w.bulk(key)
+	}
+}
+
+func (s *Server) incr(w writer, args []string) {
+	s.counter(w, store.OpTypeIncr, args[1], 1)
+}
+
+func (s *Server) decr(w writer, args []string) {
+	s.counter(w, store.OpTypeDecr, args[1], 1)
+}
+
+func (s *Server) incrby(w writer, args []string) {
+	delta, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		w.error("ERR value is not an integer or out of range")
+		return
+	}
+	s.counter(w, store.OpTypeAdd, args[1], delta)
+}
+
+func (s *Server) decrby(w writer, args []string) {
+	delta, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		w.error("ERR value is not an integer or out of range")
+		return
+	}
+	s.counter(w, store.OpTypeDecr, args[1], delta)
+}
+
+// counter applies a numeric command to a key and replies with the new value.
+func (s *Server) counter(w writer, op store.OpType, key string, delta int64) {
+	res, err := s.apply(store.Command{Op: op, Key: key, Delta: delta})
+	if err != nil {
+		applyError(w, err)
+		return
+	}
+
+	n, err := strconv.ParseInt(string(res.Value), 10, 64)
+	if err != nil {
+		applyError(w, store.ErrNotInteger)
+		return
+	}
+	w.integer(n)
+}
+
+// match reports whether s matches the Redis glob-style pattern, which
+// supports '*', '?', character classes such as [a-z] or [^a] and '\' escapes.
+func match(pattern, s string) bool {
+	// Position to resume from when backtracking to the last '*'
+	starP, starS := -1, 0
+
+	p, i := 0, 0
+	for i < len(s) {
+		if p < len(pattern) {
+			switch pattern[p] {
+			case '*':
+				starP, starS = p, i
+				p++
+				continue
+			case '?':
+				p++
+				i++
+				continue
+			case '[':
+				if n, ok := matchClass(pattern[p:], s[i]); n > 0 {
+					if ok {
+						p += n
+						i++
+						continue
+					}
+					break
+				}
+				fallthrough
+			default:
+				c := pattern[p]
+				n := 1
+				if c == '\\' && p+1 < len(pattern) {
+					c = pattern[p+1]
+					n = 2
+				}
+				if c == s[i] {
+					p += n
+					i++
+					continue
+				}
+			}
+		}
+
+		if starP < 0 {
+			return false
+		}
+		starS++
+		p, i = starP+1, starS
+	}
+
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// matchClass matches c against the character class at the start of pattern.
+// It returns the length of the class, or zero if the class is not terminated.
+func matchClass(pattern string, c byte) (int, bool) {
+	i := 1
+	negate := i < len(pattern) && pattern[i] == '^'
+	if negate {
+		i++
+	}
+
+	matched := false
+	for ; i < len(pattern) && pattern[i] != ']'; i++ {
+		switch {
+		case pattern[i] == '\\' && i+1 < len(pattern):
+			i++
+			matched = matched || pattern[i] == c
+		case i+2 < len(pattern) && pattern[i+1] == '-' && pattern[i+2] != ']':
+			lo, hi := pattern[i], pattern[i+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			matched = matched || (lo <= c && c <= hi)
+			i += 2
+		default:
+			matched = matched || pattern[i] == c
+		}
+	}
+	if i == len(pattern) {
+		return 0, false
+	}
+	return i + 1, matched != negate
+}
@@ -0,0 +1,258 @@
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/thanhqng1510/dbdb/store"
+	"github.com/thanhqng1510/dbdb/store/storetest"
+)
+
+// roundTrip sends a command as a RESP array and returns the raw reply.
+func roundTrip(t *testing.T, m store.IStore, args ...string) string {
+	t.Helper()
+
+	client, conn := net.Pipe()
+	s := &Server{store: m}
+	go s.serve(conn)
+	defer client.Close()
+
+	req := fmt.Sprintf("*%d\r\n", len(args))
+	for _, a := range args {
+		req += fmt.Sprintf("$%d\r\n%s\r\n", len(a), a)
+	}
+	go client.Write([]byte(req))
+
+	r := bufio.NewReader(client)
+	var sb strings.Builder
+	// Read until a complete reply has been received
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("could not read reply: %s", err)
+		}
+		sb.WriteString(line)
+		if r.Buffered() == 0 {
+			return sb.String()
+		}
+	}
+}
+
+func TestReadCommand(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("*2\r\n$3\r\nGET\r\n$3\r\nf o\r\nSET a  b\r\n"))
+
+	args, err := readCommand(r, 0)
+	if err != nil || len(args) != 2 || args[0] != "GET" || args[1] != "f o" {
+		t.Errorf("expected [GET f o], got %q (err %v)", args, err)
+	}
+
+	args, err = readCommand(r, 0)
+	if err != nil || len(args) != 3 || args[2] != "b" {
+		t.Errorf("expected inline [SET a b], got %q (err %v)", args, err)
+	}
+}
+
+func TestReadCommand_ProtocolError(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("*1\r\n+GET\r\n"))
+	if _, err := readCommand(r, 0); err == nil {
+		t.Errorf("expected protocol error, got nil")
+	}
+}
+
+func TestReadCommand_TooLarge(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("*3\r\n$3\r\nSET\r\n$1\r\na\r\n$6\r\nabcdef\r\n*2\r\n$3\r\nGET\r\n$1\r\na\r\n"))
+	if _, err := readCommand(r, 4); !errors.Is(err, errTooLarge) {
+		t.Errorf("expected errTooLarge, got %v", err)
+	}
+
+	// The oversized command is discarded whole
+	if args, err := readCommand(r, 4); err != nil || len(args) != 2 || args[0] != "GET" {
+		t.Errorf("expected [GET a], got %q (err %v)", args, err)
+	}
+}
+
+func TestGet(t *testing.T) {
+	m := &storetest.Fake{Entries: map[string]store.Entry{"foo": {Value: []byte("bar")}}}
+	if got := roundTrip(t, m, "GET", "foo"); got != "$3\r\nbar\r\n" {
+		t.Errorf("unexpected reply %q", got)
+	}
+	if got := roundTrip(t, m, "get", "missing"); got != "$-1\r\n" {
+		t.Errorf("expected null reply, got %q", got)
+	}
+}
+
+func TestSet_Options(t *testing.T) {
+	m := &storetest.Fake{}
+	if got := roundTrip(t, m, "SET", "foo", "bar", "EX", "10", "NX"); got != "+OK\r\n" {
+		t.Errorf("unexpected reply %q", got)
+	}
+
+	want := store.Command{Op: store.OpTypeSet, Key: "foo", Value: []byte("bar"), Ttl: 10000, Cond: store.CondNotExists}
+	if len(m.Applied) != 1 || !reflect.DeepEqual(m.Applied[0], want) {
+		t.Errorf("expected command %+v, got %+v", want, m.Applied)
+	}
+}
+
+func TestSet_NotApplied(t *testing.T) {
+	m := &storetest.Fake{ApplyResult: &store.Result{Applied: false}}
+	if got := roundTrip(t, m, "SET", "foo", "bar", "XX"); got != "$-1\r\n" {
+		t.Errorf("expected null reply, got %q", got)
+	}
+}
+
+func TestSet_SyntaxError(t *testing.T) {
+	m := &storetest.Fake{}
+	for _, args := range [][]string{
+		{"SET", "foo", "bar", "NX", "XX"},
+		{"SET", "foo", "bar", "EX"},
+		{"SET", "foo", "bar", "EX", "0"},
+		{"SET", "foo", "bar", "BOGUS"},
+	} {
+		if got := roundTrip(t, m, args...); !strings.HasPrefix(got, "-ERR") {
+			t.Errorf("%q: expected error reply, got %q", args, got)
+		}
+	}
+	if len(m.Applied) != 0 {
+		t.Errorf("expected no command applied, got %+v", m.Applied)
+	}
+}
+
+func TestIncr(t *testing.T) {
+	m := &storetest.Fake{ApplyResult: &store.Result{Applied: true, Value: []byte("42")}}
+	if got := roundTrip(t, m, "INCR", "n"); got != ":42\r\n" {
+		t.Errorf("unexpected reply %q", got)
+	}
+
+	m = &storetest.Fake{ApplyErr: fmt.Errorf("FSM error on apply command: %w", store.ErrNotInteger)}
+	if got := roundTrip(t, m, "INCR", "n"); got != "-ERR value is not an integer or out of range\r\n" {
+		t.Errorf("unexpected reply %q", got)
+	}
+}
+
+func TestIncrByAndDecr(t *testing.T) {
+	m := &storetest.Fake{ApplyResult: &store.Result{Applied: true, Value: []byte("-1")}}
+	for _, args := range [][]string{{"DECR", "n"}, {"INCRBY", "n", "-5"}, {"DECRBY", "n", "3"}} {
+		if got := roundTrip(t, m, args...); got != ":-1\r\n" {
+			t.Errorf("%v: unexpected reply %q", args, got)
+		}
+	}
+
+	want := []store.Command{
+		{Op: store.OpTypeDecr, Key: "n", Delta: 1},
+		{Op: store.OpTypeAdd, Key: "n", Delta: -5},
+		{Op: store.OpTypeDecr, Key: "n", Delta: 3},
+	}
+	if !reflect.DeepEqual(m.Applied, want) {
+		t.Errorf("expected commands %+v, got %+v", want, m.Applied)
+	}
+
+	if got := roundTrip(t, m, "INCRBY", "n", "x"); got != "-ERR value is not an integer or out of range\r\n" {
+		t.Errorf("unexpected reply %q", got)
+	}
+}
+
+func TestDelAndExists(t *testing.T) {
+	m := &storetest.Fake{Entries: map[string]store.Entry{"a": {Value: []byte("1")}}}
+	if got := roundTrip(t, m, "EXISTS", "a", "b", "a"); got != ":2\r\n" {
+		t.Errorf("unexpected reply %q", got)
+	}
+
+	m.ApplyResult = &store.Result{Applied: true, Results: []store.Result{{Applied: true}, {Applied: false}}}
+	if got := roundTrip(t, m, "DEL", "a", "b"); got != ":1\r\n" {
+		t.Errorf("unexpected reply %q", got)
+	}
+	if len(m.Applied) != 1 || m.Applied[0].Op != store.OpTypeTxn || len(m.Applied[0].Then) != 2 {
+		t.Errorf("expected keys to be deleted in one transaction, got %+v", m.Applied)
+	}
+}
+
+func TestMset(t *testing.T) {
+	m := &storetest.Fake{}
+	if got := roundTrip(t, m, "MSET", "a", "1", "b", "2"); got != "+OK\r\n" {
+		t.Errorf("unexpected reply %q", got)
+	}
+
+	want := store.Command{Op: store.OpTypeTxn, Then: []store.Command{
+		{Op: store.OpTypeSet, Key: "a", Value: []byte("1")},
+		{Op: store.OpTypeSet, Key: "b", Value: []byte("2")},
+	}}
+	if len(m.Applied) != 1 || !reflect.DeepEqual(m.Applied[0], want) {
+		t.Errorf("expected command %+v, got %+v", want, m.Applied)
+	}
+}
+
+func TestScan(t *testing.T) {
+	m := &storetest.Fake{Entries: map[string]store.Entry{"a": {Value: []byte("1")}}}
+	if got := roundTrip(t, m, "SCAN", "0", "MATCH", "a*"); got != "*2\r\n$1\r\n0\r\n*1\r\n$1\r\na\r\n" {
+		t.Errorf("unexpected reply %q", got)
+	}
+}
+
+func TestUnknownCommand(t *testing.T) {
+	if got := roundTrip(t, &storetest.Fake{}, "FLUSHALL"); !strings.HasPrefix(got, "-ERR unknown command") {
+		t.Errorf("unexpected reply %q", got)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		s       string
+		want    bool
+	}{
+		{"*", "", true},
+		{"*", "user/1", true},
+		{"user:*", "user:42", true},
+		{"user:*", "users", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{"*a*b", "xaxxb", true},
+		{"*a*b", "xaxxbc", false},
+		{`h\*llo`, "h*llo", true},
+		{`h\*llo`, "hello", false},
+		{"[abc", "[abc", true},
+	}
+
+	for _, tc := range tests {
+		if got := match(tc.pattern, tc.s); got != tc.want {
+			t.Errorf("match(%q, %q) = %t, want %t", tc.pattern, tc.s, got, tc.want)
+		}
+	}
+}
+
+func TestGet_OtherNamespace(t *testing.T) {
+	st := storetest.NewStore(t)
+	storetest.Apply(t, st, `{"op": "nscreate", "key": "team"}`)
+	storetest.Apply(t, st.In("team"), `{"op": "set", "key": "secret", "value": "MQ=="}`)
+
+	if got := roundTrip(t, st, "GET", "\x00team\x00secret"); got != "$-1\r\n" {
+		t.Errorf("expected null reply for a key of another namespace, got %q", got)
+	}
+	if got := roundTrip(t, st, "MGET", "\x00team\x00secret"); got != "*1\r\n$-1\r\n" {
+		t.Errorf("expected null reply for a key of another namespace, got %q", got)
+	}
+}
+
+func TestMget_OtherTypes(t *testing.T) {
+	st := storetest.NewStore(t)
+	storetest.Apply(t, st,
+		`{"op": "set", "key": "s", "value": "MQ=="}`,
+		`{"op": "hset", "key": "h", "field": "f", "value": "MQ=="}`,
+		`{"op": "rpush", "key": "l", "value": "MQ=="}`,
+	)
+
+	if got := roundTrip(t, st, "MGET", "s", "h", "l"); got != "*3\r\n$1\r\n1\r\n$-1\r\n$-1\r\n" {
+		t.Errorf("expected null replies for keys that are not strings, got %q", got)
+	}
+	if got := roundTrip(t, st, "GET", "h"); !strings.HasPrefix(got, "-WRONGTYPE") {
+		t.Errorf("expected WRONGTYPE, got %q", got)
+	}
+}
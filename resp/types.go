@@ -0,0 +1,238 @@
+package resp
+
+import (
+	"maps"
+	"slices"
+	"strconv"
+
+	"github.com/thanhqng1510/dbdb/store"
+)
+
+const errWrongType = "WRONGTYPE Operation against a key holding the wrong kind of value"
+
+// applyAll applies commands on several fields, elements or members of a key
+// in one transaction, and returns the result of each of them.
+func (s *Server) applyAll(w writer, ops []store.Command) ([]store.Result, bool) {
+	res, err := s.apply(store.Command{Op: store.OpTypeTxn, Then: ops})
+	if err != nil {
+		applyError(w, err)
+		return nil, false
+	}
+	return res.Results, true
+}
+
+// countApplied replies with the number of commands that had an effect.
+func (s *Server) countApplied(w writer, ops []store.Command) {
+	results, ok := s.applyAll(w, ops)
+	if !ok {
+		return
+	}
+
+	var n int64
+	for _, r := range results {
+		if r.Applied {
+			n++
+		}
+	}
+	w.integer(n)
+}
+
+// lookup returns the entry of a key holding a value of the given type. It
+// replies and returns false if the key does not exist or holds another type.
+func (s *Server) lookup(w writer, key string, typ store.ValueType, missing func()) (store.Entry, bool) {
+	entry, exist := s.store.Get(key)
+	if !exist {
+		missing()
+		return store.Entry{}, false
+	}
+	if entry.Type != typ {
+		w.error(errWrongType)
+		return store.Entry{}, false
+	}
+	return entry, true
+}
+
+// hset handles HSET key field value [field value ...].
+func (s *Server) hset(w writer, args []string) {
+	if len(args)%2 != 0 {
+		w.error("ERR wrong number of arguments for 'hset' command")
+		return
+	}
+
+	var ops []store.Command
+	for i := 2; i < len(args); i += 2 {
+		ops = append(ops, store.Command{Op: store.OpTypeHSet, Key: args[1], Field: args[i], Value: []byte(args[i+1])})
+	}
+
+	results, ok := s.applyAll(w, ops)
+	if !ok {
+		return
+	}
+
+	var n int64
+	for _, r := range results {
+		if r.Created {
+			n++
+		}
+	}
+	w.integer(n)
+}
+
+func (s *Server) hget(w writer, args []string) {
+	entry, ok := s.lookup(w, args[1], store.TypeHash, w.null)
+	if !ok {
+		return
+	}
+
+	v, ok := entry.Hash[args[2]]
+	if !ok {
+		w.null()
+		return
+	}
+	w.bulk(string(v))
+}
+
+// hdel handles HDEL key field [field ...].
+func (s *Server) hdel(w writer, args []string) {
+	var ops []store.Command
+	for _, field := range args[2:] {
+		ops = append(ops, store.Command{Op: store.OpTypeHDel, Key: args[1], Field: field})
+	}
+	s.countApplied(w, ops)
+}
+
+// hgetall replies with the fields and values of a hash, ordered by field.
+func (s *Server) hgetall(w writer, args []string) {
+	entry, ok := s.lookup(w, args[1], store.TypeHash, func() { w.array(0) })
+	if !ok {
+		return
+	}
+
+	w.array(2 * len(entry.Hash))
+	for _, field := range slices.Sorted(maps.Keys(entry.Hash)) {
+		w.bulk(field)
+		w.bulk(string(entry.Hash[field]))
+	}
+}
+
+func (s *Server) lpush(w writer, args []string) {
+	s.push(w, store.OpTypeLPush, args)
+}
+
+func (s *Server) rpush(w writer, args []string) {
+	s.push(w, store.OpTypeRPush, args)
+}
+
+// push handles LPUSH and RPUSH key element [element ...], replying with
+// the length of the list.
+func (s *Server) push(w writer, op store.OpType, args []string) {
+	var ops []store.Command
+	for _, elem := range args[2:] {
+		ops = append(ops, store.Command{Op: op, Key: args[1], Value: []byte(elem)})
+	}
+
+	results, ok := s.applyAll(w, ops)
+	if !ok {
+		return
+	}
+	w.integer(int64(results[len(results)-1].Count))
+}
+
+func (s *Server) lpop(w writer, args []string) {
+	s.pop(w, store.OpTypeLPop, args[1])
+}
+
+func (s *Server) rpop(w writer, args []string) {
+	s.pop(w, store.OpTypeRPop, args[1])
+}
+
+func (s *Server) pop(w writer, op store.OpType, key string) {
+	res, err := s.apply(store.Command{Op: op, Key: key})
+	if err != nil {
+		applyError(w, err)
+		return
+	}
+	if !res.Applied {
+		w.null()
+		return
+	}
+	w.bulk(string(res.Value))
+}
+
+// lrange handles LRANGE key start stop, where start and stop are inclusive
+// and count from the end of the list if negative.
+func (s *Server) lrange(w writer, args []string) {
+	start, err1 := strconv.Atoi(args[2])
+	stop, err2 := strconv.Atoi(args[3])
+	if err1 != nil || err2 != nil {
+		w.error("ERR value is not an integer or out of range")
+		return
+	}
+
+	entry, ok := s.lookup(w, args[1], store.TypeList, func() { w.array(0) })
+	if !ok {
+		return
+	}
+
+	n := len(entry.List)
+	if start < 0 {
+		start = max(n+start, 0)
+	}
+	if stop < 0 {
+		stop += n
+	}
+	stop = min(stop+1, n)
+	if start >= stop {
+		w.array(0)
+		return
+	}
+
+	w.array(stop - start)
+	for _, elem := range entry.List[start:stop] {
+		w.bulk(string(elem))
+	}
+}
+
+// sadd handles SADD key member [member ...].
+func (s *Server) sadd(w writer, args []string) {
+	s.members(w, store.OpTypeSAdd, args)
+}
+
+// srem handles SREM key member [member ...].
+func (s *Server) srem(w writer, args []string) {
+	s.members(w, store.OpTypeSRem, args)
+}
+
+func (s *Server) members(w writer, op store.OpType, args []string) {
+	var ops []store.Command
+	for _, member := range args[2:] {
+		ops = append(ops, store.Command{Op: op, Key: args[1], Value: []byte(member)})
+	}
+	s.countApplied(w, ops)
+}
+
+// smembers replies with the members of a set in lexical order.
+func (s *Server) smembers(w writer, args []string) {
+	entry, ok := s.lookup(w, args[1], store.TypeSet, func() { w.array(0) })
+	if !ok {
+		return
+	}
+
+	w.array(len(entry.Set))
+	for _, m := range slices.Sorted(maps.Keys(entry.Set)) {
+		w.bulk(m)
+	}
+}
+
+func (s *Server) sismember(w writer, args []string) {
+	entry, ok := s.lookup(w, args[1], store.TypeSet, func() { w.integer(0) })
+	if !ok {
+		return
+	}
+
+	if _, ok := entry.Set[args[2]]; ok {
+		w.integer(1)
+		return
+	}
+	w.integer(0)
+}
@@ -0,0 +1,108 @@
+package resp
+
+import (
+	"testing"
+
+	"github.com/thanhqng1510/dbdb/store"
+	"github.com/thanhqng1510/dbdb/store/storetest"
+)
+
+func TestHash(t *testing.T) {
+	m := &storetest.Fake{Entries: map[string]store.Entry{
+		"h": {Type: store.TypeHash, Hash: map[string][]byte{"b": []byte("2"), "a": []byte("1")}},
+	}}
+	if got := roundTrip(t, m, "HGET", "h", "a"); got != "$1\r\n1\r\n" {
+		t.Errorf("unexpected reply %q", got)
+	}
+	if got := roundTrip(t, m, "HGET", "h", "c"); got != "$-1\r\n" {
+		t.Errorf("unexpected reply %q", got)
+	}
+	if got := roundTrip(t, m, "HGETALL", "h"); got != "*4\r\n$1\r\na\r\n$1\r\n1\r\n$1\r\nb\r\n$1\r\n2\r\n" {
+		t.Errorf("unexpected reply %q", got)
+	}
+
+	m.ApplyResult = &store.Result{Applied: true, Results: []store.Result{{Applied: true, Created: true}, {Applied: true}}}
+	if got := roundTrip(t, m, "HSET", "h", "c", "3", "a", "4"); got != ":1\r\n" {
+		t.Errorf("unexpected reply %q", got)
+	}
+	if c := m.Applied[0]; c.Op != store.OpTypeTxn || len(c.Then) != 2 || c.Then[1].Field != "a" || string(c.Then[1].Value) != "4" {
+		t.Errorf("expected fields to be set in one transaction, got %+v", c)
+	}
+	if got := roundTrip(t, m, "HSET", "h", "c"); got != "-ERR wrong number of arguments for 'hset' command\r\n" {
+		t.Errorf("unexpected reply %q", got)
+	}
+}
+
+func TestList(t *testing.T) {
+	m := &storetest.Fake{Entries: map[string]store.Entry{
+		"l": {Type: store.TypeList, List: [][]byte{[]byte("a"), []byte("b"), []byte("c")}},
+	}}
+	tests := []struct {
+		start, stop string
+		want        string
+	}{
+		{"0", "-1", "*3\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n"},
+		{"1", "1", "*1\r\n$1\r\nb\r\n"},
+		{"-2", "10", "*2\r\n$1\r\nb\r\n$1\r\nc\r\n"},
+		{"2", "1", "*0\r\n"},
+	}
+	for _, tt := range tests {
+		if got := roundTrip(t, m, "LRANGE", "l", tt.start, tt.stop); got != tt.want {
+			t.Errorf("LRANGE %s %s: expected %q, got %q", tt.start, tt.stop, tt.want, got)
+		}
+	}
+
+	m.ApplyResult = &store.Result{Applied: true, Results: []store.Result{{Applied: true, Count: 4}, {Applied: true, Count: 5}}}
+	if got := roundTrip(t, m, "RPUSH", "l", "d", "e"); got != ":5\r\n" {
+		t.Errorf("unexpected reply %q", got)
+	}
+
+	m.ApplyResult = &store.Result{Applied: true, Value: []byte("a")}
+	if got := roundTrip(t, m, "LPOP", "l"); got != "$1\r\na\r\n" {
+		t.Errorf("unexpected reply %q", got)
+	}
+	m.ApplyResult = &store.Result{Applied: false}
+	if got := roundTrip(t, m, "RPOP", "empty"); got != "$-1\r\n" {
+		t.Errorf("unexpected reply %q", got)
+	}
+}
+
+func TestSet(t *testing.T) {
+	m := &storetest.Fake{Entries: map[string]store.Entry{
+		"s": {Type: store.TypeSet, Set: map[string]struct{}{"y": {}, "x": {}}},
+	}}
+	if got := roundTrip(t, m, "SMEMBERS", "s"); got != "*2\r\n$1\r\nx\r\n$1\r\ny\r\n" {
+		t.Errorf("unexpected reply %q", got)
+	}
+	if got := roundTrip(t, m, "SISMEMBER", "s", "x"); got != ":1\r\n" {
+		t.Errorf("unexpected reply %q", got)
+	}
+	if got := roundTrip(t, m, "SISMEMBER", "s", "z"); got != ":0\r\n" {
+		t.Errorf("unexpected reply %q", got)
+	}
+
+	m.ApplyResult = &store.Result{Applied: true, Results: []store.Result{{Applied: false}, {Applied: true}}}
+	if got := roundTrip(t, m, "SADD", "s", "x", "z"); got != ":1\r\n" {
+		t.Errorf("unexpected reply %q", got)
+	}
+}
+
+func TestWrongType(t *testing.T) {
+	m := &storetest.Fake{
+		Entries: map[string]store.Entry{
+			"str": {Value: []byte("v")},
+			"h":   {Type: store.TypeHash, Hash: map[string][]byte{"a": []byte("1")}},
+		},
+		ApplyErr: store.ErrWrongType,
+	}
+	want := "-" + errWrongType + "\r\n"
+	if got := roundTrip(t, m, "GET", "h"); got != want {
+		t.Errorf("unexpected reply %q", got)
+	}
+	if got := roundTrip(t, m, "SMEMBERS", "str"); got != want {
+		t.Errorf("unexpected reply %q", got)
+	}
+	if got := roundTrip(t, m, "LPUSH", "h", "a"); got != want {
+		t.Errorf("unexpected reply %q", got)
+	}
+}
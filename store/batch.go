@@ -0,0 +1,208 @@
+package store
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// proposal is a command waiting on the leader to be replicated in a batch.
+type proposal struct {
+	cmd  []byte
+	done chan proposalResult
+
+	// Stored keys the command reads or writes
+	keys []string
+}
+
+type proposalResult struct {
+	res *Result
+	err error
+}
+
+// batcher coalesces the commands proposed concurrently on the leader into
+// batch entries, so that they are replicated and written to disk together.
+// A batch is sent once it holds maxSize commands, its first command has
+// waited for linger, or the next command touches a key of the batch, and the
+// next batch is collected while it commits.
+type batcher struct {
+	s         *Store
+	proposals chan *proposal
+	maxSize   int
+	linger    time.Duration
+}
+
+func newBatcher(s *Store, maxSize int, linger time.Duration) *batcher {
+	return &batcher{s: s, proposals: make(chan *proposal, maxSize), maxSize: maxSize, linger: linger}
+}
+
+// ownEntry reports whether a command must be proposed in its own entry.
+// Leases, messages and receipts are identified by the Raft index of the
+// command creating them, which commands of a batch share. Revoke and
+// namespace commands write keys they do not name, which a batch cannot
+// keep apart from the other writes of those keys.
+func ownEntry(c Command) bool {
+	switch c.Op {
+	case OpTypeGrant, OpTypeEnqueue, OpTypeDequeue, OpTypeRevoke, OpTypeNsCreate, OpTypeNsUpdate, OpTypeNsDelete:
+		return true
+	}
+	return ordered(c)
+}
+
+// ordered reports whether a command or one of its then and else commands
+// creates a key only if it is missing, or attaches a key to a lease. Locks
+// and elections order such keys by version, so two of them must not share
+// the index of a batch.
+func ordered(c Command) bool {
+	if c.Cond == CondNotExists || c.Lease != 0 {
+		return true
+	}
+	for _, cmp := range c.Compare {
+		if cmp.Target == CompareExists || (cmp.Target == CompareVersion && cmp.Version == 0) {
+			return true
+		}
+	}
+	return slices.ContainsFunc(slices.Concat(c.Then, c.Else), ordered)
+}
+
+// touchedKeys returns the stored keys a command and its comparisons and then
+// and else commands read or write.
+func touchedKeys(c Command) []string {
+	keys := []string{nsKey(c.Namespace, c.Key)}
+	for _, cmp := range c.Compare {
+		keys = append(keys, nsKey(c.Namespace, cmp.Key))
+	}
+	for _, op := range slices.Concat(c.Then, c.Else) {
+		op.Namespace = c.Namespace
+		keys = append(keys, touchedKeys(op)...)
+	}
+	return keys
+}
+
+// propose queues an encoded command for the next batch and waits for its
+// result.
+func (b *batcher) propose(c Command, cmd []byte) (*Result, error) {
+	p := &proposal{cmd: cmd, done: make(chan proposalResult, 1), keys: touchedKeys(c)}
+
+	// Proposals left in the queue are dropped once the store is shut down
+	select {
+	case b.proposals <- p:
+	case <-b.s.done:
+		return nil, fmt.Errorf("could not perform apply command via Raft: %w", raft.ErrRaftShutdown)
+	}
+	select {
+	case r := <-p.done:
+		return r.res, r.err
+	case <-b.s.done:
+		return nil, fmt.Errorf("could not perform apply command via Raft: %w", raft.ErrRaftShutdown)
+	}
+}
+
+// run collects and sends batches until the store is shut down.
+// Commands of a batch share the Raft index of its entry as version, so a
+// command touching a key of the batch starts the next one. Otherwise two
+// writes of a key would get the same version, and a client could overwrite
+// a value it never read by checking the version of its own write.
+func (b *batcher) run() {
+	var carried *proposal
+	for {
+		p := carried
+		if p == nil {
+			select {
+			case p = <-b.proposals:
+			case <-b.s.done:
+				return
+			}
+		}
+		carried = nil
+
+		batch := []*proposal{p}
+		keys := make(map[string]bool)
+		for _, key := range p.keys {
+			keys[key] = true
+		}
+
+		var deadline <-chan time.Time
+		if b.linger > 0 {
+			deadline = time.After(b.linger)
+		}
+		for len(batch) < b.maxSize {
+			p := b.next(deadline)
+			if p == nil {
+				break
+			}
+			if slices.ContainsFunc(p.keys, func(key string) bool { return keys[key] }) {
+				carried = p
+				break
+			}
+			batch = append(batch, p)
+			for _, key := range p.keys {
+				keys[key] = true
+			}
+		}
+
+		b.send(batch)
+	}
+}
+
+// next returns the next queued proposal, waiting for one until the deadline
+// if there is none. It returns nil once the deadline has passed, or right
+// away without a deadline.
+func (b *batcher) next(deadline <-chan time.Time) *proposal {
+	select {
+	case p := <-b.proposals:
+		return p
+	default:
+	}
+	if deadline == nil {
+		return nil
+	}
+
+	select {
+	case p := <-b.proposals:
+		return p
+	case <-deadline:
+		return nil
+	}
+}
+
+// send appends a batch to the Raft log and fans its results out to the
+// proposers once it is applied. It only waits for the batch to be queued
+// by Raft, not committed.
+func (b *batcher) send(batch []*proposal) {
+	if len(batch) == 1 {
+		future := b.s.raft.Apply(batch[0].cmd, time.Duration(b.s.applyTimeout.Load()))
+		go func() {
+			res, err := b.s.result(future)
+			batch[0].done <- proposalResult{res, err}
+		}()
+		return
+	}
+
+	cmds := make([][]byte, len(batch))
+	for i, p := range batch {
+		cmds[i] = p.cmd
+	}
+	future := b.s.raft.Apply(encodeBatch(cmds), time.Duration(b.s.applyTimeout.Load()))
+	go func() {
+		if err := future.Error(); err != nil {
+			err = fmt.Errorf("could not perform apply command via Raft: %w", b.s.raftError(err))
+			for _, p := range batch {
+				p.done <- proposalResult{nil, err}
+			}
+			return
+		}
+
+		responses, ok := future.Response().([]any)
+		for i, p := range batch {
+			if !ok || i >= len(responses) {
+				p.done <- proposalResult{nil, fmt.Errorf("FSM error on apply command: unexpected batch response %v", future.Response())}
+				continue
+			}
+			res, err := fsmResult(responses[i])
+			p.done <- proposalResult{res, err}
+		}
+	}()
+}
@@ -0,0 +1,294 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+func TestApply_Batch(t *testing.T) {
+	kf := newTestFsm()
+
+	var cmds [][]byte
+	for _, c := range []Command{
+		{Op: OpTypeSet, Key: "a", Value: []byte("x")},
+		{Op: OpTypeIncr, Key: "a", Delta: 1},
+		{Op: OpTypeSet, Key: "b", Value: []byte("1")},
+	} {
+		cmd, err := encodeCommand(c)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		cmds = append(cmds, cmd)
+	}
+
+	responses, ok := kf.Apply(&raft.Log{Type: raft.LogCommand, Index: 7, Data: encodeBatch(cmds)}).([]any)
+	if !ok || len(responses) != 3 {
+		t.Fatalf("expected a response per command, got %#v", responses)
+	}
+	if res, ok := responses[0].(*Result); !ok || !res.Applied || res.Version != 7 {
+		t.Errorf("expected first command to apply at the index of the entry, got %#v", responses[0])
+	}
+	if err, ok := responses[1].(error); !ok || !errors.Is(err, ErrNotInteger) {
+		t.Errorf("expected ErrNotInteger, got %#v", responses[1])
+	}
+	if res, ok := responses[2].(*Result); !ok || !res.Applied {
+		t.Errorf("expected a failed command not to affect the next one, got %#v", responses[2])
+	}
+	if e, _ := kf.load("a", 0); string(e.Value) != "x" {
+		t.Errorf("expected a to be x, got %q", e.Value)
+	}
+
+	for _, data := range [][]byte{
+		{codecBatch, 1},
+		{codecBatch, 1, 1, '{'},
+		append(encodeBatch(cmds), 0),
+	} {
+		if _, ok := kf.Apply(&raft.Log{Type: raft.LogCommand, Index: 8, Data: data}).(error); !ok {
+			t.Errorf("%v: expected malformed batch to fail", data)
+		}
+	}
+}
+
+// newTestStore returns a store backed by a single in-memory Raft node that is
+// the leader, with short Raft timeouts.
+func newTestStore(t *testing.T, cfg Config) *Store {
+	t.Helper()
+
+	cfg.Raft = DefaultRaftConfig()
+	cfg.Raft.HeartbeatTimeout = 50 * time.Millisecond
+	cfg.Raft.ElectionTimeout = 50 * time.Millisecond
+	cfg.Raft.LeaderLeaseTimeout = 50 * time.Millisecond
+	cfg.Raft.LogLevel = "error"
+
+	s := &Store{config: cfg, fsm: newKvFsm(), logger: newRaftLogger(cfg.Raft), done: make(chan struct{})}
+	s.applyTimeout.Store(int64(cfg.Raft.ApplyTimeout))
+	raftCfg := cfg.Raft.raftConfig("node1", s.logger)
+	raftCfg.CommitTimeout = 5 * time.Millisecond
+
+	logs := raft.NewInmemStore()
+	_, transport := raft.NewInmemTransport("node1")
+	r, err := raft.NewRaft(raftCfg, s.fsm, logs, logs, raft.NewInmemSnapshotStore(), transport)
+	if err != nil {
+		t.Fatalf("could not create raft: %v", err)
+	}
+	s.raft = r
+	t.Cleanup(func() { s.Shutdown() })
+
+	r.BootstrapCluster(raft.Configuration{Servers: []raft.Server{{ID: "node1", Address: transport.LocalAddr()}}})
+	select {
+	case <-r.LeaderCh():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("node did not become leader")
+	}
+
+	if cfg.MaxBatchSize > 1 {
+		s.batcher = newBatcher(s, cfg.MaxBatchSize, cfg.BatchLinger)
+		go s.batcher.run()
+	}
+	return s
+}
+
+func TestStore_Batching(t *testing.T) {
+	s := newTestStore(t, Config{MaxBatchSize: 8, BatchLinger: 20 * time.Millisecond})
+	start := s.raft.LastIndex()
+
+	const n = 32
+	results := make([]*Result, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = s.propose(Command{Op: OpTypeSet, Key: fmt.Sprintf("k%d", i), Value: []byte("v")})
+		}()
+	}
+	wg.Wait()
+
+	for i := range n {
+		if errs[i] != nil || !results[i].Applied {
+			t.Fatalf("command %d: expected to apply, got %+v, %v", i, results[i], errs[i])
+		}
+		if e, _ := s.Get(fmt.Sprintf("k%d", i)); e.Version != results[i].Version {
+			t.Errorf("command %d: expected version %d, got %d", i, results[i].Version, e.Version)
+		}
+	}
+	if entries := s.raft.LastIndex() - start; entries >= n {
+		t.Errorf("expected commands to be coalesced, got %d entries for %d commands", entries, n)
+	}
+
+	// Commands creating leases get their own entry and lease ID
+	leases := make([]uint64, 3)
+	for i := range leases {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := s.propose(Command{Op: OpTypeGrant, Ttl: 1000})
+			if err != nil {
+				t.Errorf("unexpected error %v", err)
+				return
+			}
+			leases[i] = res.Lease
+		}()
+	}
+	wg.Wait()
+	ids := make(map[uint64]bool)
+	for _, id := range leases {
+		ids[id] = true
+	}
+	if len(ids) != 3 {
+		t.Errorf("expected distinct lease IDs, got %v", ids)
+	}
+}
+
+func TestStore_BatchingSameKey(t *testing.T) {
+	s := newTestStore(t, Config{MaxBatchSize: 8, BatchLinger: 20 * time.Millisecond})
+	start := s.raft.LastIndex()
+
+	// Writes of the same key, directly or in a transaction, get distinct
+	// versions, while writes of other keys are still coalesced
+	cmds := []Command{
+		{Op: OpTypeSet, Key: "k", Value: []byte("1")},
+		{Op: OpTypeSet, Key: "k", Value: []byte("2")},
+		{Op: OpTypeTxn, Compare: []Compare{{Key: "k", Target: CompareExists, Op: CompareEqual, Exists: true}},
+			Then: []Command{{Op: OpTypeSet, Key: "j", Value: []byte("3")}}},
+		{Op: OpTypeTxn, Then: []Command{{Op: OpTypeSet, Key: "k", Value: []byte("4")}}},
+		{Op: OpTypeSet, Key: "a", Value: []byte("5")},
+		{Op: OpTypeSet, Key: "b", Value: []byte("6")},
+		{Op: OpTypeSet, Key: "c", Value: []byte("7")},
+	}
+	results := make([]*Result, len(cmds))
+	var wg sync.WaitGroup
+	for i, c := range cmds {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := s.propose(c)
+			if err != nil {
+				t.Errorf("command %d: unexpected error %v", i, err)
+				return
+			}
+			results[i] = res
+		}()
+	}
+	wg.Wait()
+	if t.Failed() {
+		return
+	}
+
+	versions := make(map[uint64]bool)
+	for _, v := range []uint64{results[0].Version, results[1].Version, results[3].Results[0].Version} {
+		if v == 0 || versions[v] {
+			t.Errorf("expected writes of k to get distinct versions, got %d and %v", v, versions)
+		}
+		versions[v] = true
+	}
+	if entries := s.raft.LastIndex() - start; entries >= uint64(len(cmds)) {
+		t.Errorf("expected commands of other keys to be coalesced, got %d entries for %d commands", entries, len(cmds))
+	}
+}
+
+func TestStore_BatchingOrdered(t *testing.T) {
+	s := newTestStore(t, Config{MaxBatchSize: 8, BatchLinger: 20 * time.Millisecond})
+
+	// Candidates of an election are ordered by the version of their key, so
+	// campaigns on different keys must not share one
+	const n = 4
+	versions := make([]uint64, n)
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			key := fmt.Sprintf("candidate%d", i)
+			res, err := s.propose(Command{
+				Op:      OpTypeTxn,
+				Compare: []Compare{{Key: key, Target: CompareExists, Op: CompareEqual, Exists: false}},
+				Then:    []Command{{Op: OpTypeSet, Key: key, Value: []byte("v")}},
+			})
+			if err != nil {
+				t.Errorf("unexpected error %v", err)
+				return
+			}
+			versions[i] = res.Results[0].Version
+		}()
+	}
+	wg.Wait()
+
+	seen := make(map[uint64]bool)
+	for _, v := range versions {
+		if seen[v] {
+			t.Errorf("expected campaigns to get distinct versions, got %v", versions)
+		}
+		seen[v] = true
+	}
+
+	for _, c := range []Command{
+		{Op: OpTypeSet, Key: "k", Value: []byte("v"), Cond: CondNotExists},
+		{Op: OpTypeSet, Key: "k", Value: []byte("v"), Lease: 1},
+		{Op: OpTypeTxn, Compare: []Compare{{Key: "k", Target: CompareVersion, Op: CompareEqual}}},
+		{Op: OpTypeTxn, Then: []Command{{Op: OpTypeSet, Key: "k", Value: []byte("v"), Lease: 1}}},
+		{Op: OpTypeRevoke, Lease: 1},
+		{Op: OpTypeNsDelete, Key: "ns"},
+	} {
+		if !ownEntry(c) {
+			t.Errorf("%+v: expected command to get its own entry", c)
+		}
+	}
+	if ownEntry(Command{Op: OpTypeSet, Key: "k", Value: []byte("v")}) {
+		t.Errorf("expected a plain set to be batched")
+	}
+}
+
+func TestStore_BatchingShutdown(t *testing.T) {
+	s := newTestStore(t, Config{MaxBatchSize: 8})
+	if err := s.Shutdown(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// The batcher has stopped, so proposals fail rather than wait for it
+	cmd, _ := encodeCommand(Command{Op: OpTypeSet, Key: "k", Value: []byte("v")})
+	for range 2 * s.config.MaxBatchSize {
+		if _, err := s.batcher.propose(Command{Op: OpTypeSet, Key: "k"}, cmd); !errors.Is(err, raft.ErrRaftShutdown) {
+			t.Fatalf("expected ErrRaftShutdown, got %v", err)
+		}
+	}
+}
+
+func TestApplyBatch(t *testing.T) {
+	var kf raft.BatchingFSM = newTestFsm()
+
+	set, _ := encodeCommand(Command{Op: OpTypeSet, Key: "a", Value: []byte("1")})
+	incr, _ := encodeCommand(Command{Op: OpTypeIncr, Key: "a", Delta: 1})
+	batch := encodeBatch([][]byte{incr, incr})
+	responses := kf.ApplyBatch([]*raft.Log{
+		{Type: raft.LogCommand, Index: 1, Data: set},
+		{Type: raft.LogConfiguration, Index: 2},
+		{Type: raft.LogCommand, Index: 3, Data: []byte(`{"op": "set", "key": ""}`)},
+		{Type: raft.LogCommand, Index: 4, Data: batch},
+	})
+
+	if len(responses) != 4 {
+		t.Fatalf("expected a response per entry, got %d", len(responses))
+	}
+	if res, ok := responses[0].(*Result); !ok || res.Version != 1 {
+		t.Errorf("expected set to apply at index 1, got %#v", responses[0])
+	}
+	if responses[1] != nil {
+		t.Errorf("expected no response to configuration entry, got %#v", responses[1])
+	}
+	if _, ok := responses[2].(error); !ok {
+		t.Errorf("expected invalid legacy command to fail, got %#v", responses[2])
+	}
+	if results, ok := responses[3].([]any); !ok || len(results) != 2 || string(results[1].(*Result).Value) != "3" {
+		t.Errorf("expected batch to increment twice, got %#v", responses[3])
+	}
+	if index := kf.(*kvFsm).index; index != 4 {
+		t.Errorf("expected applied index 4, got %d", index)
+	}
+}
@@ -0,0 +1,577 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Commands are written to the Raft log in a compact binary encoding:
+//
+//	version (1 byte) | op (1 byte) | field mask (uvarint) | key | fields...
+//
+// The key is always present and length-prefixed. The other fields are only
+// present if their bit is set in the field mask, in the order of the bits.
+// Strings are length-prefixed with a uvarint, signed integers are varints and
+// unsigned integers are uvarints.
+//
+// Transactions carry their comparisons and commands as length-prefixed lists,
+// with each command encoded as above.
+//
+// Independent commands coalesced by the leader into one entry are written
+// as a batch, with its own version byte and the commands as a length-prefixed
+// list of commands encoded as above:
+//
+//	batch version (1 byte) | count (uvarint) | command...
+//
+// Entries written by older versions are JSON objects, which are recognized
+// by their leading '{' and decoded as such so that existing logs still replay.
+const (
+	codecV1    byte = 1
+	codecBatch byte = 2
+)
+
+const (
+	fieldValue uint64 = 1 << iota
+	fieldTtl
+	fieldCond
+	fieldDelta
+	fieldVersion
+	fieldFlags
+	fieldRevision
+	fieldCompare
+	fieldThen
+	fieldElse
+	fieldLease
+	fieldReceipt
+	fieldField
+	fieldPath
+	fieldIndex
+	fieldMaxKeys
+	fieldMaxBytes
+	fieldTokenHash
+	fieldNamespace
+)
+
+var opCodes = map[OpType]byte{
+	OpTypeSet:         1,
+	OpTypeDelete:      2,
+	OpTypeIncr:        3,
+	OpTypeCompact:     4,
+	OpTypeTxn:         5,
+	OpTypeDecr:        6,
+	OpTypeAdd:         7,
+	OpTypeGrant:       8,
+	OpTypeKeepAlive:   9,
+	OpTypeRevoke:      10,
+	OpTypeEnqueue:     11,
+	OpTypeDequeue:     12,
+	OpTypeAck:         13,
+	OpTypePeek:        14,
+	OpTypeHSet:        15,
+	OpTypeHDel:        16,
+	OpTypeLPush:       17,
+	OpTypeRPush:       18,
+	OpTypeLPop:        19,
+	OpTypeRPop:        20,
+	OpTypeSAdd:        21,
+	OpTypeSRem:        22,
+	OpTypeJSet:        23,
+	OpTypeJDel:        24,
+	OpTypeJMerge:      25,
+	OpTypeCreateIndex: 26,
+	OpTypeDropIndex:   27,
+	OpTypeNsCreate:    28,
+	OpTypeNsUpdate:    29,
+	OpTypeNsDelete:    30,
+	opTypeNode:        31,
+}
+
+var condCodes = map[CondType]byte{
+	CondNotExists: 1,
+	CondExists:    2,
+}
+
+var compareTargetCodes = map[CompareTarget]byte{
+	CompareValue:   1,
+	CompareVersion: 2,
+	CompareExists:  3,
+}
+
+var compareOpCodes = map[CompareOp]byte{
+	CompareEqual:    1,
+	CompareNotEqual: 2,
+	CompareLess:     3,
+	CompareGreater:  4,
+}
+
+var (
+	opTypes            = invert(opCodes)
+	condTypes          = invert(condCodes)
+	compareTargetTypes = invert(compareTargetCodes)
+	compareOpTypes     = invert(compareOpCodes)
+)
+
+var errTruncated = errors.New("truncated command")
+
+// encodeCommand returns the binary encoding of a command.
+func encodeCommand(c Command) ([]byte, error) {
+	op, ok := opCodes[c.Op]
+	if !ok {
+		return nil, fmt.Errorf("unknown op type: %s", c.Op)
+	}
+
+	var mask uint64
+	if c.Value != nil {
+		mask |= fieldValue
+	}
+	if c.Ttl != 0 {
+		mask |= fieldTtl
+	}
+	if c.Cond != "" {
+		mask |= fieldCond
+	}
+	if c.Delta != 0 {
+		mask |= fieldDelta
+	}
+	if c.Version != 0 {
+		mask |= fieldVersion
+	}
+	if c.Flags != 0 {
+		mask |= fieldFlags
+	}
+	if c.Revision != 0 {
+		mask |= fieldRevision
+	}
+	if len(c.Compare) != 0 {
+		mask |= fieldCompare
+	}
+	if len(c.Then) != 0 {
+		mask |= fieldThen
+	}
+	if len(c.Else) != 0 {
+		mask |= fieldElse
+	}
+	if c.Lease != 0 {
+		mask |= fieldLease
+	}
+	if c.Receipt != 0 {
+		mask |= fieldReceipt
+	}
+	if c.Field != "" {
+		mask |= fieldField
+	}
+	if c.Path != "" {
+		mask |= fieldPath
+	}
+	if c.Index != "" {
+		mask |= fieldIndex
+	}
+	if c.MaxKeys != 0 {
+		mask |= fieldMaxKeys
+	}
+	if c.MaxBytes != 0 {
+		mask |= fieldMaxBytes
+	}
+	if c.TokenHash != "" {
+		mask |= fieldTokenHash
+	}
+	if c.Namespace != "" {
+		mask |= fieldNamespace
+	}
+
+	buf := make([]byte, 0, 16+len(c.Key)+len(c.Value))
+	buf = append(buf, codecV1, op)
+	buf = binary.AppendUvarint(buf, mask)
+	buf = appendString(buf, c.Key)
+	if mask&fieldValue != 0 {
+		buf = appendBytes(buf, c.Value)
+	}
+	if mask&fieldTtl != 0 {
+		buf = binary.AppendVarint(buf, c.Ttl)
+	}
+	if mask&fieldCond != 0 {
+		cond, ok := condCodes[c.Cond]
+		if !ok {
+			return nil, fmt.Errorf("unknown condition: %s", c.Cond)
+		}
+		buf = append(buf, cond)
+	}
+	if mask&fieldDelta != 0 {
+		buf = binary.AppendVarint(buf, c.Delta)
+	}
+	if mask&fieldVersion != 0 {
+		buf = binary.AppendUvarint(buf, c.Version)
+	}
+	if mask&fieldFlags != 0 {
+		buf = binary.AppendUvarint(buf, uint64(c.Flags))
+	}
+	if mask&fieldRevision != 0 {
+		buf = binary.AppendUvarint(buf, c.Revision)
+	}
+	if mask&fieldCompare != 0 {
+		buf = binary.AppendUvarint(buf, uint64(len(c.Compare)))
+		for _, cmp := range c.Compare {
+			target, ok := compareTargetCodes[cmp.Target]
+			if !ok {
+				return nil, fmt.Errorf("unknown compare target: %s", cmp.Target)
+			}
+			op, ok := compareOpCodes[cmp.Op]
+			if !ok {
+				return nil, fmt.Errorf("unknown compare op: %s", cmp.Op)
+			}
+
+			buf = appendString(buf, cmp.Key)
+			buf = append(buf, target, op)
+			buf = appendBytes(buf, cmp.Value)
+			buf = binary.AppendUvarint(buf, cmp.Version)
+			if cmp.Exists {
+				buf = append(buf, 1)
+			} else {
+				buf = append(buf, 0)
+			}
+		}
+	}
+	for _, ops := range [][]Command{c.Then, c.Else} {
+		if len(ops) == 0 {
+			continue
+		}
+		buf = binary.AppendUvarint(buf, uint64(len(ops)))
+		for _, op := range ops {
+			data, err := encodeCommand(op)
+			if err != nil {
+				return nil, err
+			}
+			buf = appendBytes(buf, data)
+		}
+	}
+	if mask&fieldLease != 0 {
+		buf = binary.AppendUvarint(buf, c.Lease)
+	}
+	if mask&fieldReceipt != 0 {
+		buf = binary.AppendUvarint(buf, c.Receipt)
+	}
+	if mask&fieldField != 0 {
+		buf = appendString(buf, c.Field)
+	}
+	if mask&fieldPath != 0 {
+		buf = appendString(buf, c.Path)
+	}
+	if mask&fieldIndex != 0 {
+		buf = appendString(buf, c.Index)
+	}
+	if mask&fieldMaxKeys != 0 {
+		buf = binary.AppendVarint(buf, c.MaxKeys)
+	}
+	if mask&fieldMaxBytes != 0 {
+		buf = binary.AppendVarint(buf, c.MaxBytes)
+	}
+	if mask&fieldTokenHash != 0 {
+		buf = appendString(buf, c.TokenHash)
+	}
+	if mask&fieldNamespace != 0 {
+		buf = appendString(buf, c.Namespace)
+	}
+	return buf, nil
+}
+
+// legacyCommand is a command in the legacy JSON encoding, which carried
+// values as plain strings rather than base64.
+type legacyCommand struct {
+	Op      OpType
+	Key     string
+	Value   string
+	Ttl     int64
+	Cond    CondType
+	Delta   int64
+	Version uint64
+	Flags   uint32
+}
+
+func (lc legacyCommand) command() Command {
+	c := Command{Op: lc.Op, Key: lc.Key, Ttl: lc.Ttl, Cond: lc.Cond, Delta: lc.Delta, Version: lc.Version, Flags: lc.Flags}
+	if lc.Value != "" {
+		c.Value = []byte(lc.Value)
+	}
+	return c
+}
+
+// decodeCommand decodes a command from a Raft log entry, in either the binary
+// or the legacy JSON encoding. It reports whether the entry was legacy JSON.
+func decodeCommand(data []byte) (Command, bool, error) {
+	if len(data) == 0 {
+		return Command{}, false, errTruncated
+	}
+
+	switch data[0] {
+	case '{', ' ', '\t', '\r', '\n':
+		var lc legacyCommand
+		if err := json.Unmarshal(data, &lc); err != nil {
+			return Command{}, true, err
+		}
+		return lc.command(), true, nil
+	case codecV1:
+		c, err := decodeV1(data[1:])
+		return c, false, err
+	}
+	return Command{}, false, fmt.Errorf("unknown command encoding version %d", data[0])
+}
+
+// encodeBatch returns the encoding of a batch of binary encoded commands.
+func encodeBatch(cmds [][]byte) []byte {
+	buf := []byte{codecBatch}
+	buf = binary.AppendUvarint(buf, uint64(len(cmds)))
+	for _, cmd := range cmds {
+		buf = appendBytes(buf, cmd)
+	}
+	return buf
+}
+
+// isBatch reports whether a Raft log entry holds a batch of commands.
+func isBatch(data []byte) bool {
+	return len(data) > 0 && data[0] == codecBatch
+}
+
+// decodeBatch decodes the commands of a batch entry.
+func decodeBatch(data []byte) ([]Command, error) {
+	d := decoder{data: data[1:]}
+	cmds := make([]Command, d.count())
+	for i := range cmds {
+		data := d.raw()
+		if d.err != nil {
+			break
+		}
+		if len(data) == 0 || data[0] != codecV1 {
+			return nil, errors.New("invalid command in batch")
+		}
+		c, err := decodeV1(data[1:])
+		if err != nil {
+			return nil, err
+		}
+		cmds[i] = c
+	}
+
+	if d.err != nil {
+		return nil, d.err
+	}
+	if len(d.data) != 0 {
+		return nil, fmt.Errorf("%d unexpected trailing bytes in batch", len(d.data))
+	}
+	return cmds, nil
+}
+
+func decodeV1(data []byte) (Command, error) {
+	d := decoder{data: data}
+
+	var c Command
+	op := d.byte()
+	if c.Op = opTypes[op]; c.Op == "" && d.err == nil {
+		return Command{}, fmt.Errorf("unknown op code %d", op)
+	}
+
+	mask := d.uvarint()
+	c.Key = d.string()
+	if mask&fieldValue != 0 {
+		c.Value = d.bytes()
+	}
+	if mask&fieldTtl != 0 {
+		c.Ttl = d.varint()
+	}
+	if mask&fieldCond != 0 {
+		cond := d.byte()
+		if c.Cond = condTypes[cond]; c.Cond == "" && d.err == nil {
+			return Command{}, fmt.Errorf("unknown condition code %d", cond)
+		}
+	}
+	if mask&fieldDelta != 0 {
+		c.Delta = d.varint()
+	}
+	if mask&fieldVersion != 0 {
+		c.Version = d.uvarint()
+	}
+	if mask&fieldFlags != 0 {
+		c.Flags = uint32(d.uvarint())
+	}
+	if mask&fieldRevision != 0 {
+		c.Revision = d.uvarint()
+	}
+	if mask&fieldCompare != 0 {
+		c.Compare = make([]Compare, d.count())
+		for i := range c.Compare {
+			cmp := &c.Compare[i]
+			cmp.Key = d.string()
+
+			target, op := d.byte(), d.byte()
+			if cmp.Target = compareTargetTypes[target]; cmp.Target == "" && d.err == nil {
+				return Command{}, fmt.Errorf("unknown compare target code %d", target)
+			}
+			if cmp.Op = compareOpTypes[op]; cmp.Op == "" && d.err == nil {
+				return Command{}, fmt.Errorf("unknown compare op code %d", op)
+			}
+
+			// Values are only carried by value comparisons
+			if v := d.bytes(); len(v) > 0 || cmp.Target == CompareValue {
+				cmp.Value = v
+			}
+			cmp.Version = d.uvarint()
+			cmp.Exists = d.byte() != 0
+		}
+	}
+	for _, f := range []struct {
+		bit uint64
+		ops *[]Command
+	}{{fieldThen, &c.Then}, {fieldElse, &c.Else}} {
+		if mask&f.bit == 0 {
+			continue
+		}
+		*f.ops = make([]Command, d.count())
+		for i := range *f.ops {
+			data := d.raw()
+			if d.err != nil {
+				break
+			}
+			if len(data) == 0 || data[0] != codecV1 {
+				return Command{}, errors.New("invalid command in transaction")
+			}
+			op, err := decodeV1(data[1:])
+			if err != nil {
+				return Command{}, err
+			}
+			(*f.ops)[i] = op
+		}
+	}
+	if mask&fieldLease != 0 {
+		c.Lease = d.uvarint()
+	}
+	if mask&fieldReceipt != 0 {
+		c.Receipt = d.uvarint()
+	}
+	if mask&fieldField != 0 {
+		c.Field = d.string()
+	}
+	if mask&fieldPath != 0 {
+		c.Path = d.string()
+	}
+	if mask&fieldIndex != 0 {
+		c.Index = d.string()
+	}
+	if mask&fieldMaxKeys != 0 {
+		c.MaxKeys = d.varint()
+	}
+	if mask&fieldMaxBytes != 0 {
+		c.MaxBytes = d.varint()
+	}
+	if mask&fieldTokenHash != 0 {
+		c.TokenHash = d.string()
+	}
+	if mask&fieldNamespace != 0 {
+		c.Namespace = d.string()
+	}
+
+	if d.err != nil {
+		return Command{}, d.err
+	}
+	if len(d.data) != 0 {
+		return Command{}, fmt.Errorf("%d unexpected trailing bytes in command", len(d.data))
+	}
+	return c, nil
+}
+
+// invert returns a map from the values to the keys of a one-to-one map.
+func invert[K, V comparable](m map[K]V) map[V]K {
+	inv := make(map[V]K, len(m))
+	for k, v := range m {
+		inv[v] = k
+	}
+	return inv
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+func appendBytes(buf []byte, b []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(b)))
+	return append(buf, b...)
+}
+
+// decoder reads binary encoded fields, remembering the first error so that
+// it only needs to be checked once all fields have been read.
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (d *decoder) byte() byte {
+	if d.err != nil || len(d.data) < 1 {
+		d.err = errTruncated
+		return 0
+	}
+	b := d.data[0]
+	d.data = d.data[1:]
+	return b
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.err = errTruncated
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.data)
+	if n <= 0 {
+		d.err = errTruncated
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+// count returns the length of a list, bounded by the remaining data
+// since every element takes at least one byte.
+func (d *decoder) count() int {
+	n := d.uvarint()
+	if n > uint64(len(d.data)) {
+		d.err = errTruncated
+		return 0
+	}
+	return int(n)
+}
+
+func (d *decoder) string() string {
+	return string(d.raw())
+}
+
+// bytes returns a copy of a length-prefixed byte slice, so that it
+// does not retain the buffer being decoded.
+func (d *decoder) bytes() []byte {
+	return bytes.Clone(d.raw())
+}
+
+func (d *decoder) raw() []byte {
+	n := d.uvarint()
+	if d.err != nil {
+		return nil
+	}
+	if uint64(len(d.data)) < n {
+		d.err = errTruncated
+		return nil
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b
+}
@@ -0,0 +1,114 @@
+package store
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/raft"
+)
+
+func TestCodec_RoundTrip(t *testing.T) {
+	tests := []Command{
+		{Op: OpTypeSet, Key: "k", Value: []byte("v")},
+		{Op: OpTypeSet, Key: "k", Value: []byte("v"), Ttl: 60000, Cond: CondNotExists, Version: 42, Flags: 7},
+		{Op: OpTypeDelete, Key: "k", Version: 1},
+		{Op: OpTypeIncr, Key: "n", Delta: -3},
+		{Op: OpTypeDecr, Key: "n", Delta: 1},
+		{Op: OpTypeAdd, Key: "n", Delta: math.MinInt64},
+		{Op: OpTypeCompact, Revision: 100},
+		{Op: OpTypeGrant, Ttl: 5000},
+		{Op: OpTypeHSet, Key: "h", Field: "f", Value: []byte("v")},
+		{Op: OpTypeJSet, Key: "j", Path: "/a/0", Value: []byte(`{"b":1}`)},
+		{Op: OpTypeCreateIndex, Key: "users/", Path: "/email", Index: "by_email"},
+		{Op: OpTypeNsCreate, Key: "team-a", MaxKeys: 100, MaxBytes: 1 << 20, TokenHash: strings.Repeat("ab", 32)},
+		{Op: OpTypeSet, Key: "k", Value: []byte("v"), Namespace: "team-a"},
+		{Op: OpTypeAck, Key: "q", Receipt: 7},
+		{Op: OpTypeRevoke, Lease: 12},
+		{Op: OpTypeSet, Key: "k", Value: []byte("v"), Lease: 12},
+		{
+			Op: OpTypeTxn,
+			Compare: []Compare{
+				{Key: "a", Target: CompareValue, Op: CompareEqual, Value: []byte{}},
+				{Key: "b", Target: CompareVersion, Op: CompareGreater, Version: 3},
+				{Key: "c", Target: CompareExists, Op: CompareNotEqual, Exists: true},
+			},
+			Then: []Command{{Op: OpTypeSet, Key: "a", Value: []byte("1")}, {Op: OpTypeDelete, Key: "b"}},
+			Else: []Command{{Op: OpTypeIncr, Key: "n", Delta: 1}},
+		},
+		{Op: OpTypeSet, Key: "empty", Value: []byte{}},
+		{Op: OpTypeSet, Key: "bin\x00ary", Value: []byte{0, 0xff, 0x80}},
+	}
+
+	for _, want := range tests {
+		data, err := encodeCommand(want)
+		if err != nil {
+			t.Fatalf("could not encode %+v: %v", want, err)
+		}
+
+		got, legacy, err := decodeCommand(data)
+		if err != nil || legacy {
+			t.Fatalf("could not decode %+v: legacy %t, err %v", want, legacy, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("expected %+v, got %+v", want, got)
+		}
+	}
+}
+
+func TestCodec_Legacy(t *testing.T) {
+	got, legacy, err := decodeCommand([]byte(`{"op": "set", "key": "x", "value": "23"}`))
+	if err != nil || !legacy {
+		t.Fatalf("expected legacy command, got legacy %t, err %v", legacy, err)
+	}
+	if want := (Command{Op: OpTypeSet, Key: "x", Value: []byte("23")}); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+}
+
+func TestCodec_Invalid(t *testing.T) {
+	data, _ := encodeCommand(Command{Op: OpTypeSet, Key: "key", Value: []byte("value")})
+
+	for name, data := range map[string][]byte{
+		"empty":            {},
+		"unknown version":  {0xff, 1},
+		"unknown op":       {codecV1, 0xff, 0},
+		"truncated":        data[:len(data)-1],
+		"trailing garbage": append(data, 0),
+	} {
+		if _, _, err := decodeCommand(data); err == nil {
+			t.Errorf("%s: expected error, got nil", name)
+		}
+	}
+}
+
+func TestApply_LegacyAndBinaryLogs(t *testing.T) {
+	kf := newTestFsm()
+
+	if res := kf.Apply(&raft.Log{Type: raft.LogCommand, Index: 1, Data: []byte(`{"op": "set", "key": "a", "value": "1"}`)}); res.(*Result).Version != 1 {
+		t.Errorf("expected legacy command to apply, got %v", res)
+	}
+
+	data, _ := encodeCommand(Command{Op: OpTypeSet, Key: "b", Value: []byte("2")})
+	if res := kf.Apply(&raft.Log{Type: raft.LogCommand, Index: 2, Data: data}); res.(*Result).Version != 2 {
+		t.Errorf("expected binary command to apply, got %v", res)
+	}
+
+	if res := kf.Apply(&raft.Log{Type: raft.LogCommand, Index: 3, Data: []byte(`{"op": "set", "key": ""}`)}); res == nil {
+		t.Errorf("expected invalid legacy command to be rejected")
+	} else if _, ok := res.(error); !ok {
+		t.Errorf("expected error, got %v", res)
+	}
+}
+
+func BenchmarkApply(b *testing.B) {
+	kf := newTestFsm()
+	data, _ := encodeCommand(Command{Op: OpTypeSet, Key: "key", Value: []byte("value")})
+	log := &raft.Log{Type: raft.LogCommand, Data: data}
+
+	for i := 0; i < b.N; i++ {
+		log.Index = uint64(i + 1)
+		kf.Apply(log)
+	}
+}
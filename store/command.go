@@ -0,0 +1,229 @@
+package store
+
+type OpType string
+
+const (
+	OpTypeSet    OpType = "set"
+	OpTypeDelete OpType = "del"
+	OpTypeIncr   OpType = "incr"
+	OpTypeDecr   OpType = "decr"
+	OpTypeAdd    OpType = "add"
+
+	// OpTypeCompact discards the history of all keys before a revision.
+	OpTypeCompact OpType = "compact"
+
+	// Lease ops grant a lease with a time to live, extend it, or revoke it
+	// along with the keys attached to it.
+	OpTypeGrant     OpType = "grant"
+	OpTypeKeepAlive OpType = "keepalive"
+	OpTypeRevoke    OpType = "revoke"
+
+	// Hash ops set or delete a field of the hash stored at the key
+	OpTypeHSet OpType = "hset"
+	OpTypeHDel OpType = "hdel"
+
+	// List ops push an element to the head (left) or tail (right) of the list
+	// stored at the key, or pop one from it
+	OpTypeLPush OpType = "lpush"
+	OpTypeRPush OpType = "rpush"
+	OpTypeLPop  OpType = "lpop"
+	OpTypeRPop  OpType = "rpop"
+
+	// Set ops add or remove a member of the set stored at the key
+	OpTypeSAdd OpType = "sadd"
+	OpTypeSRem OpType = "srem"
+
+	// JSON ops set, delete or merge-patch the value at a path of the JSON
+	// document stored at the key
+	OpTypeJSet   OpType = "jset"
+	OpTypeJDel   OpType = "jdel"
+	OpTypeJMerge OpType = "jmerge"
+
+	// Queue ops append a message to the queue named by the key, receive the
+	// first visible message and hide it for a visibility timeout, delete a
+	// received message, or return the first visible message.
+	OpTypeEnqueue OpType = "enqueue"
+	OpTypeDequeue OpType = "dequeue"
+	OpTypeAck     OpType = "ack"
+	OpTypePeek    OpType = "peek"
+
+	// Index ops create a secondary index on a field of the JSON values of
+	// the keys with a prefix, or drop it.
+	OpTypeCreateIndex OpType = "createindex"
+	OpTypeDropIndex   OpType = "dropindex"
+
+	// Namespace ops create a namespace, update its quotas and token, or
+	// delete it along with its keys.
+	OpTypeNsCreate OpType = "nscreate"
+	OpTypeNsUpdate OpType = "nsupdate"
+	OpTypeNsDelete OpType = "nsdelete"
+
+	// opTypeNode records the HTTP address of the node named by its key,
+	// carried in its value. It is proposed by the store, never by clients.
+	opTypeNode OpType = "node"
+
+	// OpTypeTxn runs either its then or else commands atomically, depending
+	// on whether all of its comparisons hold.
+	OpTypeTxn OpType = "txn"
+)
+
+// CondType restricts when a set command is allowed to take effect.
+type CondType string
+
+const (
+	// CondNotExists only applies the command if the key does not exist.
+	CondNotExists CondType = "nx"
+	// CondExists only applies the command if the key already exists.
+	CondExists CondType = "xx"
+)
+
+// Command is a single mutation of the key-value store. It is the JSON body
+// accepted by IStore.Apply and the payload carried in Raft logs.
+type Command struct {
+	Op OpType `json:"op" validate:"required,oneof=set del incr decr add hset hdel lpush rpush lpop rpop sadd srem jset jdel jmerge compact grant keepalive revoke enqueue dequeue ack peek createindex dropindex nscreate nsupdate nsdelete txn"`
+	// Key is also the name of the queue for queue commands, the prefix of
+	// the indexed keys for createindex commands, and the name of the
+	// namespace for namespace commands.
+	Key string `json:"key" validate:"required_unless=Op compact Op txn Op grant Op keepalive Op revoke Op createindex Op dropindex"`
+	// Values are carried as base64 in JSON. Value is also the element or
+	// member of list and set commands, and the JSON value or merge patch of
+	// jset and jmerge commands.
+	Value []byte `json:"value" validate:"required_if=Op set,required_if=Op hset,required_if=Op lpush,required_if=Op rpush,required_if=Op sadd,required_if=Op srem,required_if=Op enqueue,required_if=Op jset,required_if=Op jmerge"`
+
+	// Field of the hash for hset and hdel commands
+	Field string `json:"field,omitempty" validate:"required_if=Op hset,required_if=Op hdel"`
+
+	// JSON Pointer (RFC 6901) to the value of the document for JSON commands,
+	// or to the indexed field for createindex commands. The empty pointer
+	// refers to the whole document.
+	Path string `json:"path,omitempty" validate:"required_if=Op createindex"`
+
+	// Name of the index for createindex and dropindex commands
+	Index string `json:"index,omitempty" validate:"required_if=Op createindex,required_if=Op dropindex"`
+
+	// Quotas of the namespace for nscreate and nsupdate commands, zero for no limit
+	MaxKeys  int64 `json:"max_keys,omitempty" validate:"min=0"`
+	MaxBytes int64 `json:"max_bytes,omitempty" validate:"min=0"`
+
+	// SHA-256 hash of the token granting access to the namespace, hex
+	// encoded, for nscreate and nsupdate commands
+	TokenHash string `json:"token_hash,omitempty" validate:"omitempty,hexadecimal,len=64"`
+
+	// Namespace the command runs in, empty for the default namespace. It is
+	// set by the store view of the namespace rather than by clients.
+	Namespace string `json:"-"`
+
+	// Time to live of the key, of the lease for grant commands, or of the
+	// visibility timeout for dequeue commands, in milliseconds, counted from
+	// the time the leader appended the command to its log. Zero means the key
+	// never expires.
+	Ttl int64 `json:"ttl,omitempty" validate:"min=0,required_if=Op grant"`
+
+	// Optional condition for set commands
+	Cond CondType `json:"cond,omitempty" validate:"omitempty,oneof=nx xx,excluded_unless=Op set"`
+
+	// Amount added to the current value by incr and add commands, or
+	// subtracted from it by decr commands. It defaults to 1 for incr and decr.
+	Delta int64 `json:"delta,omitempty" validate:"required_if=Op add"`
+
+	// If non-zero, the command only applies if the key exists and its
+	// current version matches (compare-and-swap)
+	Version uint64 `json:"version,omitempty"`
+
+	// Opaque client flags stored alongside the value by set commands
+	Flags uint32 `json:"flags,omitempty"`
+
+	// Lease to attach the key to for set commands, so that it is deleted
+	// along with the lease, or the lease to extend or revoke
+	Lease uint64 `json:"lease,omitempty" validate:"required_if=Op keepalive,required_if=Op revoke"`
+
+	// Receipt of the received message to delete for ack commands
+	Receipt uint64 `json:"receipt,omitempty" validate:"required_if=Op ack"`
+
+	// Revision before which compact commands discard history
+	Revision uint64 `json:"revision,omitempty" validate:"required_if=Op compact,excluded_unless=Op compact"`
+
+	// Comparisons of a txn command, which all must hold for its then
+	// commands to run. Otherwise its else commands run.
+	Compare []Compare `json:"compare,omitempty" validate:"excluded_unless=Op txn,dive"`
+	Then    []Command `json:"then,omitempty" validate:"excluded_unless=Op txn,dive"`
+	Else    []Command `json:"else,omitempty" validate:"excluded_unless=Op txn,dive"`
+}
+
+// CompareTarget is the property of a key checked by a comparison.
+type CompareTarget string
+
+const (
+	CompareValue   CompareTarget = "value"
+	CompareVersion CompareTarget = "version"
+	CompareExists  CompareTarget = "exists"
+)
+
+// CompareOp is the operator of a comparison.
+type CompareOp string
+
+const (
+	CompareEqual    CompareOp = "eq"
+	CompareNotEqual CompareOp = "ne"
+	CompareLess     CompareOp = "lt"
+	CompareGreater  CompareOp = "gt"
+)
+
+// Compare is a comparison of the current state of a key in a txn command.
+// The version of a missing key is zero, and comparisons of its value never hold.
+type Compare struct {
+	Key    string        `json:"key" validate:"required"`
+	Target CompareTarget `json:"target" validate:"required,oneof=value version exists"`
+	Op     CompareOp     `json:"op" validate:"required,oneof=eq ne lt gt"`
+
+	// Operand of the comparison, depending on the target
+	Value   []byte `json:"value,omitempty" validate:"required_if=Target value"`
+	Version uint64 `json:"version,omitempty"`
+	Exists  bool   `json:"exists,omitempty"`
+}
+
+// Lookup is the result of reading a key.
+type Lookup struct {
+	Key   string
+	Entry Entry
+	Found bool
+}
+
+// Result is the outcome of applying a Command.
+type Result struct {
+	// Applied is false if the command had no effect, e.g. its condition
+	// did not hold or the key to delete did not exist.
+	Applied bool `json:"applied"`
+
+	// Conflict is true if the command was not applied because its
+	// condition (cond or version) did not hold.
+	Conflict bool `json:"conflict,omitempty"`
+
+	// Value is the new value of the key for commands that compute it (incr, decr, add).
+	Value []byte `json:"value,omitempty"`
+
+	// Version is the version of the key after the command. If the command
+	// was not applied, it is the current version, or zero if the key does not exist.
+	// For queue commands, it is the ID of the message.
+	Version uint64 `json:"version,omitempty"`
+
+	// Receipt identifies the delivery of a message by a dequeue command,
+	// to acknowledge it with an ack command.
+	Receipt uint64 `json:"receipt,omitempty"`
+
+	// Created is true if an hset command added a new field to the hash.
+	Created bool `json:"created,omitempty"`
+
+	// Count is the number of fields, elements or members of the hash, list
+	// or set after a command on it.
+	Count int `json:"count,omitempty"`
+
+	// Lease is the ID of the lease created by a grant command.
+	Lease uint64 `json:"lease,omitempty"`
+
+	// Succeeded is true if all comparisons of a txn command held.
+	Succeeded bool `json:"succeeded,omitempty"`
+
+	// Results of the commands run by a txn command, in order.
+	Results []Result `json:"results,omitempty"`
+}
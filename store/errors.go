@@ -0,0 +1,95 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/hashicorp/raft"
+)
+
+var (
+	// ErrNotLeader is returned for requests that must be served by the leader.
+	// Errors returned by the store are of type *NotLeaderError, which carries
+	// the current leader if it is known.
+	ErrNotLeader = errors.New("not the leader")
+
+	// ErrTimeout is returned if a command could not be committed in time, or
+	// leadership was lost while committing it. The command may still be applied.
+	ErrTimeout = errors.New("timed out committing command")
+
+	// ErrConflict is returned if the current value of a key prevents a command
+	// from being applied.
+	ErrConflict = errors.New("conflict with current value")
+
+	ErrNotInteger = fmt.Errorf("%w: value is not an integer", ErrConflict)
+	ErrOverflow   = fmt.Errorf("%w: increment would overflow", ErrConflict)
+	ErrWrongType  = fmt.Errorf("%w: key holds a value of another type", ErrConflict)
+
+	// ErrCollectionFull is returned for commands that would grow a hash, list
+	// or set beyond its maximum number of fields, elements or members.
+	ErrCollectionFull = fmt.Errorf("%w: collection is full", ErrConflict)
+
+	// ErrPathNotFound is returned for JSON commands whose path does not lead
+	// to a value of the document, or to an object or array to add it to.
+	ErrPathNotFound = fmt.Errorf("%w: path not found in document", ErrConflict)
+
+	// ErrLeaseNotFound is returned for commands on a lease that does not
+	// exist or has expired.
+	ErrLeaseNotFound = errors.New("lease not found")
+
+	// ErrNamespaceNotFound is returned for commands in a namespace that does not exist.
+	ErrNamespaceNotFound = errors.New("namespace not found")
+
+	// ErrQuotaExceeded is returned for commands that would take a namespace
+	// over its quota of keys or bytes.
+	ErrQuotaExceeded = errors.New("namespace quota exceeded")
+
+	// ErrIndexNotFound is returned for queries on an index that does not exist.
+	ErrIndexNotFound = errors.New("index not found")
+
+	// ErrCompacted is returned for reads at a revision whose history was discarded.
+	ErrCompacted = errors.New("revision has been compacted")
+
+	// ErrFutureRevision is returned for reads or compactions at a revision
+	// that has not been applied yet.
+	ErrFutureRevision = errors.New("revision is in the future")
+)
+
+// NotLeaderError is returned when a node that is not the leader receives
+// a request that must be served by the leader.
+type NotLeaderError struct {
+	// ID and Raft address of the current leader, empty if unknown
+	LeaderID   string
+	LeaderAddr string
+
+	// HTTP address of the current leader, empty if unknown or not recorded
+	LeaderHttpAddr string
+}
+
+func (e *NotLeaderError) Error() string {
+	if e.LeaderID == "" {
+		return "not the leader, leader is unknown"
+	}
+	return fmt.Sprintf("not the leader, leader is %s at %s", e.LeaderID, e.LeaderAddr)
+}
+
+func (e *NotLeaderError) Is(target error) bool {
+	return target == ErrNotLeader
+}
+
+// notLeader returns an error carrying the current leader of the cluster.
+func (s *Store) notLeader() error {
+	addr, id := s.raft.LeaderWithID()
+	return &NotLeaderError{LeaderID: string(id), LeaderAddr: string(addr), LeaderHttpAddr: s.fsm.nodeAddr(string(id))}
+}
+
+// raftError converts an error returned by a Raft future into a store error.
+func (s *Store) raftError(err error) error {
+	switch {
+	case errors.Is(err, raft.ErrNotLeader), errors.Is(err, raft.ErrLeadershipTransferInProgress):
+		return s.notLeader()
+	case errors.Is(err, raft.ErrEnqueueTimeout), errors.Is(err, raft.ErrLeadershipLost):
+		return fmt.Errorf("%w: %w", ErrTimeout, err)
+	}
+	return err
+}
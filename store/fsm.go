@@ -4,64 +4,543 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
 	"sync"
+	"time"
 
-	"github.com/go-playground/validator/v10"
 	"github.com/hashicorp/raft"
 )
 
-type OpType string
+// ValueType is the type of the value of a key.
+type ValueType string
 
 const (
-	OpTypeSet    OpType = "set"
-	OpTypeDelete OpType = "del"
+	TypeString ValueType = ""
+	TypeHash   ValueType = "hash"
+	TypeList   ValueType = "list"
+	TypeSet    ValueType = "set"
+	TypeJSON   ValueType = "json"
 )
 
-var validate = validator.New()
+// Entry is a value stored in the FSM along with its metadata. Entries are
+// never modified once stored, so that they can be shared with readers.
+type Entry struct {
+	Type ValueType
+
+	// Value of a string, or encoding of a JSON document
+	Value []byte
+
+	// Fields of a hash, elements of a list or members of a set
+	Hash map[string][]byte
+	List [][]byte
+	Set  map[string]struct{}
+
+	// Raft index of the command that last modified the entry
+	Version uint64
+
+	// Opaque client flags stored alongside the value
+	Flags uint32
+
+	// Unix time in milliseconds after which the entry is considered deleted.
+	// Zero means the entry never expires.
+	ExpireAt int64
+
+	// Lease the entry is attached to, zero if none
+	Lease uint64
+}
+
+// expired reports whether the entry is expired at the given unix time in milliseconds.
+func (e Entry) expired(now int64) bool {
+	return e.ExpireAt != 0 && e.ExpireAt <= now
+}
+
+// revision is the value of a key as of the Raft index in its Version.
+// Deletions are recorded as tombstones so that reads at earlier
+// revisions still find the deleted value.
+type revision struct {
+	Entry
+	deleted bool
+}
 
 // kvFsm implements the raft.FSM interface for a key-value store.
+// It keeps the history of every key until it is compacted, so that
+// reads can be served as of any revision (Raft index) since then.
 type kvFsm struct {
-	data *sync.Map
+	// Guards data and index, so that reads spanning several keys
+	// observe the state at a single applied index
+	mu sync.RWMutex
+
+	// Revisions of each key, oldest first
+	data map[string][]revision
+
+	// Raft index of the last applied log
+	index uint64
+
+	// Revision up to which history was discarded. Reads before it are
+	// no longer possible.
+	compacted uint64
+
+	// Times at which the leader appended the applied commands, oldest
+	// first, kept since the compacted revision so that reads at a revision
+	// evaluate expiry as of that revision
+	times []appliedAt
+
+	// Granted leases by ID
+	leases map[uint64]*lease
+
+	// Queues by name, which are separate from the keys
+	queues map[string]*queue
+
+	// Secondary indexes by name, kept up to date with the latest revision
+	// of every key
+	indexes map[string]*index
+
+	// Namespaces by name, other than the default one
+	namespaces map[string]*namespace
+
+	// HTTP addresses of the nodes by ID
+	nodes map[string]string
 }
 
-// fsmPayload is the structure for data in Raft logs for data apply operations.
-type fsmPayload struct {
-	Op    OpType `validate:"required,oneof=set del"`
-	Key   string `validate:"required"`
-	Value string `validate:"required_if=Op set"`
+// appliedAt is the unix time in milliseconds at which the leader appended the
+// command at a Raft index.
+type appliedAt struct {
+	index uint64
+	at    int64
+}
+
+func newKvFsm() *kvFsm {
+	return &kvFsm{
+		data:       make(map[string][]revision),
+		leases:     make(map[uint64]*lease),
+		queues:     make(map[string]*queue),
+		indexes:    make(map[string]*index),
+		namespaces: make(map[string]*namespace),
+		nodes:      make(map[string]string),
+	}
 }
 
 // Apply applies a Raft log entry to the FSM.
 func (kf *kvFsm) Apply(log *raft.Log) any {
-	switch log.Type {
-	case raft.LogCommand:
-		var p fsmPayload
-		if err := json.Unmarshal(log.Data, &p); err != nil {
-			return fmt.Errorf("could not parse command payload: %w", err)
+	if log.Type != raft.LogCommand {
+		return fmt.Errorf("unknown raft log type: %#v", log.Type)
+	}
+	return kf.ApplyBatch([]*raft.Log{log})[0]
+}
+
+// ApplyBatch applies consecutive Raft log entries to the FSM under a single
+// lock, returning the response for each entry. Commands are decoded before
+// taking the lock. Configuration entries have no response.
+func (kf *kvFsm) ApplyBatch(logs []*raft.Log) []any {
+	entries := make([]logEntry, len(logs))
+	for i, log := range logs {
+		if log.Type == raft.LogCommand {
+			entries[i] = decodeLog(log)
+		}
+	}
+
+	kf.mu.Lock()
+	defer kf.mu.Unlock()
+
+	responses := make([]any, len(logs))
+	for i, log := range logs {
+		switch log.Type {
+		case raft.LogCommand:
+			responses[i] = kf.applyLog(log, entries[i])
+		case raft.LogConfiguration:
+		default:
+			responses[i] = fmt.Errorf("unknown raft log type: %#v", log.Type)
+		}
+	}
+	return responses
+}
+
+// logEntry holds the commands decoded from a Raft log entry, or the error
+// decoding them.
+type logEntry struct {
+	cmds []Command
+
+	// Whether the entry is a batch of independent commands
+	batch bool
+
+	err error
+}
+
+// decodeLog decodes the commands of a Raft log entry.
+func decodeLog(log *raft.Log) logEntry {
+	if isBatch(log.Data) {
+		cmds, err := decodeBatch(log.Data)
+		if err != nil {
+			return logEntry{err: fmt.Errorf("could not parse batch payload: %w", err)}
 		}
+		return logEntry{cmds: cmds, batch: true}
+	}
+
+	c, legacy, err := decodeCommand(log.Data)
+	if err != nil {
+		return logEntry{err: fmt.Errorf("could not parse command payload: %w", err)}
+	}
 
-		if err := validate.Struct(p); err != nil {
-			return fmt.Errorf("invalid command payload: %w", err)
+	// Binary commands were validated by the leader before being proposed,
+	// only legacy JSON commands may be invalid.
+	if legacy {
+		if err := validateCommand(c); err != nil {
+			return logEntry{err: err}
 		}
+	}
+	return logEntry{cmds: []Command{c}}
+}
+
+// applyLog applies the commands decoded from a Raft log entry and returns the
+// response for the entry: a *Result or an error, or a slice of them for each
+// command of a batch. The caller must hold the write lock.
+func (kf *kvFsm) applyLog(log *raft.Log, e logEntry) any {
+	if e.err != nil {
+		return e.err
+	}
+	kf.index = log.Index
+
+	// Use the leader's append time rather than the local clock so that
+	// every node makes the same expiry decisions when replaying the log.
+	now := log.AppendedAt.UnixMilli()
+	kf.times = append(kf.times, appliedAt{index: log.Index, at: now})
 
-		switch p.Op {
-		case OpTypeSet:
-			kf.data.Store(p.Key, p.Value)
-		case OpTypeDelete:
-			kf.data.Delete(p.Key)
+	responses := make([]any, len(e.cmds))
+	for i, c := range e.cmds {
+		res, err := kf.applyIn(c, log.Index, now)
+		if err != nil {
+			responses[i] = err // Return an error object for FSM-level errors
+		} else {
+			responses[i] = res
 		}
+	}
 
-		return nil // Return nil for success, or an error object for FSM-level errors
-	default:
-		return fmt.Errorf("unknown raft log type: %#v", log.Type)
+	if e.batch {
+		return responses
+	}
+	return responses[0]
+}
+
+// apply executes a validated command committed at the given Raft index
+// and unix time in milliseconds. The caller must hold the write lock.
+func (kf *kvFsm) apply(c Command, index uint64, now int64) (*Result, error) {
+	cur, exists := kf.load(c.Key, now)
+
+	if c.Version != 0 && (!exists || cur.Version != c.Version) {
+		return &Result{Applied: false, Conflict: true, Version: cur.Version}, nil
+	}
+
+	switch c.Op {
+	case OpTypeSet:
+		if (c.Cond == CondNotExists && exists) || (c.Cond == CondExists && !exists) {
+			return &Result{Applied: false, Conflict: true, Version: cur.Version}, nil
+		}
+
+		if c.Lease != 0 && !kf.leaseAlive(c.Lease, now) {
+			return nil, ErrLeaseNotFound
+		}
+
+		e := Entry{Value: c.Value, Version: index, Flags: c.Flags, Lease: c.Lease}
+		if c.Ttl > 0 {
+			e.ExpireAt = now + c.Ttl
+		}
+		kf.put(c.Key, revision{Entry: e})
+		return &Result{Applied: true, Version: index}, nil
+	case OpTypeDelete:
+		// Expired keys get a tombstone too, as they may still be read
+		// at an earlier revision
+		if last, ok := kf.latest(c.Key); ok && !last.deleted {
+			kf.put(c.Key, revision{Entry: Entry{Version: index}, deleted: true})
+		}
+		return &Result{Applied: exists}, nil
+	case OpTypeIncr, OpTypeDecr, OpTypeAdd:
+		if exists && cur.Type != TypeString {
+			return nil, ErrWrongType
+		}
+
+		// The delta is used as is: defaults are filled in by the leader,
+		// so that replaying the log always has the same effect
+		delta := c.Delta
+		if c.Op == OpTypeDecr {
+			if delta == math.MinInt64 {
+				return nil, ErrOverflow
+			}
+			delta = -delta
+		}
+
+		var n int64
+		if exists {
+			var err error
+			if n, err = strconv.ParseInt(string(cur.Value), 10, 64); err != nil {
+				return nil, ErrNotInteger
+			}
+		}
+		if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+			return nil, ErrOverflow
+		}
+
+		// An increment keeps the key's flags and expiry, like in Redis
+		cur.Value = strconv.AppendInt(nil, n+delta, 10)
+		cur.Version = index
+		kf.put(c.Key, revision{Entry: cur})
+		return &Result{Applied: true, Value: cur.Value, Version: index}, nil
+	case OpTypeCompact:
+		if c.Revision > index {
+			return nil, ErrFutureRevision
+		}
+		if c.Revision <= kf.compacted {
+			return &Result{Applied: false}, nil
+		}
+		kf.compact(c.Revision)
+		return &Result{Applied: true}, nil
+	case OpTypeGrant:
+		kf.leases[index] = &lease{ttl: c.Ttl, expireAt: now + c.Ttl, ns: c.Namespace}
+		return &Result{Applied: true, Lease: index}, nil
+	case OpTypeKeepAlive:
+		if !kf.leaseAlive(c.Lease, now) {
+			return nil, ErrLeaseNotFound
+		}
+		l := kf.leases[c.Lease]
+		l.expireAt = now + l.ttl
+		return &Result{Applied: true, Lease: c.Lease}, nil
+	case OpTypeRevoke:
+		if _, ok := kf.leases[c.Lease]; !ok {
+			return &Result{Applied: false}, nil
+		}
+		kf.revoke(c.Lease, index)
+		return &Result{Applied: true, Lease: c.Lease}, nil
+	case OpTypeHSet, OpTypeHDel, OpTypeLPush, OpTypeRPush, OpTypeLPop, OpTypeRPop, OpTypeSAdd, OpTypeSRem:
+		return kf.applyCollection(c, cur, exists, index)
+	case OpTypeJSet, OpTypeJDel, OpTypeJMerge:
+		return kf.applyJSON(c, cur, exists, index)
+	case OpTypeEnqueue, OpTypeDequeue, OpTypeAck, OpTypePeek:
+		return kf.applyQueue(c, index, now), nil
+	case OpTypeCreateIndex:
+		return kf.createIndex(c)
+	case OpTypeDropIndex:
+		if _, ok := kf.indexes[c.Index]; !ok {
+			return &Result{Applied: false}, nil
+		}
+		delete(kf.indexes, c.Index)
+		return &Result{Applied: true}, nil
+	case OpTypeTxn:
+		return kf.txn(c, index, now)
+	}
+	return nil, fmt.Errorf("unknown op type: %s", c.Op)
+}
+
+// put appends a revision to the history of a key and updates the usage of
+// its namespace and the indexes. The caller must hold the write lock.
+func (kf *kvFsm) put(key string, r revision) {
+	prev, hadPrev := kf.latest(key)
+	kf.data[key] = append(kf.data[key], r)
+	kf.account(key, prev, hadPrev, r, true)
+	for _, ix := range kf.indexes {
+		ix.update(key, r)
+	}
+}
+
+// compact discards the history of every key before the given revision,
+// keeping the value each key had at that revision. The caller must hold
+// the write lock.
+func (kf *kvFsm) compact(rev uint64) {
+	for key, h := range kf.data {
+		i := sort.Search(len(h), func(i int) bool { return h[i].Version > rev })
+		if i == 0 {
+			continue
+		}
+
+		// The revision live at rev is kept, unless it is a tombstone
+		h = h[i-1:]
+		if h[0].deleted {
+			h = h[1:]
+		}
+		if len(h) == 0 {
+			delete(kf.data, key)
+			continue
+		}
+		kf.data[key] = slices.Clone(h)
+	}
+
+	// The time of the last command up to rev is kept for reads at rev
+	if i := sort.Search(len(kf.times), func(i int) bool { return kf.times[i].index > rev }); i > 0 {
+		kf.times = slices.Clone(kf.times[i-1:])
+	}
+	kf.compacted = rev
+}
+
+// latest returns the last revision of a key. The caller must hold the lock.
+func (kf *kvFsm) latest(key string) (revision, bool) {
+	h := kf.data[key]
+	if len(h) == 0 {
+		return revision{}, false
+	}
+	return h[len(h)-1], true
+}
+
+// load returns the live entry for the key at the given unix time in milliseconds.
+// The caller must hold the lock.
+func (kf *kvFsm) load(key string, now int64) (Entry, bool) {
+	r, ok := kf.latest(key)
+	if !ok || !kf.live(r, now) {
+		return Entry{}, false
+	}
+	return r.Entry, true
+}
+
+// live reports whether a revision is neither deleted nor expired at the given
+// unix time in milliseconds. The caller must hold the lock.
+func (kf *kvFsm) live(r revision, now int64) bool {
+	return !r.deleted && !r.expired(now) && !kf.leaseExpired(r.Lease, now)
+}
+
+// loadAt returns the entry the key had at the given revision, if it was not
+// expired when the revision was applied, so that reads at a revision are
+// repeatable. Keys of revoked leases have a tombstone, so leases are not
+// checked. The caller must hold the lock and have checked the revision.
+func (kf *kvFsm) loadAt(key string, rev uint64) (Entry, bool) {
+	h := kf.data[key]
+	i := sort.Search(len(h), func(i int) bool { return h[i].Version > rev })
+	if i == 0 {
+		return Entry{}, false
+	}
+
+	r := h[i-1]
+	if r.deleted || r.expired(kf.appliedAt(rev)) {
+		return Entry{}, false
+	}
+	return r.Entry, true
+}
+
+// appliedAt returns the unix time in milliseconds at which the leader appended
+// the last command applied up to a revision, or zero if it is unknown. The
+// caller must hold the lock.
+func (kf *kvFsm) appliedAt(rev uint64) int64 {
+	i := sort.Search(len(kf.times), func(i int) bool { return kf.times[i].index > rev })
+	if i == 0 {
+		return 0
+	}
+	return kf.times[i-1].at
+}
+
+// checkRevision returns the revision to read at, the current one if rev is zero,
+// or an error if it is in the future or was compacted. The caller must hold the lock.
+func (kf *kvFsm) checkRevision(rev uint64) (uint64, error) {
+	switch {
+	case rev == 0:
+		return kf.index, nil
+	case rev > kf.index:
+		return 0, ErrFutureRevision
+	case rev < kf.compacted:
+		return 0, ErrCompacted
+	}
+	return rev, nil
+}
+
+// get returns the live entry for the key at the given unix time in milliseconds.
+func (kf *kvFsm) get(key string, now int64) (Entry, bool) {
+	kf.mu.RLock()
+	defer kf.mu.RUnlock()
+
+	return kf.load(key, now)
+}
+
+// getAt returns the entry for the key as of the given revision, or the live
+// entry at the given unix time in milliseconds if the revision is zero.
+func (kf *kvFsm) getAt(key string, rev uint64, now int64) (Entry, bool, error) {
+	kf.mu.RLock()
+	defer kf.mu.RUnlock()
+
+	if rev == 0 {
+		e, ok := kf.load(key, now)
+		return e, ok, nil
+	}
+	rev, err := kf.checkRevision(rev)
+	if err != nil {
+		return Entry{}, false, err
+	}
+
+	e, ok := kf.loadAt(key, rev)
+	return e, ok, nil
+}
+
+// getMany returns the live entries for the keys at the given unix time in
+// milliseconds, along with the applied index they were read at.
+func (kf *kvFsm) getMany(keys []string, now int64) ([]Lookup, uint64) {
+	kf.mu.RLock()
+	defer kf.mu.RUnlock()
+
+	lookups := make([]Lookup, len(keys))
+	for i, key := range keys {
+		e, ok := kf.load(key, now)
+		lookups[i] = Lookup{Key: key, Entry: e, Found: ok}
+	}
+	return lookups, kf.index
+}
+
+// keys returns the live keys at the given unix time in milliseconds.
+func (kf *kvFsm) keys(now int64) []string {
+	kf.mu.RLock()
+	defer kf.mu.RUnlock()
+
+	var keys []string
+	for k := range kf.data {
+		if _, ok := kf.load(k, now); ok {
+			keys = append(keys, k)
+		}
 	}
+	return keys
+}
+
+// rangeAt returns the entries of the keys in [start, end) in lexical order as
+// of the given revision, or the live ones at the given unix time in
+// milliseconds if the revision is zero, along with the revision they were
+// read at. An empty end means no upper bound, and a limit of zero means no limit.
+func (kf *kvFsm) rangeAt(start, end string, rev uint64, limit int, now int64) ([]Lookup, uint64, error) {
+	kf.mu.RLock()
+	defer kf.mu.RUnlock()
+
+	current := rev == 0
+	rev, err := kf.checkRevision(rev)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var keys []string
+	for k := range kf.data {
+		if k >= start && (end == "" || k < end) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var lookups []Lookup
+	for _, k := range keys {
+		if limit > 0 && len(lookups) == limit {
+			break
+		}
+		var e Entry
+		var ok bool
+		if current {
+			e, ok = kf.load(k, now)
+		} else {
+			e, ok = kf.loadAt(k, rev)
+		}
+		if ok {
+			lookups = append(lookups, Lookup{Key: k, Entry: e, Found: true})
+		}
+	}
+	return lookups, rev, nil
 }
 
 // snapshotNoop is a no-op FSMSnapshot implementation.
 type snapshotNoop struct{}
 
 func (sn snapshotNoop) Persist(_ raft.SnapshotSink) error { return nil }
-func (sn snapshotNoop) Release() {}
+func (sn snapshotNoop) Release()                          {}
 
 // Snapshot returns a snapshot of the FSM state.
 func (kf *kvFsm) Snapshot() (raft.FSMSnapshot, error) {
@@ -71,21 +550,23 @@ func (kf *kvFsm) Snapshot() (raft.FSMSnapshot, error) {
 
 // Restore restores the FSM state from a snapshot.
 func (kf *kvFsm) Restore(rc io.ReadCloser) error {
+	now := time.Now().UnixMilli()
+
+	kf.mu.Lock()
+	defer kf.mu.Unlock()
+
 	decoder := json.NewDecoder(rc)
 	for decoder.More() {
-		var p fsmPayload
-		if err := decoder.Decode(&p); err != nil {
+		var c Command
+		if err := decoder.Decode(&c); err != nil {
 			// Original code did not check for io.EOF specifically here.
 			return fmt.Errorf("could not decode payload from snapshot: %w", err)
 		}
 
-		switch p.Op {
-		case OpTypeSet:
-			kf.data.Store(p.Key, p.Value)
-		case OpTypeDelete:
-			// don't expect delete op in snapshot,
-			// but leave this here for completeness anw
-			kf.data.Delete(p.Key)
+		// don't expect delete op in snapshot,
+		// but leave it to apply for completeness anw
+		if _, err := kf.apply(c, 0, now); err != nil {
+			return fmt.Errorf("could not restore payload from snapshot: %w", err)
 		}
 	}
 	return rc.Close()
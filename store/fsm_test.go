@@ -0,0 +1,273 @@
+package store
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+func newTestFsm() *kvFsm {
+	return newKvFsm()
+}
+
+func TestApply_SetCondition(t *testing.T) {
+	kf := newTestFsm()
+
+	res, err := kf.apply(Command{Op: OpTypeSet, Key: "k", Value: []byte("1"), Cond: CondExists}, 1, 0)
+	if err != nil || res.Applied {
+		t.Fatalf("expected xx set on missing key not to apply, got %+v (err %v)", res, err)
+	}
+
+	res, err = kf.apply(Command{Op: OpTypeSet, Key: "k", Value: []byte("1"), Cond: CondNotExists}, 1, 0)
+	if err != nil || !res.Applied {
+		t.Fatalf("expected nx set on missing key to apply, got %+v (err %v)", res, err)
+	}
+
+	res, err = kf.apply(Command{Op: OpTypeSet, Key: "k", Value: []byte("2"), Cond: CondNotExists}, 1, 0)
+	if err != nil || res.Applied {
+		t.Fatalf("expected nx set on existing key not to apply, got %+v (err %v)", res, err)
+	}
+	if e, _ := kf.load("k", 0); string(e.Value) != "1" {
+		t.Errorf("expected value '1', got '%s'", e.Value)
+	}
+}
+
+func TestApply_Ttl(t *testing.T) {
+	kf := newTestFsm()
+
+	if _, err := kf.apply(Command{Op: OpTypeSet, Key: "k", Value: []byte("v"), Ttl: 100}, 1, 1000); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := kf.load("k", 1099); !ok {
+		t.Errorf("expected key to exist before expiry")
+	}
+	if _, ok := kf.load("k", 1100); ok {
+		t.Errorf("expected key to be expired")
+	}
+
+	res, err := kf.apply(Command{Op: OpTypeSet, Key: "k", Value: []byte("v"), Cond: CondNotExists}, 1, 1100)
+	if err != nil || !res.Applied {
+		t.Errorf("expected nx set on expired key to apply, got %+v (err %v)", res, err)
+	}
+}
+
+func TestApply_Incr(t *testing.T) {
+	kf := newTestFsm()
+
+	res, err := kf.apply(Command{Op: OpTypeIncr, Key: "n", Delta: 5}, 1, 0)
+	if err != nil || string(res.Value) != "5" {
+		t.Fatalf("expected '5', got %+v (err %v)", res, err)
+	}
+
+	res, err = kf.apply(Command{Op: OpTypeIncr, Key: "n", Delta: -7}, 1, 0)
+	if err != nil || string(res.Value) != "-2" {
+		t.Fatalf("expected '-2', got %+v (err %v)", res, err)
+	}
+
+	kf.apply(Command{Op: OpTypeSet, Key: "s", Value: []byte("abc")}, 1, 0)
+	if _, err := kf.apply(Command{Op: OpTypeIncr, Key: "s", Delta: 1}, 1, 0); !errors.Is(err, ErrNotInteger) {
+		t.Errorf("expected ErrNotInteger, got %v", err)
+	}
+
+	kf.apply(Command{Op: OpTypeSet, Key: "max", Value: []byte(strconv.FormatInt(math.MaxInt64, 10))}, 1, 0)
+	if _, err := kf.apply(Command{Op: OpTypeIncr, Key: "max", Delta: 1}, 1, 0); !errors.Is(err, ErrOverflow) {
+		t.Errorf("expected ErrOverflow, got %v", err)
+	}
+	if e, _ := kf.load("max", 0); e.Version != 1 {
+		t.Errorf("expected overflowing increment not to modify the key, got %+v", e)
+	}
+}
+
+func TestApply_DecrAndAdd(t *testing.T) {
+	kf := newTestFsm()
+
+	res, err := kf.apply(Command{Op: OpTypeDecr, Key: "n", Delta: 3}, 1, 0)
+	if err != nil || string(res.Value) != "-3" {
+		t.Fatalf("expected '-3', got %+v (err %v)", res, err)
+	}
+
+	res, err = kf.apply(Command{Op: OpTypeAdd, Key: "n", Delta: 10}, 2, 0)
+	if err != nil || string(res.Value) != "7" || res.Version != 2 {
+		t.Fatalf("expected '7' at version 2, got %+v (err %v)", res, err)
+	}
+
+	if _, err := kf.apply(Command{Op: OpTypeDecr, Key: "n", Delta: math.MinInt64}, 3, 0); !errors.Is(err, ErrOverflow) {
+		t.Errorf("expected ErrOverflow, got %v", err)
+	}
+
+	kf.apply(Command{Op: OpTypeSet, Key: "min", Value: []byte(strconv.FormatInt(math.MinInt64, 10))}, 4, 0)
+	if _, err := kf.apply(Command{Op: OpTypeDecr, Key: "min", Delta: 1}, 5, 0); !errors.Is(err, ErrOverflow) {
+		t.Errorf("expected ErrOverflow, got %v", err)
+	}
+}
+
+func TestApply_Delete(t *testing.T) {
+	kf := newTestFsm()
+	kf.apply(Command{Op: OpTypeSet, Key: "k", Value: []byte("v")}, 1, 0)
+
+	if res, _ := kf.apply(Command{Op: OpTypeDelete, Key: "k"}, 1, 0); !res.Applied {
+		t.Errorf("expected delete of existing key to apply")
+	}
+	if res, _ := kf.apply(Command{Op: OpTypeDelete, Key: "k"}, 1, 0); res.Applied {
+		t.Errorf("expected delete of missing key not to apply")
+	}
+}
+
+func TestApply_Version(t *testing.T) {
+	kf := newTestFsm()
+
+	res, _ := kf.apply(Command{Op: OpTypeSet, Key: "k", Value: []byte("v1")}, 3, 0)
+	if res.Version != 3 {
+		t.Fatalf("expected version 3, got %d", res.Version)
+	}
+
+	res, _ = kf.apply(Command{Op: OpTypeSet, Key: "k", Value: []byte("v2"), Version: 2}, 4, 0)
+	if res.Applied || res.Version != 3 {
+		t.Errorf("expected stale version not to apply and report version 3, got %+v", res)
+	}
+
+	res, _ = kf.apply(Command{Op: OpTypeSet, Key: "k", Value: []byte("v2"), Version: 3}, 5, 0)
+	if !res.Applied || res.Version != 5 {
+		t.Errorf("expected matching version to apply with version 5, got %+v", res)
+	}
+
+	res, _ = kf.apply(Command{Op: OpTypeDelete, Key: "missing", Version: 5}, 6, 0)
+	if res.Applied || res.Version != 0 {
+		t.Errorf("expected versioned delete of missing key not to apply, got %+v", res)
+	}
+}
+
+func TestGetMany(t *testing.T) {
+	kf := newTestFsm()
+	for i, key := range []string{"a", "b"} {
+		data, _ := encodeCommand(Command{Op: OpTypeSet, Key: key, Value: []byte(key)})
+		kf.Apply(&raft.Log{Type: raft.LogCommand, Index: uint64(i + 1), Data: data})
+	}
+
+	lookups, index := kf.getMany([]string{"b", "missing", "a"}, 0)
+	if index != 2 {
+		t.Errorf("expected index 2, got %d", index)
+	}
+
+	want := []Lookup{
+		{Key: "b", Entry: Entry{Value: []byte("b"), Version: 2}, Found: true},
+		{Key: "missing"},
+		{Key: "a", Entry: Entry{Value: []byte("a"), Version: 1}, Found: true},
+	}
+	if !reflect.DeepEqual(lookups, want) {
+		t.Errorf("expected %+v, got %+v", want, lookups)
+	}
+}
+
+func TestApply_History(t *testing.T) {
+	kf := newTestFsm()
+	for i, c := range []Command{
+		{Op: OpTypeSet, Key: "a", Value: []byte("1")},
+		{Op: OpTypeSet, Key: "b", Value: []byte("x")},
+		{Op: OpTypeSet, Key: "a", Value: []byte("2")},
+		{Op: OpTypeDelete, Key: "a"},
+	} {
+		data, _ := encodeCommand(c)
+		kf.Apply(&raft.Log{Type: raft.LogCommand, Index: uint64(i + 1), Data: data})
+	}
+
+	tests := []struct {
+		rev   uint64
+		value string
+		found bool
+	}{
+		{1, "1", true},
+		{2, "1", true},
+		{3, "2", true},
+		{4, "", false},
+		{0, "", false},
+	}
+	for _, tt := range tests {
+		e, ok, err := kf.getAt("a", tt.rev, 0)
+		if err != nil || ok != tt.found || string(e.Value) != tt.value {
+			t.Errorf("revision %d: expected %q (found %t), got %q (found %t, err %v)", tt.rev, tt.value, tt.found, e.Value, ok, err)
+		}
+	}
+
+	if _, _, err := kf.getAt("a", 5, 0); !errors.Is(err, ErrFutureRevision) {
+		t.Errorf("expected ErrFutureRevision, got %v", err)
+	}
+
+	lookups, rev, err := kf.rangeAt("a", "", 3, 0, 0)
+	if err != nil || rev != 3 || len(lookups) != 2 || string(lookups[0].Entry.Value) != "2" || lookups[1].Key != "b" {
+		t.Errorf("unexpected range at revision 3: %+v at %d, err %v", lookups, rev, err)
+	}
+	if lookups, _, _ := kf.rangeAt("a", "b", 0, 0, 0); len(lookups) != 0 {
+		t.Errorf("expected deleted key not to be in current range, got %+v", lookups)
+	}
+}
+
+func TestApply_HistoryExpiry(t *testing.T) {
+	kf := newTestFsm()
+	for i, c := range []Command{
+		{Op: OpTypeSet, Key: "a", Value: []byte("1"), Ttl: 1000},
+		{Op: OpTypeSet, Key: "b", Value: []byte("x")},
+		{Op: OpTypeSet, Key: "c", Value: []byte("y")},
+	} {
+		data, _ := encodeCommand(c)
+		index := uint64(i + 1)
+		kf.Apply(&raft.Log{Type: raft.LogCommand, Index: index, Data: data, AppendedAt: time.UnixMilli(int64(index) * 600)})
+	}
+
+	// The key expired at 1600, after revision 2 but before revision 3,
+	// whatever the time of the read
+	for _, now := range []int64{0, 5000} {
+		if e, ok, err := kf.getAt("a", 2, now); err != nil || !ok || string(e.Value) != "1" {
+			t.Errorf("at %d: expected key to be live at revision 2, got %q (found %t, err %v)", now, e.Value, ok, err)
+		}
+		if _, ok, _ := kf.getAt("a", 3, now); ok {
+			t.Errorf("at %d: expected key to be expired at revision 3", now)
+		}
+		if lookups, _, _ := kf.rangeAt("a", "b", 2, 0, now); len(lookups) != 1 {
+			t.Errorf("at %d: expected range at revision 2 to find the key, got %+v", now, lookups)
+		}
+	}
+	if _, ok, _ := kf.getAt("a", 0, 1200); !ok {
+		t.Errorf("expected current read to use the time of the read")
+	}
+
+	kf.apply(Command{Op: OpTypeCompact, Revision: 2}, 4, 0)
+	if _, ok, _ := kf.getAt("a", 2, 5000); !ok {
+		t.Errorf("expected time of the compacted revision to be kept")
+	}
+}
+
+func TestApply_Compact(t *testing.T) {
+	kf := newTestFsm()
+	kf.apply(Command{Op: OpTypeSet, Key: "a", Value: []byte("1")}, 1, 0)
+	kf.apply(Command{Op: OpTypeSet, Key: "a", Value: []byte("2")}, 2, 0)
+	kf.apply(Command{Op: OpTypeSet, Key: "b", Value: []byte("x")}, 3, 0)
+	kf.apply(Command{Op: OpTypeDelete, Key: "b"}, 4, 0)
+	kf.index = 5
+
+	if res, err := kf.apply(Command{Op: OpTypeCompact, Revision: 4}, 5, 0); err != nil || !res.Applied {
+		t.Fatalf("expected compaction to apply, got %+v, err %v", res, err)
+	}
+	if len(kf.data["a"]) != 1 || kf.data["b"] != nil {
+		t.Errorf("expected history to be discarded, got %+v", kf.data)
+	}
+
+	if _, _, err := kf.getAt("a", 3, 0); !errors.Is(err, ErrCompacted) {
+		t.Errorf("expected ErrCompacted, got %v", err)
+	}
+	if e, ok, err := kf.getAt("a", 4, 0); err != nil || !ok || string(e.Value) != "2" {
+		t.Errorf("expected value at compacted revision to be kept, got %q, err %v", e.Value, err)
+	}
+
+	if res, _ := kf.apply(Command{Op: OpTypeCompact, Revision: 2}, 6, 0); res.Applied {
+		t.Errorf("expected compaction to an earlier revision not to apply")
+	}
+	if _, err := kf.apply(Command{Op: OpTypeCompact, Revision: 10}, 7, 0); !errors.Is(err, ErrFutureRevision) {
+		t.Errorf("expected ErrFutureRevision, got %v", err)
+	}
+}
@@ -0,0 +1,254 @@
+package store
+
+import (
+	"cmp"
+	"encoding/json"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Kinds of indexed values, in the order in which they sort
+const (
+	kindBool = iota
+	kindNumber
+	kindString
+)
+
+// indexValue is a JSON string, number or boolean found at the indexed field
+// of a value. Values sort by kind first, then by value.
+type indexValue struct {
+	kind int
+	num  float64
+	str  string
+}
+
+// toIndexValue converts a decoded JSON value to an indexValue. Only strings,
+// numbers and booleans are indexed.
+func toIndexValue(v any) (indexValue, bool) {
+	switch v := v.(type) {
+	case bool:
+		return indexValue{kind: kindBool, num: float64(boolInt(v))}, true
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return indexValue{}, false
+		}
+		return indexValue{kind: kindNumber, num: f}, true
+	case string:
+		return indexValue{kind: kindString, str: v}, true
+	}
+	return indexValue{}, false
+}
+
+func (v indexValue) compare(o indexValue) int {
+	return cmp.Or(cmp.Compare(v.kind, o.kind), cmp.Compare(v.num, o.num), strings.Compare(v.str, o.str))
+}
+
+// parseIndexValue parses the JSON encoding of a value to query an index with.
+func parseIndexValue(field string, data json.RawMessage) (indexValue, error) {
+	if json.Valid(data) {
+		if doc, err := decodeJSON(data); err == nil {
+			if v, ok := toIndexValue(doc); ok {
+				return v, nil
+			}
+		}
+	}
+	return indexValue{}, &ValidationError{Fields: []FieldError{{Field: field, Message: "must be a JSON string, number or boolean"}}}
+}
+
+// IndexInfo describes a secondary index.
+type IndexInfo struct {
+	Name string `json:"name"`
+
+	// Prefix of the indexed keys, empty for all keys
+	Prefix string `json:"prefix"`
+
+	// JSON Pointer to the indexed field of the values
+	Path string `json:"path"`
+
+	// Number of indexed keys
+	Count int `json:"count"`
+}
+
+// IndexQuery selects the keys of an index by the value of their indexed field:
+// the keys whose value equals Value if it is set, otherwise those whose value
+// is in [Start, End). Values are JSON strings, numbers or booleans, and a
+// missing bound means no bound. A limit of zero means no limit.
+type IndexQuery struct {
+	Value json.RawMessage
+	Start json.RawMessage
+	End   json.RawMessage
+	Limit int
+}
+
+// index is a secondary index on a field of the JSON values of the keys with a
+// prefix. Values are indexed whether they are JSON documents or strings
+// holding JSON. Keys without a string, number or boolean at the field are
+// not indexed.
+type index struct {
+	namespace string
+	prefix    string
+	path      string
+	tokens    []string
+
+	// Indexed keys ordered by value, then key
+	items []indexItem
+
+	// Indexed value of each key
+	values map[string]indexValue
+}
+
+type indexItem struct {
+	value indexValue
+	key   string
+}
+
+func compareItems(a, b indexItem) int {
+	return cmp.Or(a.value.compare(b.value), strings.Compare(a.key, b.key))
+}
+
+// extract returns the indexed value of an entry.
+func (ix *index) extract(e Entry) (indexValue, bool) {
+	if (e.Type != TypeString && e.Type != TypeJSON) || !json.Valid(e.Value) {
+		return indexValue{}, false
+	}
+
+	doc, err := decodeJSON(e.Value)
+	if err != nil {
+		return indexValue{}, false
+	}
+	v, ok := resolve(doc, ix.tokens)
+	if !ok {
+		return indexValue{}, false
+	}
+	return toIndexValue(v)
+}
+
+// update indexes the latest revision of a key, removing the key from the
+// index if it was deleted.
+func (ix *index) update(key string, r revision) {
+	if !strings.HasPrefix(key, ix.prefix) {
+		return
+	}
+	// Indexes only cover the keys of their own namespace
+	if ns, _ := splitKey(key); ns != ix.namespace {
+		return
+	}
+
+	if old, ok := ix.values[key]; ok {
+		if i, found := slices.BinarySearchFunc(ix.items, indexItem{old, key}, compareItems); found {
+			ix.items = slices.Delete(ix.items, i, i+1)
+		}
+		delete(ix.values, key)
+	}
+	if r.deleted {
+		return
+	}
+
+	v, ok := ix.extract(r.Entry)
+	if !ok {
+		return
+	}
+	item := indexItem{v, key}
+	i, _ := slices.BinarySearchFunc(ix.items, item, compareItems)
+	ix.items = slices.Insert(ix.items, i, item)
+	ix.values[key] = v
+}
+
+// reindex updates the indexes with the latest revision of a key. The caller
+// must hold the write lock.
+func (kf *kvFsm) reindex(key string) {
+	r, ok := kf.latest(key)
+	if !ok {
+		r.deleted = true
+	}
+	for _, ix := range kf.indexes {
+		ix.update(key, r)
+	}
+}
+
+// createIndex creates an index and indexes the current keys. The caller must
+// hold the write lock.
+func (kf *kvFsm) createIndex(c Command) (*Result, error) {
+	if _, ok := kf.indexes[c.Index]; ok {
+		return &Result{Applied: false}, nil
+	}
+
+	tokens, err := parsePointer(c.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	ns, _ := splitKey(c.Index)
+	ix := &index{namespace: ns, prefix: c.Key, path: c.Path, tokens: tokens, values: make(map[string]indexValue)}
+	for key, h := range kf.data {
+		ix.update(key, h[len(h)-1])
+	}
+	kf.indexes[c.Index] = ix
+	return &Result{Applied: true, Count: len(ix.values)}, nil
+}
+
+// queryIndex returns the live entries of the keys selected by a query on an
+// index at the given unix time in milliseconds, ordered by indexed value then
+// key, along with the applied index they were read at.
+func (kf *kvFsm) queryIndex(name string, q IndexQuery, now int64) ([]Lookup, uint64, error) {
+	kf.mu.RLock()
+	defer kf.mu.RUnlock()
+
+	ix, ok := kf.indexes[name]
+	if !ok {
+		return nil, 0, ErrIndexNotFound
+	}
+
+	// Values are selected in [start, end), or equal to start for equality queries
+	var start, end *indexValue
+	equal := q.Value != nil
+	for _, b := range []struct {
+		field string
+		data  json.RawMessage
+		v     **indexValue
+	}{{"value", q.Value, &start}, {"start", q.Start, &start}, {"end", q.End, &end}} {
+		if b.data == nil || (equal && b.field != "value") {
+			continue
+		}
+		v, err := parseIndexValue(b.field, b.data)
+		if err != nil {
+			return nil, 0, err
+		}
+		*b.v = &v
+	}
+
+	i := 0
+	if start != nil {
+		i = sort.Search(len(ix.items), func(i int) bool { return ix.items[i].value.compare(*start) >= 0 })
+	}
+
+	var lookups []Lookup
+	for _, item := range ix.items[i:] {
+		if (equal && item.value.compare(*start) != 0) || (!equal && end != nil && item.value.compare(*end) >= 0) {
+			break
+		}
+		if q.Limit > 0 && len(lookups) == q.Limit {
+			break
+		}
+		if e, ok := kf.load(item.key, now); ok {
+			lookups = append(lookups, Lookup{Key: item.key, Entry: e, Found: true})
+		}
+	}
+	return lookups, kf.index, nil
+}
+
+// listIndexes returns the indexes ordered by name.
+func (kf *kvFsm) listIndexes() []IndexInfo {
+	kf.mu.RLock()
+	defer kf.mu.RUnlock()
+
+	infos := make([]IndexInfo, 0, len(kf.indexes))
+	for _, name := range slices.Sorted(maps.Keys(kf.indexes)) {
+		ix := kf.indexes[name]
+		infos = append(infos, IndexInfo{Name: name, Prefix: ix.prefix, Path: ix.path, Count: len(ix.values)})
+	}
+	return infos
+}
@@ -0,0 +1,94 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// indexedKeys returns the keys of the lookups.
+func indexedKeys(lookups []Lookup) []string {
+	var keys []string
+	for _, l := range lookups {
+		keys = append(keys, l.Key)
+	}
+	return keys
+}
+
+func TestIndex(t *testing.T) {
+	kf := newTestFsm()
+	kf.apply(Command{Op: OpTypeSet, Key: "users/a", Value: []byte(`{"email":"a@x","age":30}`)}, 1, 0)
+	kf.apply(Command{Op: OpTypeJSet, Key: "users/b", Value: []byte(`{"email":"b@x","age":25}`)}, 2, 0)
+	kf.apply(Command{Op: OpTypeSet, Key: "users/c", Value: []byte(`not json`)}, 3, 0)
+	kf.apply(Command{Op: OpTypeSet, Key: "other", Value: []byte(`{"email":"a@x"}`)}, 4, 0)
+
+	res, err := kf.apply(Command{Op: OpTypeCreateIndex, Key: "users/", Path: "/email", Index: "by_email"}, 5, 0)
+	if err != nil || !res.Applied || res.Count != 2 {
+		t.Fatalf("expected 2 keys to be indexed, got %+v, %v", res, err)
+	}
+	kf.apply(Command{Op: OpTypeCreateIndex, Key: "users/", Path: "/age", Index: "by_age"}, 6, 0)
+	if res, _ := kf.apply(Command{Op: OpTypeCreateIndex, Path: "/x", Index: "by_age"}, 7, 0); res.Applied {
+		t.Errorf("expected existing index not to be created again")
+	}
+
+	// Indexes follow writes and deletions
+	kf.apply(Command{Op: OpTypeJSet, Key: "users/b", Path: "/email", Value: []byte(`"a@x"`)}, 8, 0)
+	kf.apply(Command{Op: OpTypeSet, Key: "users/d", Value: []byte(`{"email":"d@x","age":40}`)}, 9, 0)
+	kf.apply(Command{Op: OpTypeDelete, Key: "users/a"}, 10, 0)
+
+	tests := []struct {
+		index string
+		q     IndexQuery
+		want  []string
+	}{
+		{"by_email", IndexQuery{Value: json.RawMessage(`"a@x"`)}, []string{"users/b"}},
+		{"by_email", IndexQuery{Value: json.RawMessage(`"b@x"`)}, nil},
+		{"by_age", IndexQuery{Start: json.RawMessage(`25`), End: json.RawMessage(`40`)}, []string{"users/b"}},
+		{"by_age", IndexQuery{Start: json.RawMessage(`26`)}, []string{"users/d"}},
+		{"by_email", IndexQuery{Limit: 1}, []string{"users/b"}},
+	}
+	for _, tt := range tests {
+		lookups, _, err := kf.queryIndex(tt.index, tt.q, 0)
+		if err != nil || !reflect.DeepEqual(indexedKeys(lookups), tt.want) {
+			t.Errorf("%s %+v: expected %v, got %v (%v)", tt.index, tt.q, tt.want, indexedKeys(lookups), err)
+		}
+	}
+
+	kf.apply(Command{Op: OpTypeDropIndex, Index: "by_email"}, 11, 0)
+	if _, _, err := kf.queryIndex("by_email", IndexQuery{}, 0); !errors.Is(err, ErrIndexNotFound) {
+		t.Errorf("expected ErrIndexNotFound, got %v", err)
+	}
+	if want := []IndexInfo{{Name: "by_age", Prefix: "users/", Path: "/age", Count: 2}}; !reflect.DeepEqual(kf.listIndexes(), want) {
+		t.Errorf("expected indexes %+v, got %+v", want, kf.listIndexes())
+	}
+}
+
+func TestIndex_TxnRollback(t *testing.T) {
+	kf := newTestFsm()
+	kf.apply(Command{Op: OpTypeCreateIndex, Path: "/n", Index: "by_n"}, 1, 0)
+	kf.apply(Command{Op: OpTypeSet, Key: "a", Value: []byte(`{"n":1}`)}, 2, 0)
+
+	_, err := kf.apply(Command{Op: OpTypeTxn, Then: []Command{
+		{Op: OpTypeSet, Key: "a", Value: []byte(`{"n":2}`)},
+		{Op: OpTypeIncr, Key: "a", Delta: 1},
+	}}, 3, 0)
+	if err == nil {
+		t.Fatalf("expected transaction to fail")
+	}
+
+	lookups, _, _ := kf.queryIndex("by_n", IndexQuery{Value: json.RawMessage(`1`)}, 0)
+	if !reflect.DeepEqual(indexedKeys(lookups), []string{"a"}) {
+		t.Errorf("expected index to be rolled back, got %v", indexedKeys(lookups))
+	}
+}
+
+func TestIndex_InvalidQuery(t *testing.T) {
+	kf := newTestFsm()
+	kf.apply(Command{Op: OpTypeCreateIndex, Path: "/n", Index: "by_n"}, 1, 0)
+
+	var verr *ValidationError
+	if _, _, err := kf.queryIndex("by_n", IndexQuery{Start: json.RawMessage(`{}`)}, 0); !errors.As(err, &verr) || verr.Fields[0].Field != "start" {
+		t.Errorf("expected ValidationError on start, got %v", err)
+	}
+}
@@ -0,0 +1,258 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var errInvalidPointer = errors.New("invalid JSON Pointer")
+
+// pointerUnescaper decodes the escaped '/' and '~' of a reference token.
+// It replaces "~1" before "~0", so that "~01" decodes to "~1".
+var pointerUnescaper = strings.NewReplacer("~1", "/", "~0", "~")
+
+// parsePointer splits a JSON Pointer (RFC 6901) into its reference tokens.
+// The empty pointer refers to the whole document and has no tokens.
+func parsePointer(p string) ([]string, error) {
+	if p == "" {
+		return nil, nil
+	}
+	if p[0] != '/' {
+		return nil, errInvalidPointer
+	}
+
+	tokens := strings.Split(p[1:], "/")
+	for i, tok := range tokens {
+		tokens[i] = pointerUnescaper.Replace(tok)
+	}
+	return tokens, nil
+}
+
+// arrayIndex parses a reference token as an index of an array of length n.
+// Indexes must not have leading zeros.
+func arrayIndex(tok string, n int) (int, bool) {
+	if tok == "" || (len(tok) > 1 && tok[0] == '0') {
+		return 0, false
+	}
+	i, err := strconv.Atoi(tok)
+	if err != nil || i < 0 || i >= n {
+		return 0, false
+	}
+	return i, true
+}
+
+// decodeJSON decodes a JSON document, keeping numbers as they were written
+// so that rewriting the document does not change them.
+func decodeJSON(data []byte) (any, error) {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+
+	var v any
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// resolve returns the value of a decoded document at the given tokens.
+func resolve(doc any, tokens []string) (any, bool) {
+	for _, tok := range tokens {
+		switch v := doc.(type) {
+		case map[string]any:
+			var ok bool
+			if doc, ok = v[tok]; !ok {
+				return nil, false
+			}
+		case []any:
+			i, ok := arrayIndex(tok, len(v))
+			if !ok {
+				return nil, false
+			}
+			doc = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return doc, true
+}
+
+// ResolvePointer returns the encoding of the value at a JSON Pointer of an
+// encoded document, and whether the document has a value there.
+func ResolvePointer(doc []byte, pointer string) (json.RawMessage, bool, error) {
+	tokens, err := parsePointer(pointer)
+	if err != nil {
+		return nil, false, &ValidationError{Fields: []FieldError{{Field: "path", Message: "must be a JSON Pointer"}}}
+	}
+	if len(tokens) == 0 {
+		return doc, true, nil
+	}
+
+	v, err := decodeJSON(doc)
+	if err != nil {
+		return nil, false, err
+	}
+	v, ok := resolve(v, tokens)
+	if !ok {
+		return nil, false, nil
+	}
+
+	data, err := json.Marshal(v)
+	return data, err == nil, err
+}
+
+// update replaces the value at the given tokens of a decoded document with
+// the result of fn, which is given the current value and whether there is
+// one. The value is removed if fn returns false. Objects and arrays are
+// modified in place, and the possibly new root of the document is returned.
+//
+// The last token may name a new member of an object, or be "-" to append
+// to an array. The other tokens must lead to existing values.
+func update(doc any, tokens []string, fn func(cur any, exists bool) (any, bool)) (any, error) {
+	if len(tokens) == 0 {
+		v, _ := fn(doc, true)
+		return v, nil
+	}
+
+	tok, rest := tokens[0], tokens[1:]
+	switch node := doc.(type) {
+	case map[string]any:
+		cur, ok := node[tok]
+		if len(rest) > 0 {
+			if !ok {
+				return nil, ErrPathNotFound
+			}
+			v, err := update(cur, rest, fn)
+			if err != nil {
+				return nil, err
+			}
+			node[tok] = v
+			return node, nil
+		}
+
+		if v, keep := fn(cur, ok); keep {
+			node[tok] = v
+		} else {
+			delete(node, tok)
+		}
+		return node, nil
+	case []any:
+		if tok == "-" && len(rest) == 0 {
+			if v, keep := fn(nil, false); keep {
+				node = append(node, v)
+			}
+			return node, nil
+		}
+
+		i, ok := arrayIndex(tok, len(node))
+		if !ok {
+			return nil, ErrPathNotFound
+		}
+		if len(rest) > 0 {
+			v, err := update(node[i], rest, fn)
+			if err != nil {
+				return nil, err
+			}
+			node[i] = v
+			return node, nil
+		}
+
+		if v, keep := fn(node[i], true); keep {
+			node[i] = v
+			return node, nil
+		}
+		return slices.Delete(node, i, i+1), nil
+	}
+	return nil, ErrPathNotFound
+}
+
+// mergePatch applies a JSON merge patch (RFC 7386) to a decoded value.
+func mergePatch(target, patch any) any {
+	p, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	t, ok := target.(map[string]any)
+	if !ok {
+		t = make(map[string]any, len(p))
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+			continue
+		}
+		t[k] = mergePatch(t[k], v)
+	}
+	return t
+}
+
+// applyJSON executes a command on the JSON document stored at a key, given
+// its current entry. The document is decoded and encoded again on every
+// command, so earlier revisions are left untouched. Setting or merging
+// the whole document creates the key, and deleting it deletes the key.
+// The caller must hold the write lock.
+func (kf *kvFsm) applyJSON(c Command, cur Entry, exists bool, index uint64) (*Result, error) {
+	if exists && cur.Type != TypeJSON {
+		return nil, ErrWrongType
+	}
+
+	tokens, err := parsePointer(c.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc any
+	if exists {
+		if doc, err = decodeJSON(cur.Value); err != nil {
+			return nil, err
+		}
+	} else if c.Op == OpTypeJDel {
+		return &Result{Applied: false}, nil
+	} else if len(tokens) > 0 {
+		return nil, ErrPathNotFound
+	}
+
+	switch c.Op {
+	case OpTypeJSet, OpTypeJMerge:
+		v, err := decodeJSON(c.Value)
+		if err != nil {
+			return nil, err
+		}
+		if doc, err = update(doc, tokens, func(cur any, _ bool) (any, bool) {
+			if c.Op == OpTypeJMerge {
+				return mergePatch(cur, v), true
+			}
+			return v, true
+		}); err != nil {
+			return nil, err
+		}
+	case OpTypeJDel:
+		if _, ok := resolve(doc, tokens); !ok {
+			return &Result{Applied: false, Version: cur.Version}, nil
+		}
+		if len(tokens) == 0 {
+			kf.put(c.Key, revision{Entry: Entry{Version: index}, deleted: true})
+			return &Result{Applied: true}, nil
+		}
+		if doc, err = update(doc, tokens, func(any, bool) (any, bool) { return nil, false }); err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	// The key keeps its flags, expiry and lease
+	e := cur
+	e.Type = TypeJSON
+	e.Value = data
+	e.Version = index
+	kf.put(c.Key, revision{Entry: e})
+	return &Result{Applied: true, Version: index}, nil
+}
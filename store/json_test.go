@@ -0,0 +1,124 @@
+package store
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParsePointer(t *testing.T) {
+	tests := []struct {
+		pointer string
+		want    []string
+		err     bool
+	}{
+		{"", nil, false},
+		{"/", []string{""}, false},
+		{"/a/0", []string{"a", "0"}, false},
+		{"/a~1b/m~0n/~01", []string{"a/b", "m~n", "~1"}, false},
+		{"a", nil, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parsePointer(tt.pointer)
+		if (err != nil) != tt.err || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: expected %q (error %t), got %q (%v)", tt.pointer, tt.want, tt.err, got, err)
+		}
+	}
+}
+
+func TestApply_JSON(t *testing.T) {
+	kf := newTestFsm()
+
+	steps := []struct {
+		c    Command
+		want string
+	}{
+		{Command{Op: OpTypeJSet, Value: []byte(`{"a":{"b":1.50},"l":[1,2]}`)}, `{"a":{"b":1.50},"l":[1,2]}`},
+		{Command{Op: OpTypeJSet, Path: "/a/c", Value: []byte(`"x"`)}, `{"a":{"b":1.50,"c":"x"},"l":[1,2]}`},
+		{Command{Op: OpTypeJSet, Path: "/l/-", Value: []byte(`3`)}, `{"a":{"b":1.50,"c":"x"},"l":[1,2,3]}`},
+		{Command{Op: OpTypeJSet, Path: "/l/0", Value: []byte(`0`)}, `{"a":{"b":1.50,"c":"x"},"l":[0,2,3]}`},
+		{Command{Op: OpTypeJDel, Path: "/l/1"}, `{"a":{"b":1.50,"c":"x"},"l":[0,3]}`},
+		{Command{Op: OpTypeJMerge, Path: "/a", Value: []byte(`{"b":null,"d":{"e":true}}`)}, `{"a":{"c":"x","d":{"e":true}},"l":[0,3]}`},
+		{Command{Op: OpTypeJDel, Path: "/a/d"}, `{"a":{"c":"x"},"l":[0,3]}`},
+	}
+	for i, step := range steps {
+		step.c.Key = "doc"
+		index := uint64(i + 1)
+		res, err := kf.apply(step.c, index, 0)
+		if err != nil || !res.Applied || res.Version != index {
+			t.Fatalf("step %d: expected command to apply, got %+v, %v", i, res, err)
+		}
+		if e, _ := kf.load("doc", 0); e.Type != TypeJSON || string(e.Value) != step.want {
+			t.Errorf("step %d: expected %s, got %s", i, step.want, e.Value)
+		}
+	}
+
+	// Earlier revisions are not modified
+	if e, _ := kf.loadAt("doc", 1); string(e.Value) != `{"a":{"b":1.50},"l":[1,2]}` {
+		t.Errorf("expected document at revision 1 to be unchanged, got %s", e.Value)
+	}
+
+	if res, _ := kf.apply(Command{Op: OpTypeJDel, Key: "doc", Path: "/missing"}, 10, 0); res.Applied {
+		t.Errorf("expected delete of missing path not to apply")
+	}
+	for _, path := range []string{"/missing/a", "/l/5", "/l/01", "/a/c/d"} {
+		if _, err := kf.apply(Command{Op: OpTypeJSet, Key: "doc", Path: path, Value: []byte(`1`)}, 11, 0); !errors.Is(err, ErrPathNotFound) {
+			t.Errorf("%s: expected ErrPathNotFound, got %v", path, err)
+		}
+	}
+
+	kf.apply(Command{Op: OpTypeJDel, Key: "doc"}, 12, 0)
+	if _, ok := kf.load("doc", 0); ok {
+		t.Errorf("expected deleting the whole document to delete the key")
+	}
+}
+
+func TestApply_JSONMissingKey(t *testing.T) {
+	kf := newTestFsm()
+
+	if _, err := kf.apply(Command{Op: OpTypeJSet, Key: "doc", Path: "/a", Value: []byte(`1`)}, 1, 0); !errors.Is(err, ErrPathNotFound) {
+		t.Errorf("expected ErrPathNotFound, got %v", err)
+	}
+	if res, _ := kf.apply(Command{Op: OpTypeJDel, Key: "doc"}, 2, 0); res.Applied {
+		t.Errorf("expected delete of missing document not to apply")
+	}
+
+	kf.apply(Command{Op: OpTypeJMerge, Key: "doc", Value: []byte(`{"a":1,"b":null}`)}, 3, 0)
+	if e, _ := kf.load("doc", 0); string(e.Value) != `{"a":1}` {
+		t.Errorf("expected merge patch to create the document, got %s", e.Value)
+	}
+
+	kf.apply(Command{Op: OpTypeSet, Key: "s", Value: []byte(`{}`)}, 4, 0)
+	if _, err := kf.apply(Command{Op: OpTypeJSet, Key: "s", Value: []byte(`1`)}, 5, 0); !errors.Is(err, ErrWrongType) {
+		t.Errorf("expected ErrWrongType, got %v", err)
+	}
+}
+
+func TestResolvePointer(t *testing.T) {
+	doc := []byte(`{"a":[{"b":"c"}],"d/e":2}`)
+
+	tests := []struct {
+		pointer string
+		want    string
+		found   bool
+	}{
+		{"", string(doc), true},
+		{"/a/0", `{"b":"c"}`, true},
+		{"/a/0/b", `"c"`, true},
+		{"/d~1e", `2`, true},
+		{"/a/1", "", false},
+		{"/d~1e/f", "", false},
+	}
+	for _, tt := range tests {
+		got, found, err := ResolvePointer(doc, tt.pointer)
+		if err != nil || found != tt.found || string(got) != tt.want {
+			t.Errorf("%q: expected %s (found %t), got %s (found %t, %v)", tt.pointer, tt.want, tt.found, got, found, err)
+		}
+	}
+
+	var verr *ValidationError
+	if _, _, err := ResolvePointer(doc, "a"); !errors.As(err, &verr) {
+		t.Errorf("expected ValidationError, got %v", err)
+	}
+}
@@ -0,0 +1,111 @@
+package store
+
+import (
+	"log"
+	"slices"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// How often the leader looks for expired leases to revoke
+const leaseCheckInterval = time.Second
+
+// lease is a time to live shared by the keys attached to it. Its ID is the
+// Raft index of the command that granted it.
+type lease struct {
+	// Time to live in milliseconds, which keepalives extend the lease by
+	ttl int64
+
+	// Unix time in milliseconds after which the lease is expired
+	expireAt int64
+
+	// Namespace the lease was granted in, the only one it can be used in
+	ns string
+}
+
+// leasesIn reports whether the existing leases a command and its then and
+// else commands refer to were granted in a namespace. The caller must hold
+// the lock.
+func (kf *kvFsm) leasesIn(c Command, ns string) bool {
+	if l, ok := kf.leases[c.Lease]; ok && l.ns != ns {
+		return false
+	}
+	for _, op := range slices.Concat(c.Then, c.Else) {
+		if !kf.leasesIn(op, ns) {
+			return false
+		}
+	}
+	return true
+}
+
+// leaseAlive reports whether a lease exists and is not expired at the given
+// unix time in milliseconds. The caller must hold the lock.
+func (kf *kvFsm) leaseAlive(id uint64, now int64) bool {
+	l, ok := kf.leases[id]
+	return ok && l.expireAt > now
+}
+
+// leaseExpired reports whether a lease exists and is expired at the given
+// unix time in milliseconds. Keys attached to an expired lease are considered
+// deleted until the lease is revoked. The caller must hold the lock.
+func (kf *kvFsm) leaseExpired(id uint64, now int64) bool {
+	if id == 0 {
+		return false
+	}
+	l, ok := kf.leases[id]
+	return ok && l.expireAt <= now
+}
+
+// revoke removes a lease and deletes the keys attached to it at the given
+// Raft index. The caller must hold the write lock.
+func (kf *kvFsm) revoke(id uint64, index uint64) {
+	delete(kf.leases, id)
+
+	for key := range kf.data {
+		if r, _ := kf.latest(key); !r.deleted && r.Lease == id {
+			kf.put(key, revision{Entry: Entry{Version: index}, deleted: true})
+		}
+	}
+}
+
+// expiredLeases returns the commands revoking the leases expired at the given
+// unix time in milliseconds, in the namespaces of the leases.
+func (kf *kvFsm) expiredLeases(now int64) []Command {
+	kf.mu.RLock()
+	defer kf.mu.RUnlock()
+
+	var revokes []Command
+	for id, l := range kf.leases {
+		if l.expireAt <= now {
+			revokes = append(revokes, Command{Op: OpTypeRevoke, Lease: id, Namespace: l.ns})
+		}
+	}
+	return revokes
+}
+
+// expireLeases revokes expired leases while the node is the leader, so that
+// the keys attached to them are deleted on every node. This is a blocking
+// call, which returns once the store is shut down.
+func (s *Store) expireLeases() {
+	ticker := time.NewTicker(leaseCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+		if s.raft.State() != raft.Leader {
+			continue
+		}
+
+		for _, c := range s.fsm.expiredLeases(time.Now().UnixMilli()) {
+			if _, err := s.propose(c); err != nil {
+				log.Printf("Could not revoke expired lease %d: %s", c.Lease, err)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package store
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestApply_Lease(t *testing.T) {
+	kf := newTestFsm()
+
+	res, err := kf.apply(Command{Op: OpTypeGrant, Ttl: 1000}, 1, 0)
+	if err != nil || res.Lease != 1 {
+		t.Fatalf("expected lease 1 to be granted, got %+v, err %v", res, err)
+	}
+	kf.apply(Command{Op: OpTypeSet, Key: "a", Value: []byte("1"), Lease: 1}, 2, 0)
+	kf.apply(Command{Op: OpTypeSet, Key: "b", Value: []byte("2")}, 3, 0)
+
+	if _, err := kf.apply(Command{Op: OpTypeKeepAlive, Lease: 1}, 4, 500); err != nil {
+		t.Fatalf("unexpected keepalive error: %v", err)
+	}
+	if _, ok := kf.load("a", 1200); !ok {
+		t.Errorf("expected key to be live after keepalive")
+	}
+	if _, ok := kf.load("a", 1500); ok {
+		t.Errorf("expected key of expired lease not to be live")
+	}
+	if revokes := kf.expiredLeases(1500); !reflect.DeepEqual(revokes, []Command{{Op: OpTypeRevoke, Lease: 1}}) {
+		t.Errorf("expected lease 1 to be expired, got %+v", revokes)
+	}
+
+	if _, err := kf.apply(Command{Op: OpTypeKeepAlive, Lease: 1}, 5, 1500); !errors.Is(err, ErrLeaseNotFound) {
+		t.Errorf("expected ErrLeaseNotFound for expired lease, got %v", err)
+	}
+	if _, err := kf.apply(Command{Op: OpTypeSet, Key: "c", Value: []byte("3"), Lease: 1}, 6, 1500); !errors.Is(err, ErrLeaseNotFound) {
+		t.Errorf("expected ErrLeaseNotFound for set on expired lease, got %v", err)
+	}
+
+	if res, _ := kf.apply(Command{Op: OpTypeRevoke, Lease: 1}, 7, 1500); !res.Applied {
+		t.Errorf("expected revoke to apply")
+	}
+	if _, ok := kf.data["a"]; !ok {
+		t.Errorf("expected history of revoked key to be kept")
+	}
+	if _, ok, _ := kf.getAt("a", 7, 0); ok {
+		t.Errorf("expected key of revoked lease to be deleted")
+	}
+	if _, ok := kf.load("b", 1500); !ok {
+		t.Errorf("expected key without lease to be kept")
+	}
+	if res, _ := kf.apply(Command{Op: OpTypeRevoke, Lease: 1}, 8, 1500); res.Applied {
+		t.Errorf("expected revoke of missing lease not to apply")
+	}
+}
+
+func TestApply_LeaseDetach(t *testing.T) {
+	kf := newTestFsm()
+	kf.apply(Command{Op: OpTypeGrant, Ttl: 1000}, 1, 0)
+	kf.apply(Command{Op: OpTypeSet, Key: "a", Value: []byte("1"), Lease: 1}, 2, 0)
+
+	// Overwriting the key without a lease detaches it
+	kf.apply(Command{Op: OpTypeSet, Key: "a", Value: []byte("2")}, 3, 0)
+	kf.apply(Command{Op: OpTypeRevoke, Lease: 1}, 4, 0)
+	if e, ok := kf.load("a", 0); !ok || string(e.Value) != "2" {
+		t.Errorf("expected detached key to be kept, got %+v", e)
+	}
+}
+
+func TestApply_LeaseNamespace(t *testing.T) {
+	kf := newTestFsm()
+	kf.applyIn(Command{Op: OpTypeNsCreate, Key: "a"}, 1, 0)
+	kf.applyIn(Command{Op: OpTypeNsCreate, Key: "b"}, 2, 0)
+	if res, err := kf.applyIn(Command{Op: OpTypeGrant, Ttl: 1000, Namespace: "a"}, 3, 0); err != nil || res.Lease != 3 {
+		t.Fatalf("expected lease 3 to be granted, got %+v, err %v", res, err)
+	}
+	kf.applyIn(Command{Op: OpTypeSet, Key: "k", Value: []byte("1"), Lease: 3, Namespace: "a"}, 4, 0)
+
+	for _, ns := range []string{"", "b"} {
+		for _, c := range []Command{
+			{Op: OpTypeKeepAlive, Lease: 3},
+			{Op: OpTypeRevoke, Lease: 3},
+			{Op: OpTypeSet, Key: "k", Value: []byte("2"), Lease: 3},
+			{Op: OpTypeTxn, Then: []Command{{Op: OpTypeSet, Key: "k", Value: []byte("2"), Lease: 3}}},
+		} {
+			c.Namespace = ns
+			if _, err := kf.applyIn(c, 5, 0); !errors.Is(err, ErrLeaseNotFound) {
+				t.Errorf("%q: expected ErrLeaseNotFound for %s on a lease of another namespace, got %v", ns, c.Op, err)
+			}
+		}
+	}
+	if _, ok := kf.load(nsKey("a", "k"), 0); !ok {
+		t.Errorf("expected key of the lease to be kept")
+	}
+	if _, err := kf.applyIn(Command{Op: OpTypeKeepAlive, Lease: 3, Namespace: "a"}, 6, 0); err != nil {
+		t.Errorf("expected keepalive in the namespace of the lease, got %v", err)
+	}
+
+	if revokes := kf.expiredLeases(2000); !reflect.DeepEqual(revokes, []Command{{Op: OpTypeRevoke, Lease: 3, Namespace: "a"}}) {
+		t.Errorf("expected expired lease to be revoked in its namespace, got %+v", revokes)
+	}
+	kf.applyIn(Command{Op: OpTypeNsDelete, Key: "a"}, 7, 0)
+	if len(kf.leases) != 0 {
+		t.Errorf("expected leases of a deleted namespace to be deleted, got %v", kf.leases)
+	}
+}
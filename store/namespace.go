@@ -0,0 +1,442 @@
+package store
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Keys, queues and indexes of a namespace are stored under the name of the
+// namespace enclosed in NUL bytes, which keys of the default namespace may
+// not start with. The default namespace has no prefix.
+const nsSep = "\x00"
+
+// Namespace names are made of letters, digits, '_', '.' and '-'
+var namespaceName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,62}$`)
+
+// nsKey returns the key under which a key of a namespace is stored.
+func nsKey(ns, key string) string {
+	if ns == "" {
+		return key
+	}
+	return nsSep + ns + nsSep + key
+}
+
+// splitKey returns the namespace of a stored key and the key within it.
+func splitKey(key string) (string, string) {
+	rest, ok := strings.CutPrefix(key, nsSep)
+	if !ok {
+		return "", key
+	}
+	ns, key, _ := strings.Cut(rest, nsSep)
+	return ns, key
+}
+
+// NamespaceInfo describes a namespace and the usage of its quotas.
+type NamespaceInfo struct {
+	Name string `json:"name"`
+
+	// Maximum number of keys and bytes of keys and values, zero for no limit
+	MaxKeys  int64 `json:"max_keys,omitempty"`
+	MaxBytes int64 `json:"max_bytes,omitempty"`
+
+	// Current number of keys, and bytes of keys, values and queued messages
+	Keys  int64 `json:"keys"`
+	Bytes int64 `json:"bytes"`
+
+	// SHA-256 hash of the token granting access to the namespace, hex encoded
+	TokenHash string `json:"-"`
+}
+
+// namespace is the state of a namespace. Usage counts the latest revisions of
+// the keys that are not deleted, including expired ones until they are, and
+// the messages of its queues.
+type namespace struct {
+	maxKeys, maxBytes int64
+	keys, bytes       int64
+	tokenHash         string
+}
+
+// exceeds reports whether a namespace is over one of its quotas and its
+// usage grew beyond the given one.
+func (ns *namespace) exceeds(keys, bytes int64) bool {
+	return (ns.maxKeys > 0 && ns.keys > ns.maxKeys && ns.keys > keys) ||
+		(ns.maxBytes > 0 && ns.bytes > ns.maxBytes && ns.bytes > bytes)
+}
+
+// entrySize returns the number of bytes a key and its entry count for in quotas.
+func entrySize(key string, e Entry) int64 {
+	_, key = splitKey(key)
+	n := len(key) + len(e.Value)
+	for f, v := range e.Hash {
+		n += len(f) + len(v)
+	}
+	for _, v := range e.List {
+		n += len(v)
+	}
+	for m := range e.Set {
+		n += len(m)
+	}
+	return int64(n)
+}
+
+// account updates the usage of the namespace of a key whose latest revision
+// changed from prev to cur. The caller must hold the write lock.
+func (kf *kvFsm) account(key string, prev revision, hadPrev bool, cur revision, hasCur bool) {
+	name, _ := splitKey(key)
+	ns, ok := kf.namespaces[name]
+	if !ok {
+		return
+	}
+
+	if hadPrev && !prev.deleted {
+		ns.keys--
+		ns.bytes -= entrySize(key, prev.Entry)
+	}
+	if hasCur && !cur.deleted {
+		ns.keys++
+		ns.bytes += entrySize(key, cur.Entry)
+	}
+}
+
+// accountQueue updates the usage of the namespace of a queue whose messages
+// grew by the given number of bytes. The caller must hold the write lock.
+func (kf *kvFsm) accountQueue(name string, bytes int64) {
+	ns, _ := splitKey(name)
+	if ns, ok := kf.namespaces[ns]; ok {
+		ns.bytes += bytes
+	}
+}
+
+// saved is the state of the keys and queues written by commands, so that the
+// commands can be rolled back.
+type saved struct {
+	keys   map[string][]revision
+	queues map[string][]message
+}
+
+// save returns the current histories of the keys and messages of the queues
+// written by commands, so that they can be restored if the commands have to
+// be rolled back. The caller must hold the lock.
+func (kf *kvFsm) save(ops []Command) saved {
+	// Revisions are only ever appended, so the current histories of the
+	// keys are enough to roll back. So are the messages of the queues, as
+	// commands that can go over a quota only append to them.
+	sv := saved{keys: make(map[string][]revision, len(ops))}
+	for _, op := range ops {
+		switch op.Op {
+		case OpTypeEnqueue, OpTypeDequeue, OpTypeAck, OpTypePeek:
+			if sv.queues == nil {
+				sv.queues = make(map[string][]message)
+			}
+			if _, ok := sv.queues[op.Key]; !ok {
+				sv.queues[op.Key] = kf.queues[op.Key].all()
+			}
+			continue
+		}
+		if _, ok := sv.keys[op.Key]; !ok {
+			sv.keys[op.Key] = kf.data[op.Key]
+		}
+	}
+	return sv
+}
+
+// restore rolls the keys and queues back to their saved state, along with the
+// usage of their namespaces and the indexes. The caller must hold the write lock.
+func (kf *kvFsm) restore(sv saved) {
+	for key, h := range sv.keys {
+		prev, hadPrev := kf.latest(key)
+		if h == nil {
+			delete(kf.data, key)
+		} else {
+			kf.data[key] = h
+		}
+
+		cur, hasCur := kf.latest(key)
+		kf.account(key, prev, hadPrev, cur, hasCur)
+		kf.reindex(key)
+	}
+
+	for name, messages := range sv.queues {
+		kf.accountQueue(name, messagesSize(messages)-messagesSize(kf.queues[name].all()))
+		if len(messages) == 0 {
+			delete(kf.queues, name)
+		} else {
+			kf.queues[name] = &queue{messages: messages}
+		}
+	}
+}
+
+// scope returns a command of a namespace with its keys, queues and indexes
+// replaced by the ones under which they are stored.
+func scope(c Command, ns string) Command {
+	c.Key = nsKey(ns, c.Key)
+	if c.Index != "" {
+		c.Index = nsKey(ns, c.Index)
+	}
+
+	c.Compare = slices.Clone(c.Compare)
+	for i := range c.Compare {
+		c.Compare[i].Key = nsKey(ns, c.Compare[i].Key)
+	}
+	for _, ops := range []*[]Command{&c.Then, &c.Else} {
+		*ops = slices.Clone(*ops)
+		for i, op := range *ops {
+			(*ops)[i] = scope(op, ns)
+		}
+	}
+	return c
+}
+
+// applyIn executes a validated command in its namespace. Commands that leave
+// their namespace over quota are rolled back and fail with ErrQuotaExceeded.
+// The caller must hold the write lock.
+func (kf *kvFsm) applyIn(c Command, index uint64, now int64) (*Result, error) {
+	switch c.Op {
+	case OpTypeNsCreate, OpTypeNsUpdate, OpTypeNsDelete:
+		return kf.applyNamespace(c, index), nil
+	case opTypeNode:
+		return kf.applyNode(c), nil
+	}
+	// Leases of other namespaces are treated as missing
+	if !kf.leasesIn(c, c.Namespace) {
+		return nil, ErrLeaseNotFound
+	}
+	if c.Namespace == "" {
+		return kf.apply(c, index, now)
+	}
+
+	ns, ok := kf.namespaces[c.Namespace]
+	if !ok {
+		return nil, ErrNamespaceNotFound
+	}
+	c = scope(c, c.Namespace)
+
+	keys, bytes := ns.keys, ns.bytes
+	saved := kf.save(slices.Concat([]Command{c}, c.Then, c.Else))
+	res, err := kf.apply(c, index, now)
+	if err != nil {
+		return nil, err
+	}
+	if ns.exceeds(keys, bytes) {
+		kf.restore(saved)
+		return nil, ErrQuotaExceeded
+	}
+	return res, nil
+}
+
+// applyNamespace executes a command creating, updating or deleting the
+// namespace named by its key. Deleting a namespace deletes its keys, queues,
+// indexes and leases at the given Raft index. The caller must hold the write lock.
+func (kf *kvFsm) applyNamespace(c Command, index uint64) *Result {
+	ns, exists := kf.namespaces[c.Key]
+	switch c.Op {
+	case OpTypeNsCreate:
+		if exists {
+			return &Result{Applied: false}
+		}
+		kf.namespaces[c.Key] = &namespace{maxKeys: c.MaxKeys, maxBytes: c.MaxBytes, tokenHash: c.TokenHash}
+	case OpTypeNsUpdate:
+		if !exists {
+			return &Result{Applied: false}
+		}
+		ns.maxKeys, ns.maxBytes = c.MaxKeys, c.MaxBytes
+		if c.TokenHash != "" {
+			ns.tokenHash = c.TokenHash
+		}
+	case OpTypeNsDelete:
+		if !exists {
+			return &Result{Applied: false}
+		}
+
+		prefix := nsKey(c.Key, "")
+		for key := range kf.data {
+			if r, _ := kf.latest(key); strings.HasPrefix(key, prefix) && !r.deleted {
+				kf.put(key, revision{Entry: Entry{Version: index}, deleted: true})
+			}
+		}
+		for name := range kf.queues {
+			if strings.HasPrefix(name, prefix) {
+				delete(kf.queues, name)
+			}
+		}
+		for name := range kf.indexes {
+			if strings.HasPrefix(name, prefix) {
+				delete(kf.indexes, name)
+			}
+		}
+		for id, l := range kf.leases {
+			if l.ns == c.Key {
+				delete(kf.leases, id)
+			}
+		}
+		delete(kf.namespaces, c.Key)
+	}
+	return &Result{Applied: true}
+}
+
+func (ns *namespace) info(name string) NamespaceInfo {
+	return NamespaceInfo{Name: name, MaxKeys: ns.maxKeys, MaxBytes: ns.maxBytes, Keys: ns.keys, Bytes: ns.bytes, TokenHash: ns.tokenHash}
+}
+
+// getNamespace returns a namespace.
+func (kf *kvFsm) getNamespace(name string) (NamespaceInfo, bool) {
+	kf.mu.RLock()
+	defer kf.mu.RUnlock()
+
+	ns, ok := kf.namespaces[name]
+	if !ok {
+		return NamespaceInfo{}, false
+	}
+	return ns.info(name), true
+}
+
+// listNamespaces returns the namespaces ordered by name.
+func (kf *kvFsm) listNamespaces() []NamespaceInfo {
+	kf.mu.RLock()
+	defer kf.mu.RUnlock()
+
+	infos := make([]NamespaceInfo, 0, len(kf.namespaces))
+	for name, ns := range kf.namespaces {
+		infos = append(infos, ns.info(name))
+	}
+	slices.SortFunc(infos, func(a, b NamespaceInfo) int { return strings.Compare(a.Name, b.Name) })
+	return infos
+}
+
+// namespaced is a view of the store scoped to a namespace. Commands applied
+// through it are run in the namespace, and reads only see its keys.
+type namespaced struct {
+	s  *Store
+	ns string
+}
+
+// In returns a view of the store scoped to a namespace. The default
+// namespace is the empty one.
+func (s *Store) In(ns string) IStore {
+	return &namespaced{s: s, ns: ns}
+}
+
+func (n *namespaced) Apply(data []byte) (*Result, error) {
+	c, err := parseCommand(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkSize(c, n.s.config.MaxKeySize, n.s.config.MaxValueSize); err != nil {
+		return nil, err
+	}
+
+	// Cluster-wide commands can only be run outside of namespaces
+	if n.ns != "" {
+		switch c.Op {
+		case OpTypeCompact, OpTypeNsCreate, OpTypeNsUpdate, OpTypeNsDelete:
+			return nil, &ValidationError{Fields: []FieldError{{Field: "op", Message: "is not allowed in a namespace"}}}
+		}
+	}
+
+	c.Namespace = n.ns
+	return n.s.propose(c)
+}
+
+// hidden reports whether a key names a key of another namespace, which
+// reads in the default namespace must not see.
+func (n *namespaced) hidden(key string) bool {
+	return n.ns == "" && strings.HasPrefix(key, nsSep)
+}
+
+func (n *namespaced) Get(key string) (Entry, bool) {
+	if n.hidden(key) {
+		return Entry{}, false
+	}
+	return n.s.fsm.get(nsKey(n.ns, key), time.Now().UnixMilli())
+}
+
+func (n *namespaced) GetAt(key string, revision uint64) (Entry, bool, error) {
+	e, ok, err := n.s.fsm.getAt(nsKey(n.ns, key), revision, time.Now().UnixMilli())
+	if n.hidden(key) {
+		// The revision is still checked
+		return Entry{}, false, err
+	}
+	return e, ok, err
+}
+
+func (n *namespaced) MGet(keys []string) ([]Lookup, uint64) {
+	stored := make([]string, len(keys))
+	for i, key := range keys {
+		stored[i] = nsKey(n.ns, key)
+	}
+
+	lookups, index := n.s.fsm.getMany(stored, time.Now().UnixMilli())
+	for i := range lookups {
+		if n.hidden(keys[i]) {
+			lookups[i] = Lookup{}
+		}
+		lookups[i].Key = keys[i]
+	}
+	return lookups, index
+}
+
+func (n *namespaced) Range(start, end string, revision uint64, limit int) ([]Lookup, uint64, error) {
+	if n.ns == "" {
+		// Keys of other namespaces start with a NUL byte, before any other key
+		start = max(start, "\x01")
+	} else {
+		start = nsKey(n.ns, start)
+		if end == "" {
+			end = nsSep + n.ns + "\x01"
+		} else {
+			end = nsKey(n.ns, end)
+		}
+	}
+
+	lookups, revision, err := n.s.fsm.rangeAt(start, end, revision, limit, time.Now().UnixMilli())
+	for i := range lookups {
+		_, lookups[i].Key = splitKey(lookups[i].Key)
+	}
+	return lookups, revision, err
+}
+
+func (n *namespaced) QueryIndex(name string, q IndexQuery) ([]Lookup, uint64, error) {
+	if ns, _ := splitKey(name); ns != "" {
+		return nil, 0, ErrIndexNotFound
+	}
+
+	lookups, index, err := n.s.fsm.queryIndex(nsKey(n.ns, name), q, time.Now().UnixMilli())
+	for i := range lookups {
+		_, lookups[i].Key = splitKey(lookups[i].Key)
+	}
+	return lookups, index, err
+}
+
+func (n *namespaced) Indexes() []IndexInfo {
+	var infos []IndexInfo
+	for _, info := range n.s.fsm.listIndexes() {
+		if ns, name := splitKey(info.Name); ns == n.ns {
+			info.Name = name
+			_, info.Prefix = splitKey(info.Prefix)
+			infos = append(infos, info)
+		}
+	}
+	return infos
+}
+
+// Keys returns the keys of the namespace in lexical order.
+func (n *namespaced) Keys() []string {
+	var keys []string
+	for _, key := range n.s.fsm.keys(time.Now().UnixMilli()) {
+		if ns, key := splitKey(key); ns == n.ns {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func (n *namespaced) In(ns string) IStore                         { return n.s.In(ns) }
+func (n *namespaced) Namespace(name string) (NamespaceInfo, bool) { return n.s.Namespace(name) }
+func (n *namespaced) Namespaces() []NamespaceInfo                 { return n.s.Namespaces() }
+func (n *namespaced) AddFollower(id, addr, httpAddr string) error {
+	return n.s.AddFollower(id, addr, httpAddr)
+}
+func (n *namespaced) RemoveFollower(id string) error { return n.s.RemoveFollower(id) }
@@ -0,0 +1,208 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestNamespace_Scope(t *testing.T) {
+	kf := newTestFsm()
+	kf.applyIn(Command{Op: OpTypeNsCreate, Key: "a"}, 1, 0)
+
+	if _, err := kf.applyIn(Command{Op: OpTypeSet, Key: "k", Value: []byte("1"), Namespace: "missing"}, 2, 0); !errors.Is(err, ErrNamespaceNotFound) {
+		t.Errorf("expected ErrNamespaceNotFound, got %v", err)
+	}
+
+	kf.applyIn(Command{Op: OpTypeSet, Key: "k", Value: []byte("1"), Namespace: "a"}, 3, 0)
+	kf.applyIn(Command{Op: OpTypeSet, Key: "k", Value: []byte("2")}, 4, 0)
+	res, _ := kf.applyIn(Command{
+		Op:        OpTypeTxn,
+		Compare:   []Compare{{Key: "k", Target: CompareValue, Op: CompareEqual, Value: []byte("1")}},
+		Then:      []Command{{Op: OpTypeSet, Key: "j", Value: []byte("3")}},
+		Namespace: "a",
+	}, 5, 0)
+	if !res.Succeeded {
+		t.Errorf("expected comparison to read the key of the namespace, got %+v", res)
+	}
+
+	s := &Store{fsm: kf}
+	kf.index = 5
+	if e, _ := s.In("a").Get("k"); string(e.Value) != "1" {
+		t.Errorf("expected value of the namespace, got %q", e.Value)
+	}
+	if e, _ := s.Get("k"); string(e.Value) != "2" {
+		t.Errorf("expected value of the default namespace, got %q", e.Value)
+	}
+	if keys := s.In("a").Keys(); !reflect.DeepEqual(keys, []string{"j", "k"}) {
+		t.Errorf("expected keys of the namespace, got %v", keys)
+	}
+	if keys := s.Keys(); !reflect.DeepEqual(keys, []string{"k"}) {
+		t.Errorf("expected keys of the default namespace, got %v", keys)
+	}
+	if lookups, _, _ := s.In("a").Range("", "", 0, 0); len(lookups) != 2 || lookups[0].Key != "j" {
+		t.Errorf("expected range over the namespace, got %+v", lookups)
+	}
+	if lookups, _, _ := s.Range("", "", 0, 0); len(lookups) != 1 || lookups[0].Key != "k" {
+		t.Errorf("expected range over the default namespace, got %+v", lookups)
+	}
+	if lookups, _ := s.In("a").MGet([]string{"k"}); lookups[0].Key != "k" || string(lookups[0].Entry.Value) != "1" {
+		t.Errorf("expected mget in the namespace, got %+v", lookups)
+	}
+}
+
+func TestNamespace_Quota(t *testing.T) {
+	kf := newTestFsm()
+	kf.applyIn(Command{Op: OpTypeNsCreate, Key: "a", MaxKeys: 2, MaxBytes: 10}, 1, 0)
+
+	set := func(key, value string, index uint64) error {
+		_, err := kf.applyIn(Command{Op: OpTypeSet, Key: key, Value: []byte(value), Namespace: "a"}, index, 0)
+		return err
+	}
+
+	if err := set("k1", "abc", 2); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := set("k2", "abcdefg", 3); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("expected bytes quota to be exceeded, got %v", err)
+	}
+	if _, ok := kf.load(nsKey("a", "k2"), 0); ok {
+		t.Errorf("expected write over quota to be rolled back")
+	}
+	set("k2", "a", 4)
+	if err := set("k3", "", 5); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("expected keys quota to be exceeded, got %v", err)
+	}
+
+	// A transaction over quota is rolled back as a whole
+	_, err := kf.applyIn(Command{Op: OpTypeTxn, Namespace: "a", Then: []Command{
+		{Op: OpTypeDelete, Key: "k1"},
+		{Op: OpTypeSet, Key: "k2", Value: []byte("abcdefghi")},
+	}}, 6, 0)
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("expected transaction to exceed the quota, got %v", err)
+	}
+	if info, _ := kf.getNamespace("a"); info.Keys != 2 || info.Bytes != 8 {
+		t.Errorf("expected usage of 2 keys and 8 bytes, got %+v", info)
+	}
+
+	// Lowering a quota does not prevent deletions
+	kf.applyIn(Command{Op: OpTypeNsUpdate, Key: "a", MaxKeys: 1}, 7, 0)
+	if _, err := kf.applyIn(Command{Op: OpTypeDelete, Key: "k1", Namespace: "a"}, 8, 0); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if info, _ := kf.getNamespace("a"); info.Keys != 1 || info.Bytes != 3 {
+		t.Errorf("expected usage of 1 key and 3 bytes, got %+v", info)
+	}
+}
+
+func TestNamespace_QueueQuota(t *testing.T) {
+	kf := newTestFsm()
+	kf.applyIn(Command{Op: OpTypeNsCreate, Key: "a", MaxBytes: 10}, 1, 0)
+
+	enqueue := func(value string, index uint64) error {
+		_, err := kf.applyIn(Command{Op: OpTypeEnqueue, Key: "q", Value: []byte(value), Namespace: "a"}, index, 0)
+		return err
+	}
+
+	if err := enqueue("abcdef", 2); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := enqueue("abcdef", 3); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("expected messages to count toward the bytes quota, got %v", err)
+	}
+	if n := len(kf.queues[nsKey("a", "q")].messages); n != 1 {
+		t.Errorf("expected message over quota to be rolled back, got %d messages", n)
+	}
+	if info, _ := kf.getNamespace("a"); info.Bytes != 6 {
+		t.Errorf("expected usage of 6 bytes, got %+v", info)
+	}
+
+	// Acknowledged messages free their bytes
+	res, _ := kf.applyIn(Command{Op: OpTypeDequeue, Key: "q", Ttl: 1000, Namespace: "a"}, 4, 0)
+	kf.applyIn(Command{Op: OpTypeAck, Key: "q", Receipt: res.Receipt, Namespace: "a"}, 5, 0)
+	if info, _ := kf.getNamespace("a"); info.Bytes != 0 {
+		t.Errorf("expected usage of 0 bytes, got %+v", info)
+	}
+	if err := enqueue("abcdef", 6); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestNamespace_Delete(t *testing.T) {
+	kf := newTestFsm()
+	kf.applyIn(Command{Op: OpTypeNsCreate, Key: "a"}, 1, 0)
+	kf.applyIn(Command{Op: OpTypeSet, Key: "k", Value: []byte("1"), Namespace: "a"}, 2, 0)
+	kf.applyIn(Command{Op: OpTypeEnqueue, Key: "q", Value: []byte("m"), Namespace: "a"}, 3, 0)
+	kf.applyIn(Command{Op: OpTypeCreateIndex, Path: "/x", Index: "i", Namespace: "a"}, 4, 0)
+	kf.applyIn(Command{Op: OpTypeSet, Key: "k", Value: []byte("2")}, 5, 0)
+
+	res, _ := kf.applyIn(Command{Op: OpTypeNsDelete, Key: "a"}, 6, 0)
+	if !res.Applied {
+		t.Fatalf("expected namespace to be deleted")
+	}
+	if _, ok := kf.load(nsKey("a", "k"), 0); ok {
+		t.Errorf("expected keys of the namespace to be deleted")
+	}
+	if _, ok := kf.load("k", 0); !ok {
+		t.Errorf("expected keys of the default namespace to be kept")
+	}
+	if len(kf.queues) != 0 || len(kf.indexes) != 0 || len(kf.namespaces) != 0 {
+		t.Errorf("expected queues, indexes and namespace to be deleted")
+	}
+	if res, _ := kf.applyIn(Command{Op: OpTypeNsDelete, Key: "a"}, 7, 0); res.Applied {
+		t.Errorf("expected delete of missing namespace not to apply")
+	}
+}
+
+func TestNamespace_Index(t *testing.T) {
+	kf := newTestFsm()
+	kf.applyIn(Command{Op: OpTypeNsCreate, Key: "a"}, 1, 0)
+	kf.applyIn(Command{Op: OpTypeSet, Key: "k", Value: []byte(`{"x":1}`), Namespace: "a"}, 2, 0)
+	kf.applyIn(Command{Op: OpTypeSet, Key: "k", Value: []byte(`{"x":1}`)}, 3, 0)
+	kf.applyIn(Command{Op: OpTypeCreateIndex, Path: "/x", Index: "by_x", Namespace: "a"}, 4, 0)
+	kf.applyIn(Command{Op: OpTypeCreateIndex, Path: "/x", Index: "by_x"}, 5, 0)
+
+	s := &Store{fsm: kf}
+	for _, ns := range []string{"", "a"} {
+		lookups, _, err := s.In(ns).QueryIndex("by_x", IndexQuery{Value: json.RawMessage(`1`)})
+		if err != nil || len(lookups) != 1 || lookups[0].Key != "k" {
+			t.Errorf("%q: expected only the key of the namespace, got %+v (%v)", ns, lookups, err)
+		}
+		if infos := s.In(ns).Indexes(); len(infos) != 1 || infos[0].Name != "by_x" || infos[0].Count != 1 {
+			t.Errorf("%q: expected the index of the namespace, got %+v", ns, infos)
+		}
+	}
+}
+
+func TestNamespace_DefaultCannotReadOthers(t *testing.T) {
+	kf := newTestFsm()
+	kf.applyIn(Command{Op: OpTypeNsCreate, Key: "team"}, 1, 0)
+	kf.applyIn(Command{Op: OpTypeSet, Key: "secret", Value: []byte("1"), Namespace: "team"}, 2, 0)
+	kf.index = 2
+	s := &Store{fsm: kf}
+
+	stored := nsKey("team", "secret")
+	for name, ns := range map[string]IStore{"store": s, "default namespace": s.In("")} {
+		if _, ok := ns.Get(stored); ok {
+			t.Errorf("%s: expected get of another namespace's key not to be found", name)
+		}
+		if _, ok, err := ns.GetAt(stored, 2); ok || err != nil {
+			t.Errorf("%s: expected get at a revision of another namespace's key not to be found, got %t %v", name, ok, err)
+		}
+		if _, _, err := ns.GetAt(stored, 3); !errors.Is(err, ErrFutureRevision) {
+			t.Errorf("%s: expected revision to be checked, got %v", name, err)
+		}
+		if lookups, _ := ns.MGet([]string{stored}); lookups[0].Found || lookups[0].Entry.Value != nil || lookups[0].Key != stored {
+			t.Errorf("%s: expected mget of another namespace's key not to be found, got %+v", name, lookups[0])
+		}
+		if lookups, _, _ := ns.Range(nsSep, "", 0, 0); len(lookups) != 0 {
+			t.Errorf("%s: expected range not to include other namespaces, got %+v", name, lookups)
+		}
+	}
+
+	if e, ok := s.In("team").Get("secret"); !ok || string(e.Value) != "1" {
+		t.Errorf("expected the namespace to read its key, got %+v %t", e, ok)
+	}
+}
@@ -0,0 +1,62 @@
+package store
+
+import (
+	"log"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// How often the leader checks that its HTTP address is recorded
+const nodeCheckInterval = time.Second
+
+// applyNode records the HTTP address of a node. The caller must hold the
+// write lock.
+func (kf *kvFsm) applyNode(c Command) *Result {
+	addr := string(c.Value)
+	if kf.nodes[c.Key] == addr {
+		return &Result{Applied: false}
+	}
+	kf.nodes[c.Key] = addr
+	return &Result{Applied: true}
+}
+
+// nodeAddr returns the recorded HTTP address of a node, or an empty string.
+func (kf *kvFsm) nodeAddr(id string) string {
+	kf.mu.RLock()
+	defer kf.mu.RUnlock()
+	return kf.nodes[id]
+}
+
+// recordNode replicates the HTTP address of a node, unless it is already
+// recorded.
+func (s *Store) recordNode(id, httpAddr string) error {
+	if httpAddr == "" || s.fsm.nodeAddr(id) == httpAddr {
+		return nil
+	}
+	_, err := s.propose(Command{Op: opTypeNode, Key: id, Value: []byte(httpAddr)})
+	return err
+}
+
+// announce records the HTTP advertise address of the node while it is the
+// leader, so that the other nodes can direct clients to it. Followers have
+// theirs recorded by the leader when they join. This is a blocking call,
+// which returns once the store is shut down.
+func (s *Store) announce() {
+	ticker := time.NewTicker(nodeCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+		if s.raft.State() != raft.Leader {
+			continue
+		}
+		if err := s.recordNode(s.config.NodeID, s.config.HttpAdvertiseAddr); err != nil {
+			log.Printf("Could not record HTTP address %s of node %s: %s", s.config.HttpAdvertiseAddr, s.config.NodeID, err)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package store
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestApplyNode(t *testing.T) {
+	kf := newTestFsm()
+
+	if res, err := kf.applyIn(Command{Op: opTypeNode, Key: "node1", Value: []byte("node1:8221")}, 1, 0); err != nil || !res.Applied {
+		t.Fatalf("expected node to be recorded, got %+v %v", res, err)
+	}
+	if res, _ := kf.applyIn(Command{Op: opTypeNode, Key: "node1", Value: []byte("node1:8221")}, 2, 0); res.Applied {
+		t.Errorf("expected unchanged address not to be applied")
+	}
+	if addr := kf.nodeAddr("node1"); addr != "node1:8221" {
+		t.Errorf("expected node1:8221, got %q", addr)
+	}
+	if _, ok := kf.load("node1", 0); ok {
+		t.Errorf("expected node records to be separate from the keys")
+	}
+}
+
+func TestStore_RecordNode(t *testing.T) {
+	s := newTestStore(t, Config{HttpAdvertiseAddr: "node1:8221"})
+
+	if err := s.recordNode("node1", s.config.HttpAdvertiseAddr); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	var nlerr *NotLeaderError
+	if !errors.As(s.notLeader(), &nlerr) || nlerr.LeaderHttpAddr != "node1:8221" {
+		t.Errorf("expected leader HTTP address node1:8221, got %+v", nlerr)
+	}
+
+	// Only the store records nodes
+	var verr *ValidationError
+	if _, err := s.Apply([]byte(`{"op": "node", "key": "node2", "value": "bm9kZTI6ODIyMg=="}`)); !errors.As(err, &verr) {
+		t.Errorf("expected a validation error, got %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+package store
+
+import "slices"
+
+// Visibility timeout in milliseconds of dequeue commands without a ttl
+const defaultVisibilityTimeout = 30000
+
+// queue is an ordered list of messages. Received messages stay in the queue,
+// hidden until their visibility timeout, so that they are delivered again if
+// they are not acknowledged in time.
+type queue struct {
+	messages []message
+}
+
+type message struct {
+	// Raft index of the enqueue command
+	id    uint64
+	value []byte
+
+	// Raft index of the dequeue command that last received the message,
+	// zero if it was never received
+	receipt uint64
+
+	// Unix time in milliseconds until which the message is hidden
+	hiddenUntil int64
+}
+
+// applyQueue executes a queue command committed at the given Raft index and
+// unix time in milliseconds. The caller must hold the write lock.
+func (kf *kvFsm) applyQueue(c Command, index uint64, now int64) *Result {
+	q := kf.queues[c.Key]
+
+	switch c.Op {
+	case OpTypeEnqueue:
+		if q == nil {
+			q = &queue{}
+			kf.queues[c.Key] = q
+		}
+		q.messages = append(q.messages, message{id: index, value: c.Value})
+		kf.accountQueue(c.Key, int64(len(c.Value)))
+		return &Result{Applied: true, Version: index}
+	case OpTypeDequeue, OpTypePeek:
+		m := q.visible(now)
+		if m == nil {
+			return &Result{Applied: false}
+		}
+		if c.Op == OpTypePeek {
+			// The receipt is only given to the consumer receiving the message
+			return &Result{Applied: true, Value: m.value, Version: m.id}
+		}
+		m.receipt = index
+		m.hiddenUntil = now + c.Ttl
+		return &Result{Applied: true, Value: m.value, Version: m.id, Receipt: m.receipt}
+	case OpTypeAck:
+		if q == nil {
+			return &Result{Applied: false}
+		}
+
+		// A message received again after its visibility timeout can only be
+		// acknowledged with its latest receipt
+		i := slices.IndexFunc(q.messages, func(m message) bool { return m.receipt == c.Receipt })
+		if i < 0 {
+			return &Result{Applied: false}
+		}
+		id := q.messages[i].id
+		kf.accountQueue(c.Key, -int64(len(q.messages[i].value)))
+		q.messages = slices.Delete(q.messages, i, i+1)
+		if len(q.messages) == 0 {
+			delete(kf.queues, c.Key)
+		}
+		return &Result{Applied: true, Version: id}
+	}
+	return &Result{Applied: false}
+}
+
+// visible returns the first message that is not hidden at the given unix time
+// in milliseconds, or nil if there is none.
+func (q *queue) visible(now int64) *message {
+	if q == nil {
+		return nil
+	}
+	for i := range q.messages {
+		if q.messages[i].hiddenUntil <= now {
+			return &q.messages[i]
+		}
+	}
+	return nil
+}
+
+// all returns the messages of a queue, or nil if there is none.
+func (q *queue) all() []message {
+	if q == nil {
+		return nil
+	}
+	return q.messages
+}
+
+// messagesSize returns the number of bytes messages count for in quotas.
+func messagesSize(messages []message) int64 {
+	var n int64
+	for _, m := range messages {
+		n += int64(len(m.value))
+	}
+	return n
+}
@@ -0,0 +1,50 @@
+package store
+
+import "testing"
+
+func TestApply_Queue(t *testing.T) {
+	kf := newTestFsm()
+	kf.apply(Command{Op: OpTypeEnqueue, Key: "jobs", Value: []byte("a")}, 1, 0)
+	kf.apply(Command{Op: OpTypeEnqueue, Key: "jobs", Value: []byte("b")}, 2, 0)
+
+	res, _ := kf.apply(Command{Op: OpTypePeek, Key: "jobs"}, 3, 0)
+	if !res.Applied || string(res.Value) != "a" || res.Version != 1 || res.Receipt != 0 {
+		t.Errorf("expected peek to return message 1, got %+v", res)
+	}
+
+	first, _ := kf.apply(Command{Op: OpTypeDequeue, Key: "jobs", Ttl: 1000}, 4, 0)
+	if !first.Applied || string(first.Value) != "a" || first.Receipt != 4 {
+		t.Fatalf("expected dequeue to receive message 1, got %+v", first)
+	}
+
+	// The received message is hidden until its visibility timeout
+	res, _ = kf.apply(Command{Op: OpTypeDequeue, Key: "jobs", Ttl: 1000}, 5, 500)
+	if string(res.Value) != "b" {
+		t.Errorf("expected dequeue to receive message 2, got %+v", res)
+	}
+	if res, _ := kf.apply(Command{Op: OpTypeDequeue, Key: "jobs", Ttl: 1000}, 6, 500); res.Applied {
+		t.Errorf("expected no visible message, got %+v", res)
+	}
+
+	// Peeking at a message visible again does not return its receipt
+	if res, _ := kf.apply(Command{Op: OpTypePeek, Key: "jobs"}, 7, 1000); string(res.Value) != "a" || res.Receipt != 0 {
+		t.Errorf("expected peek to return message 1 without its receipt, got %+v", res)
+	}
+
+	// Message 1 is delivered again, so that its first receipt is stale
+	again, _ := kf.apply(Command{Op: OpTypeDequeue, Key: "jobs", Ttl: 1000}, 7, 1000)
+	if string(again.Value) != "a" || again.Receipt != 7 {
+		t.Errorf("expected message 1 to be delivered again, got %+v", again)
+	}
+	if res, _ := kf.apply(Command{Op: OpTypeAck, Key: "jobs", Receipt: first.Receipt}, 8, 1000); res.Applied {
+		t.Errorf("expected ack with stale receipt not to apply")
+	}
+	if res, _ := kf.apply(Command{Op: OpTypeAck, Key: "jobs", Receipt: again.Receipt}, 9, 1000); !res.Applied || res.Version != 1 {
+		t.Errorf("expected ack to delete message 1, got %+v", res)
+	}
+
+	kf.apply(Command{Op: OpTypeAck, Key: "jobs", Receipt: 5}, 10, 1000)
+	if _, ok := kf.queues["jobs"]; ok {
+		t.Errorf("expected empty queue to be removed")
+	}
+}
@@ -0,0 +1,147 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/raft"
+)
+
+// RaftConfig tunes Raft and the transport between nodes.
+type RaftConfig struct {
+	// Time without contact from the leader before a follower starts an
+	// election, time without a leader before a candidate starts another one,
+	// and time without contact from a quorum before the leader steps down
+	HeartbeatTimeout   time.Duration
+	ElectionTimeout    time.Duration
+	LeaderLeaseTimeout time.Duration
+
+	// How often Raft checks whether to take a snapshot, and the number of log
+	// entries since the last snapshot that triggers one
+	SnapshotInterval  time.Duration
+	SnapshotThreshold uint64
+
+	// Number of log entries kept after a snapshot, so that slow followers can
+	// catch up without installing the snapshot
+	TrailingLogs uint64
+
+	// Maximum number of log entries sent to a follower in one request
+	MaxAppendEntries int
+
+	// Number of connections pooled to each node, and timeout of their I/O
+	PoolSize         int
+	TransportTimeout time.Duration
+
+	// Number of snapshots kept on disk
+	SnapshotsRetained int
+
+	// Time to wait for Raft to accept a command before failing with ErrTimeout
+	ApplyTimeout time.Duration
+
+	// Level of the logs of Raft: trace, debug, info, warn or error
+	LogLevel string
+}
+
+// DefaultRaftConfig returns the defaults of hashicorp/raft and of the
+// transport and snapshot store.
+func DefaultRaftConfig() RaftConfig {
+	d := raft.DefaultConfig()
+	return RaftConfig{
+		HeartbeatTimeout:   d.HeartbeatTimeout,
+		ElectionTimeout:    d.ElectionTimeout,
+		LeaderLeaseTimeout: d.LeaderLeaseTimeout,
+		SnapshotInterval:   d.SnapshotInterval,
+		SnapshotThreshold:  d.SnapshotThreshold,
+		TrailingLogs:       d.TrailingLogs,
+		MaxAppendEntries:   d.MaxAppendEntries,
+		PoolSize:           10,
+		TransportTimeout:   10 * time.Second,
+		SnapshotsRetained:  2,
+		ApplyTimeout:       5 * time.Second,
+		LogLevel:           d.LogLevel,
+	}
+}
+
+// raftConfig returns the Raft configuration of a node, logging to logger.
+func (c RaftConfig) raftConfig(id string, logger hclog.Logger) *raft.Config {
+	cfg := raft.DefaultConfig()
+	cfg.LocalID = raft.ServerID(id)
+	cfg.Logger = logger
+	cfg.HeartbeatTimeout = c.HeartbeatTimeout
+	cfg.ElectionTimeout = c.ElectionTimeout
+	cfg.LeaderLeaseTimeout = c.LeaderLeaseTimeout
+	cfg.SnapshotInterval = c.SnapshotInterval
+	cfg.SnapshotThreshold = c.SnapshotThreshold
+	cfg.TrailingLogs = c.TrailingLogs
+	cfg.MaxAppendEntries = c.MaxAppendEntries
+	return cfg
+}
+
+// Validate checks that the configuration is accepted by Raft, the transport
+// and the snapshot store.
+func (c RaftConfig) Validate() error {
+	if err := raft.ValidateConfig(c.raftConfig("validate", nil)); err != nil {
+		return err
+	}
+
+	switch {
+	case c.PoolSize < 1:
+		return errors.New("PoolSize must be positive")
+	case c.TransportTimeout <= 0:
+		return errors.New("TransportTimeout must be positive")
+	case c.SnapshotsRetained < 1:
+		return errors.New("SnapshotsRetained must be positive")
+	case c.ApplyTimeout <= 0:
+		return errors.New("ApplyTimeout must be positive")
+	case hclog.LevelFromString(c.LogLevel) == hclog.NoLevel:
+		return fmt.Errorf("LogLevel %q must be trace, debug, info, warn or error", c.LogLevel)
+	}
+	return nil
+}
+
+// newRaftLogger returns the logger of Raft, at the level of a configuration.
+func newRaftLogger(c RaftConfig) hclog.Logger {
+	return hclog.New(&hclog.LoggerOptions{
+		Name:   "raft",
+		Level:  hclog.LevelFromString(c.LogLevel),
+		Output: os.Stderr,
+	})
+}
+
+// Reload applies the settings of a Raft configuration that can be changed
+// while running: the heartbeat and election timeouts, snapshot interval and
+// threshold, trailing logs, apply timeout and log level. The other settings
+// only take effect when the store is created.
+func (s *Store) Reload(c RaftConfig) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+
+	if err := s.raft.ReloadConfig(raft.ReloadableConfig{
+		TrailingLogs:      c.TrailingLogs,
+		SnapshotInterval:  c.SnapshotInterval,
+		SnapshotThreshold: c.SnapshotThreshold,
+		HeartbeatTimeout:  c.HeartbeatTimeout,
+		ElectionTimeout:   c.ElectionTimeout,
+	}); err != nil {
+		return err
+	}
+	s.applyTimeout.Store(int64(c.ApplyTimeout))
+	s.logger.SetLevel(hclog.LevelFromString(c.LogLevel))
+
+	log.Printf("Reloaded raft configuration: %s", c)
+	return nil
+}
+
+func (c RaftConfig) String() string {
+	return fmt.Sprintf("heartbeat timeout %s, election timeout %s, leader lease timeout %s, "+
+		"snapshot interval %s, snapshot threshold %d, trailing logs %d, max append entries %d, "+
+		"pool size %d, transport timeout %s, snapshots retained %d, apply timeout %s, log level %s",
+		c.HeartbeatTimeout, c.ElectionTimeout, c.LeaderLeaseTimeout,
+		c.SnapshotInterval, c.SnapshotThreshold, c.TrailingLogs, c.MaxAppendEntries,
+		c.PoolSize, c.TransportTimeout, c.SnapshotsRetained, c.ApplyTimeout, c.LogLevel)
+}
@@ -0,0 +1,60 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+func TestRaftConfig_Validate(t *testing.T) {
+	if err := DefaultRaftConfig().Validate(); err != nil {
+		t.Fatalf("expected defaults to be valid, got %v", err)
+	}
+
+	tests := map[string]func(*RaftConfig){
+		"lease above heartbeat":    func(c *RaftConfig) { c.LeaderLeaseTimeout = 2 * c.HeartbeatTimeout },
+		"election below heartbeat": func(c *RaftConfig) { c.ElectionTimeout = c.HeartbeatTimeout / 2 },
+		"max append entries":       func(c *RaftConfig) { c.MaxAppendEntries = 0 },
+		"pool size":                func(c *RaftConfig) { c.PoolSize = 0 },
+		"snapshots retained":       func(c *RaftConfig) { c.SnapshotsRetained = 0 },
+		"apply timeout":            func(c *RaftConfig) { c.ApplyTimeout = 0 },
+	}
+	for name, modify := range tests {
+		c := DefaultRaftConfig()
+		modify(&c)
+		if err := c.Validate(); err == nil {
+			t.Errorf("%s: expected error, got nil", name)
+		}
+	}
+}
+
+func TestStore_Reload(t *testing.T) {
+	s := newTestStore(t, Config{})
+
+	c := s.config.Raft
+	c.HeartbeatTimeout = 100 * time.Millisecond
+	c.ElectionTimeout = 200 * time.Millisecond
+	c.TrailingLogs = 100
+	c.ApplyTimeout = time.Second
+	c.LogLevel = "warn"
+	if err := s.Reload(c); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	rc := s.raft.ReloadableConfig()
+	if rc.HeartbeatTimeout != c.HeartbeatTimeout || rc.ElectionTimeout != c.ElectionTimeout || rc.TrailingLogs != 100 {
+		t.Errorf("expected raft configuration to be reloaded, got %+v", rc)
+	}
+	if time.Duration(s.applyTimeout.Load()) != time.Second {
+		t.Errorf("expected apply timeout of 1s, got %s", time.Duration(s.applyTimeout.Load()))
+	}
+	if s.logger.GetLevel() != hclog.Warn {
+		t.Errorf("expected warn log level, got %s", s.logger.GetLevel())
+	}
+
+	c.ElectionTimeout = c.HeartbeatTimeout / 2
+	if err := s.Reload(c); err == nil {
+		t.Errorf("expected error for invalid configuration, got nil")
+	}
+}
@@ -6,11 +6,14 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/raft"
 	raftboltdb "github.com/hashicorp/raft-boltdb"
 )
@@ -18,9 +21,18 @@ import (
 // IStore defines the interface for a key-value store that uses Raft for consensus.
 // This interface allows for mocking in tests and provides a clear contract for the store's functionality.
 type IStore interface {
-	Apply([]byte) error
-	Get(string) (interface{}, bool)
-	AddFollower(string, string) error
+	Apply([]byte) (*Result, error)
+	Get(string) (Entry, bool)
+	GetAt(string, uint64) (Entry, bool, error)
+	MGet([]string) ([]Lookup, uint64)
+	Range(start, end string, revision uint64, limit int) ([]Lookup, uint64, error)
+	QueryIndex(name string, q IndexQuery) ([]Lookup, uint64, error)
+	Indexes() []IndexInfo
+	Keys() []string
+	In(namespace string) IStore
+	Namespace(name string) (NamespaceInfo, bool)
+	Namespaces() []NamespaceInfo
+	AddFollower(string, string, string) error
 	RemoveFollower(string) error
 }
 
@@ -32,62 +44,114 @@ type Config struct {
 	RaftAdvertiseAddr string
 	Bootstrap         bool
 	JoinAddr          string
+
+	// HTTP address of the node advertised to the other nodes, which direct
+	// clients to the leader at it. Not recorded if empty.
+	HttpAdvertiseAddr string
+
+	// Maximum sizes in bytes of keys and values, zero means no limit
+	MaxKeySize   int
+	MaxValueSize int
+
+	// Maximum number of concurrent commands the leader coalesces into one
+	// Raft entry, and how long it waits for more commands before sending a
+	// batch. A size of 1 or less sends each command in its own entry.
+	MaxBatchSize int
+	BatchLinger  time.Duration
+
+	// Tuning of Raft
+	Raft RaftConfig
 }
 
 // Store manages the Raft consensus and the key-value data.
 type Store struct {
 	config Config
 	raft   *raft.Raft
-	data   *sync.Map
+	fsm    *kvFsm
+
+	// Batcher of the commands proposed on the leader, nil if disabled
+	batcher *batcher
+
+	// Logger of Raft and apply timeout, which can be reloaded
+	logger       hclog.Logger
+	applyTimeout atomic.Int64
+
+	// Closed on shutdown to stop the batcher and the background loops
+	done     chan struct{}
+	shutdown sync.Once
 }
 
 // NewStore creates and initializes a new Store.
 func NewStore(cfg Config) (*Store, error) {
-	s := &Store{
-		config: cfg,
-		data:   &sync.Map{},
+	if err := cfg.Raft.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid raft configuration: %w", err)
 	}
+	log.Printf("Raft configuration: %s", cfg.Raft)
 
-	if err := os.MkdirAll(s.config.RaftDir, 0700); err != nil {
-		return nil, fmt.Errorf("could not create raft directory %s: %w", s.config.RaftDir, err)
+	if err := os.MkdirAll(cfg.RaftDir, 0700); err != nil {
+		return nil, fmt.Errorf("could not create raft directory %s: %w", cfg.RaftDir, err)
 	}
 
 	// BoltDB store for logs and stable store.
-	boltDBPath := path.Join(s.config.RaftDir, "raft.db")
+	boltDBPath := path.Join(cfg.RaftDir, "raft.db")
 	boltStore, err := raftboltdb.NewBoltStore(boltDBPath)
 	if err != nil {
 		return nil, fmt.Errorf("could not create bolt store at %s: %w", boltDBPath, err)
 	}
 
 	// Snapshot store.
-	snapshotPath := path.Join(s.config.RaftDir, "snapshots")
-	snapshots, err := raft.NewFileSnapshotStore(snapshotPath, 2, os.Stderr)
+	snapshotPath := path.Join(cfg.RaftDir, "snapshots")
+	snapshots, err := raft.NewFileSnapshotStore(snapshotPath, cfg.Raft.SnapshotsRetained, os.Stderr)
 	if err != nil {
 		return nil, fmt.Errorf("could not create snapshot store at %s: %w", snapshotPath, err)
 	}
 
-	advertiseAddr, err := net.ResolveTCPAddr("tcp", s.config.RaftAdvertiseAddr)
+	advertiseAddr, err := net.ResolveTCPAddr("tcp", cfg.RaftAdvertiseAddr)
 	if err != nil {
-		return nil, fmt.Errorf("could not resolve raft advertise address %s: %w", s.config.RaftAdvertiseAddr, err)
+		return nil, fmt.Errorf("could not resolve raft advertise address %s: %w", cfg.RaftAdvertiseAddr, err)
 	}
 
-	transport, err := raft.NewTCPTransport(s.config.RaftAddr, advertiseAddr, 10, time.Second*10, os.Stderr)
+	transport, err := raft.NewTCPTransport(cfg.RaftAddr, advertiseAddr, cfg.Raft.PoolSize, cfg.Raft.TransportTimeout, os.Stderr)
 	if err != nil {
 		return nil, fmt.Errorf("could not create tcp transport: %w", err)
 	}
 
-	raftCfg := raft.DefaultConfig()
-	raftCfg.LocalID = raft.ServerID(s.config.NodeID)
+	return newStore(cfg, boltStore, boltStore, snapshots, transport)
+}
+
+// NewInmemStore creates a Store backed by a single Raft node that keeps its
+// log and snapshots in memory and bootstraps itself. It is meant for tests,
+// so the directory and addresses of the configuration are ignored.
+func NewInmemStore(cfg Config) (*Store, error) {
+	if err := cfg.Raft.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid raft configuration: %w", err)
+	}
+
+	logs := raft.NewInmemStore()
+	_, transport := raft.NewInmemTransport("")
+	cfg.Bootstrap, cfg.JoinAddr = true, ""
+	return newStore(cfg, logs, logs, raft.NewInmemSnapshotStore(), transport)
+}
 
-	fsm := &kvFsm{data: s.data}
-	r, err := raft.NewRaft(raftCfg, fsm, boltStore, boltStore, snapshots, transport)
+// newStore starts Raft on the given storage and transport, bootstraps or joins
+// the cluster as configured, and starts the batcher and the background loops.
+func newStore(cfg Config, logs raft.LogStore, stable raft.StableStore, snapshots raft.SnapshotStore, transport raft.Transport) (*Store, error) {
+	s := &Store{
+		config: cfg,
+		fsm:    newKvFsm(),
+		logger: newRaftLogger(cfg.Raft),
+		done:   make(chan struct{}),
+	}
+	s.applyTimeout.Store(int64(cfg.Raft.ApplyTimeout))
+
+	r, err := raft.NewRaft(s.config.Raft.raftConfig(s.config.NodeID, s.logger), s.fsm, logs, stable, snapshots, transport)
 	if err != nil {
 		return nil, fmt.Errorf("could not create raft instance: %w", err)
 	}
 	s.raft = r
 
 	if s.config.Bootstrap {
-		hasState, err := raft.HasExistingState(boltStore, boltStore, snapshots)
+		hasState, err := raft.HasExistingState(logs, stable, snapshots)
 		if err != nil {
 			return nil, fmt.Errorf("failed to check for existing state: %v", err)
 		}
@@ -113,8 +177,11 @@ func NewStore(cfg Config) (*Store, error) {
 		}
 
 		// Call add-node API on the leader
-		addNodeURL := fmt.Sprintf("http://%s/add-node?followerId=%s&followerAddr=%s",
-			leaderAddr.String(), s.config.NodeID, s.config.RaftAdvertiseAddr)
+		query := url.Values{"followerId": {s.config.NodeID}, "followerAddr": {s.config.RaftAdvertiseAddr}}
+		if s.config.HttpAdvertiseAddr != "" {
+			query.Set("followerHttpAddr", s.config.HttpAdvertiseAddr)
+		}
+		addNodeURL := fmt.Sprintf("http://%s/add-node?%s", leaderAddr.String(), query.Encode())
 
 		maxRetries := 30
 		for i := range maxRetries {
@@ -142,42 +209,134 @@ func NewStore(cfg Config) (*Store, error) {
 		}
 	}
 
+	if s.config.MaxBatchSize > 1 {
+		s.batcher = newBatcher(s, s.config.MaxBatchSize, s.config.BatchLinger)
+		go s.batcher.run()
+	}
+	go s.expireLeases()
+	go s.announce()
+
 	return s, nil
 }
 
-// Apply applies a command to the key-value store via Raft.
-func (s *Store) Apply(data []byte) error {
+// Shutdown stops the batcher, the background loops and Raft on the node.
+func (s *Store) Shutdown() error {
+	s.shutdown.Do(func() { close(s.done) })
+	return s.raft.Shutdown().Error()
+}
+
+// Apply validates a JSON encoded command and applies it to the key-value store via Raft.
+// Invalid commands are rejected with a *ValidationError. The methods of Store
+// operate on the default namespace; In returns a view of another namespace.
+func (s *Store) Apply(data []byte) (*Result, error) {
+	return s.In("").Apply(data)
+}
+
+// propose replicates a validated command through Raft and returns its result
+// once applied. Commands proposed concurrently are batched if enabled.
+func (s *Store) propose(c Command) (*Result, error) {
 	if s.raft.State() != raft.Leader {
-		return fmt.Errorf("not the leader")
+		return nil, s.notLeader()
+	}
+
+	cmd, err := encodeCommand(c)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode command: %w", err)
 	}
 
-	future := s.raft.Apply(data, 5*time.Second)
+	if s.batcher != nil && !ownEntry(c) {
+		return s.batcher.propose(c, cmd)
+	}
+	return s.result(s.raft.Apply(cmd, time.Duration(s.applyTimeout.Load())))
+}
+
+// result waits for a command to be applied and returns its result.
+func (s *Store) result(future raft.ApplyFuture) (*Result, error) {
 	if err := future.Error(); err != nil {
-		return fmt.Errorf("could not perform apply command via Raft: %w", err)
+		return nil, fmt.Errorf("could not perform apply command via Raft: %w", s.raftError(err))
 	}
+	return fsmResult(future.Response())
+}
 
-	if fsmResponse := future.Response(); fsmResponse != nil {
-		if fsmErr, ok := fsmResponse.(error); ok {
-			return fmt.Errorf("FSM error on apply command: %w", fsmErr)
-		}
+// fsmResult converts the response of the FSM to a command into its result.
+func fsmResult(resp any) (*Result, error) {
+	switch resp := resp.(type) {
+	case error:
+		return nil, fmt.Errorf("FSM error on apply command: %w", resp)
+	case *Result:
+		return resp, nil
 	}
-	return nil
+	return &Result{}, nil
+}
+
+// Get retrieves the entry of a key from the store.
+func (s *Store) Get(key string) (Entry, bool) {
+	return s.In("").Get(key)
+}
+
+// GetAt retrieves the entry a key had as of a revision, the Raft index of
+// a command. A zero revision reads the current entry. It fails with
+// ErrCompacted if the history at the revision was discarded.
+func (s *Store) GetAt(key string, revision uint64) (Entry, bool, error) {
+	return s.In("").GetAt(key, revision)
+}
+
+// MGet retrieves the entries of several keys from the store. All keys are
+// read at the same point in time, identified by the returned Raft index.
+func (s *Store) MGet(keys []string) ([]Lookup, uint64) {
+	return s.In("").MGet(keys)
+}
+
+// Keys returns the keys currently in the store in lexical order.
+func (s *Store) Keys() []string {
+	return s.In("").Keys()
+}
+
+// Range retrieves the entries of the keys in [start, end) in lexical order as
+// of a revision, along with the revision they were read at. A zero revision
+// reads the current entries, an empty end means no upper bound and a zero
+// limit means no limit.
+func (s *Store) Range(start, end string, revision uint64, limit int) ([]Lookup, uint64, error) {
+	return s.In("").Range(start, end, revision, limit)
+}
+
+// QueryIndex retrieves the entries of the keys selected by a query on a
+// secondary index, ordered by indexed value then key, along with the Raft
+// index they were read at. It fails with ErrIndexNotFound if the index does
+// not exist.
+func (s *Store) QueryIndex(name string, q IndexQuery) ([]Lookup, uint64, error) {
+	return s.In("").QueryIndex(name, q)
 }
 
-// Get retrieves a value by key from the store.
-func (s *Store) Get(key string) (interface{}, bool) {
-	return s.data.Load(key)
+// Indexes returns the secondary indexes ordered by name.
+func (s *Store) Indexes() []IndexInfo {
+	return s.In("").Indexes()
 }
 
-// AddFollower adds a new node to the Raft cluster.
-func (s *Store) AddFollower(followerId, followerAddr string) error {
+// Namespace returns a namespace and the usage of its quotas.
+func (s *Store) Namespace(name string) (NamespaceInfo, bool) {
+	return s.fsm.getNamespace(name)
+}
+
+// Namespaces returns the namespaces ordered by name.
+func (s *Store) Namespaces() []NamespaceInfo {
+	return s.fsm.listNamespaces()
+}
+
+// AddFollower adds a new node to the Raft cluster, and records its HTTP
+// address if not empty.
+func (s *Store) AddFollower(followerId, followerAddr, followerHttpAddr string) error {
 	if s.raft.State() != raft.Leader {
-		return fmt.Errorf("not the leader")
+		return s.notLeader()
 	}
 
 	log.Printf("Handling add follower request for node %s at %s", followerId, followerAddr)
 	if err := s.raft.AddVoter(raft.ServerID(followerId), raft.ServerAddress(followerAddr), 0, 0).Error(); err != nil {
 		log.Printf("Failed to add voter %s (%s): %s", followerId, followerAddr, err)
+		return s.raftError(err)
+	}
+	if err := s.recordNode(followerId, followerHttpAddr); err != nil {
+		log.Printf("Failed to record HTTP address %s of %s: %s", followerHttpAddr, followerId, err)
 		return err
 	}
 	return nil
@@ -186,13 +345,13 @@ func (s *Store) AddFollower(followerId, followerAddr string) error {
 // RemoveFollower removes a node from the Raft cluster.
 func (s *Store) RemoveFollower(followerId string) error {
 	if s.raft.State() != raft.Leader {
-		return fmt.Errorf("not the leader")
+		return s.notLeader()
 	}
 
 	log.Printf("Handling remove follower request for node %s", followerId)
 	if err := s.raft.RemoveServer(raft.ServerID(followerId), 0, 0).Error(); err != nil {
 		log.Printf("Failed to remove voter %s: %s", followerId, err)
-		return err
+		return s.raftError(err)
 	}
 	return nil
 }
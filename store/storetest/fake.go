@@ -0,0 +1,115 @@
+package storetest
+
+import (
+	"encoding/json"
+	"slices"
+
+	"github.com/thanhqng1510/dbdb/store"
+)
+
+// Fake is a store for the tests of the servers built on stores. It serves
+// reads from its fields, records the commands applied to it, and serves every
+// namespace from itself.
+type Fake struct {
+	// Entries served by Get, GetAt and MGet, by key
+	Entries map[string]store.Entry
+	// Results of Range and QueryIndex, and the revision of every read of
+	// several keys
+	Lookups  []store.Lookup
+	Revision uint64
+	// Error of GetAt, Range and QueryIndex
+	ReadErr error
+
+	// Revision of the last GetAt, and the index and query of the last
+	// QueryIndex
+	GetRevision uint64
+	QueryName   string
+	Query       store.IndexQuery
+
+	// Last data applied, and the commands decoded from all data applied
+	ApplyData   []byte
+	Applied     []store.Command
+	ApplyResult *store.Result
+	ApplyErr    error
+
+	// Namespaces by name, and the namespace of the last In
+	NamespaceInfos map[string]store.NamespaceInfo
+	InNamespace    string
+}
+
+// Apply records a command and returns ApplyResult, or an applied result if
+// it is nil. Data that is not a command is only recorded in ApplyData.
+func (f *Fake) Apply(data []byte) (*store.Result, error) {
+	f.ApplyData = data
+	var c store.Command
+	if err := json.Unmarshal(data, &c); err == nil {
+		f.Applied = append(f.Applied, c)
+	}
+	if f.ApplyResult == nil {
+		return &store.Result{Applied: true}, f.ApplyErr
+	}
+	return f.ApplyResult, f.ApplyErr
+}
+
+func (f *Fake) Get(key string) (store.Entry, bool) {
+	e, ok := f.Entries[key]
+	return e, ok
+}
+
+func (f *Fake) GetAt(key string, revision uint64) (store.Entry, bool, error) {
+	f.GetRevision = revision
+	e, ok := f.Get(key)
+	return e, ok, f.ReadErr
+}
+
+func (f *Fake) MGet(keys []string) ([]store.Lookup, uint64) {
+	lookups := make([]store.Lookup, len(keys))
+	for i, key := range keys {
+		e, ok := f.Get(key)
+		lookups[i] = store.Lookup{Key: key, Entry: e, Found: ok}
+	}
+	return lookups, f.Revision
+}
+
+func (f *Fake) Range(start, end string, revision uint64, limit int) ([]store.Lookup, uint64, error) {
+	return f.Lookups, f.Revision, f.ReadErr
+}
+
+func (f *Fake) QueryIndex(name string, q store.IndexQuery) ([]store.Lookup, uint64, error) {
+	f.QueryName, f.Query = name, q
+	return f.Lookups, f.Revision, f.ReadErr
+}
+
+func (f *Fake) Indexes() []store.IndexInfo { return nil }
+
+// Keys returns the keys of Entries in order.
+func (f *Fake) Keys() []string {
+	keys := make([]string, 0, len(f.Entries))
+	for k := range f.Entries {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// In records the namespace and serves it from the same fake.
+func (f *Fake) In(ns string) store.IStore {
+	f.InNamespace = ns
+	return f
+}
+
+func (f *Fake) Namespace(name string) (store.NamespaceInfo, bool) {
+	info, ok := f.NamespaceInfos[name]
+	return info, ok
+}
+
+func (f *Fake) Namespaces() []store.NamespaceInfo {
+	infos := []store.NamespaceInfo{}
+	for _, info := range f.NamespaceInfos {
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+func (f *Fake) AddFollower(id, addr, httpAddr string) error { return nil }
+func (f *Fake) RemoveFollower(id string) error              { return nil }
@@ -0,0 +1,52 @@
+// Package storetest provides stores for the tests of the servers built on
+// them: stores backed by a single Raft node, and a fake serving canned reads.
+package storetest
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/thanhqng1510/dbdb/store"
+)
+
+// NewStore returns a store backed by a single in-memory Raft node, once it is
+// the leader. The store is shut down when the test ends.
+func NewStore(t testing.TB) *store.Store {
+	t.Helper()
+
+	raft := store.DefaultRaftConfig()
+	raft.HeartbeatTimeout = 50 * time.Millisecond
+	raft.ElectionTimeout = 50 * time.Millisecond
+	raft.LeaderLeaseTimeout = 50 * time.Millisecond
+	raft.LogLevel = "error"
+
+	s, err := store.NewInmemStore(store.Config{NodeID: "node1", Raft: raft})
+	if err != nil {
+		t.Fatalf("could not create store: %v", err)
+	}
+	t.Cleanup(func() { s.Shutdown() })
+
+	// Commands fail until the node elects itself
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, err := s.Apply([]byte(`{"op": "del", "key": "storetest"}`))
+		if err == nil {
+			return s
+		}
+		if !errors.Is(err, store.ErrNotLeader) || time.Now().After(deadline) {
+			t.Fatalf("store did not become leader: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Apply applies JSON encoded commands to a store, failing the test on error.
+func Apply(t testing.TB, s store.IStore, cmds ...string) {
+	t.Helper()
+	for _, c := range cmds {
+		if _, err := s.Apply([]byte(c)); err != nil {
+			t.Fatalf("could not apply %s: %v", c, err)
+		}
+	}
+}
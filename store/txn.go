@@ -0,0 +1,79 @@
+package store
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+)
+
+// txn executes a txn command: the then commands if all comparisons hold,
+// the else commands otherwise. The commands are applied atomically: if one
+// of them fails, the changes made by the previous ones are rolled back.
+// The caller must hold the write lock.
+func (kf *kvFsm) txn(c Command, index uint64, now int64) (*Result, error) {
+	succeeded := true
+	for _, comparison := range c.Compare {
+		if !kf.compare(comparison, now) {
+			succeeded = false
+			break
+		}
+	}
+
+	branch, ops := "then", c.Then
+	if !succeeded {
+		branch, ops = "else", c.Else
+	}
+
+	saved := kf.save(ops)
+
+	res := &Result{Applied: true, Succeeded: succeeded, Results: make([]Result, len(ops))}
+	for i, op := range ops {
+		r, err := kf.apply(op, index, now)
+		if err != nil {
+			kf.restore(saved)
+			return nil, fmt.Errorf("%s[%d]: %w", branch, i, err)
+		}
+		res.Results[i] = *r
+	}
+	return res, nil
+}
+
+// compare reports whether a comparison holds at the given unix time in
+// milliseconds. The caller must hold the lock.
+func (kf *kvFsm) compare(c Compare, now int64) bool {
+	e, exists := kf.load(c.Key, now)
+
+	var n int
+	switch c.Target {
+	case CompareValue:
+		if !exists {
+			return false
+		}
+		n = bytes.Compare(e.Value, c.Value)
+	case CompareVersion:
+		n = cmp.Compare(e.Version, c.Version)
+	case CompareExists:
+		n = cmp.Compare(boolInt(exists), boolInt(c.Exists))
+	default:
+		return false
+	}
+
+	switch c.Op {
+	case CompareEqual:
+		return n == 0
+	case CompareNotEqual:
+		return n != 0
+	case CompareLess:
+		return n < 0
+	case CompareGreater:
+		return n > 0
+	}
+	return false
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,86 @@
+package store
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestApply_Txn(t *testing.T) {
+	kf := newTestFsm()
+	kf.apply(Command{Op: OpTypeSet, Key: "stock", Value: []byte("1")}, 1, 0)
+
+	reserve := Command{
+		Op: OpTypeTxn,
+		Compare: []Compare{
+			{Key: "stock", Target: CompareValue, Op: CompareGreater, Value: []byte("0")},
+			{Key: "order", Target: CompareExists, Op: CompareEqual, Exists: false},
+		},
+		Then: []Command{
+			{Op: OpTypeIncr, Key: "stock", Delta: -1},
+			{Op: OpTypeSet, Key: "order", Value: []byte("reserved")},
+		},
+		Else: []Command{{Op: OpTypeSet, Key: "rejected", Value: []byte("1")}},
+	}
+
+	res, err := kf.apply(reserve, 2, 0)
+	if err != nil || !res.Applied || !res.Succeeded || len(res.Results) != 2 {
+		t.Fatalf("expected then branch to run, got %+v, err %v", res, err)
+	}
+	if string(res.Results[0].Value) != "0" || res.Results[1].Version != 2 {
+		t.Errorf("unexpected results %+v", res.Results)
+	}
+
+	res, err = kf.apply(reserve, 3, 0)
+	if err != nil || res.Succeeded || len(res.Results) != 1 {
+		t.Fatalf("expected else branch to run, got %+v, err %v", res, err)
+	}
+	if _, ok := kf.load("rejected", 0); !ok {
+		t.Errorf("expected else command to be applied")
+	}
+}
+
+func TestApply_TxnRollback(t *testing.T) {
+	kf := newTestFsm()
+	kf.apply(Command{Op: OpTypeSet, Key: "a", Value: []byte("1")}, 1, 0)
+	kf.apply(Command{Op: OpTypeSet, Key: "s", Value: []byte("abc")}, 2, 0)
+
+	_, err := kf.apply(Command{Op: OpTypeTxn, Then: []Command{
+		{Op: OpTypeSet, Key: "a", Value: []byte("2")},
+		{Op: OpTypeSet, Key: "new", Value: []byte("x")},
+		{Op: OpTypeIncr, Key: "s", Delta: 1},
+	}}, 3, 0)
+	if !errors.Is(err, ErrNotInteger) {
+		t.Fatalf("expected ErrNotInteger, got %v", err)
+	}
+
+	if e, _ := kf.load("a", 0); string(e.Value) != "1" || e.Version != 1 {
+		t.Errorf("expected a to be rolled back, got %+v", e)
+	}
+	if _, ok := kf.data["new"]; ok {
+		t.Errorf("expected new key to be rolled back")
+	}
+}
+
+func TestCompare(t *testing.T) {
+	kf := newTestFsm()
+	kf.apply(Command{Op: OpTypeSet, Key: "k", Value: []byte("b")}, 5, 0)
+
+	tests := []struct {
+		c    Compare
+		want bool
+	}{
+		{Compare{Key: "k", Target: CompareValue, Op: CompareEqual, Value: []byte("b")}, true},
+		{Compare{Key: "k", Target: CompareValue, Op: CompareLess, Value: []byte("c")}, true},
+		{Compare{Key: "k", Target: CompareVersion, Op: CompareEqual, Version: 5}, true},
+		{Compare{Key: "k", Target: CompareVersion, Op: CompareGreater, Version: 5}, false},
+		{Compare{Key: "k", Target: CompareExists, Op: CompareEqual, Exists: true}, true},
+		{Compare{Key: "missing", Target: CompareValue, Op: CompareNotEqual, Value: []byte("b")}, false},
+		{Compare{Key: "missing", Target: CompareVersion, Op: CompareEqual}, true},
+		{Compare{Key: "missing", Target: CompareExists, Op: CompareEqual, Exists: false}, true},
+	}
+	for _, tt := range tests {
+		if got := kf.compare(tt.c, 0); got != tt.want {
+			t.Errorf("%+v: expected %t, got %t", tt.c, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package store
+
+import (
+	"maps"
+	"slices"
+)
+
+// Maximum number of fields, elements or members of a hash, list or set.
+// Every write copies the whole collection, whose revisions are all kept until
+// compaction, so the cost of filling one grows with the square of its size.
+const maxCollectionLen = 1 << 14
+
+// collectionTypes maps the commands on hashes, lists and sets to the type
+// of value they apply to.
+var collectionTypes = map[OpType]ValueType{
+	OpTypeHSet:  TypeHash,
+	OpTypeHDel:  TypeHash,
+	OpTypeLPush: TypeList,
+	OpTypeRPush: TypeList,
+	OpTypeLPop:  TypeList,
+	OpTypeRPop:  TypeList,
+	OpTypeSAdd:  TypeSet,
+	OpTypeSRem:  TypeSet,
+}
+
+// applyCollection executes a command on the hash, list or set stored at a
+// key, given its current entry. The new entry is a modified copy of the whole
+// collection, so that earlier revisions are left untouched. Commands that
+// would grow it beyond maxCollectionLen fail with ErrCollectionFull. A key is
+// deleted when its last field, element or member is removed. The caller must
+// hold the write lock.
+func (kf *kvFsm) applyCollection(c Command, cur Entry, exists bool, index uint64) (*Result, error) {
+	typ := collectionTypes[c.Op]
+	if exists && cur.Type != typ {
+		return nil, ErrWrongType
+	}
+
+	// The key keeps its flags, expiry and lease
+	e := cur
+	e.Type = typ
+	e.Version = index
+
+	res := &Result{Applied: true, Version: index}
+	switch c.Op {
+	case OpTypeHSet:
+		_, had := cur.Hash[c.Field]
+		e.Hash = maps.Clone(cur.Hash)
+		if e.Hash == nil {
+			e.Hash = make(map[string][]byte, 1)
+		}
+		e.Hash[c.Field] = c.Value
+		res.Created = !had
+	case OpTypeHDel:
+		if _, ok := cur.Hash[c.Field]; !ok {
+			return &Result{Applied: false, Version: cur.Version, Count: len(cur.Hash)}, nil
+		}
+		e.Hash = maps.Clone(cur.Hash)
+		delete(e.Hash, c.Field)
+	case OpTypeLPush:
+		e.List = append([][]byte{c.Value}, cur.List...)
+	case OpTypeRPush:
+		e.List = append(slices.Clip(cur.List), c.Value)
+	case OpTypeLPop, OpTypeRPop:
+		if len(cur.List) == 0 {
+			return &Result{Applied: false}, nil
+		}
+		if c.Op == OpTypeLPop {
+			res.Value, e.List = cur.List[0], cur.List[1:]
+		} else {
+			res.Value, e.List = cur.List[len(cur.List)-1], cur.List[:len(cur.List)-1]
+		}
+	case OpTypeSAdd:
+		if _, ok := cur.Set[string(c.Value)]; ok {
+			return &Result{Applied: false, Version: cur.Version, Count: len(cur.Set)}, nil
+		}
+		e.Set = maps.Clone(cur.Set)
+		if e.Set == nil {
+			e.Set = make(map[string]struct{}, 1)
+		}
+		e.Set[string(c.Value)] = struct{}{}
+	case OpTypeSRem:
+		if _, ok := cur.Set[string(c.Value)]; !ok {
+			return &Result{Applied: false, Version: cur.Version, Count: len(cur.Set)}, nil
+		}
+		e.Set = maps.Clone(cur.Set)
+		delete(e.Set, string(c.Value))
+	}
+
+	res.Count = len(e.Hash) + len(e.List) + len(e.Set)
+	if res.Count > maxCollectionLen {
+		return nil, ErrCollectionFull
+	}
+	if res.Count == 0 {
+		kf.put(c.Key, revision{Entry: Entry{Version: index}, deleted: true})
+		return res, nil
+	}
+	kf.put(c.Key, revision{Entry: e})
+	return res, nil
+}
@@ -0,0 +1,120 @@
+package store
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestApply_Hash(t *testing.T) {
+	kf := newTestFsm()
+
+	res, _ := kf.apply(Command{Op: OpTypeHSet, Key: "h", Field: "a", Value: []byte("1")}, 1, 0)
+	if !res.Created || res.Count != 1 {
+		t.Errorf("expected new field, got %+v", res)
+	}
+	res, _ = kf.apply(Command{Op: OpTypeHSet, Key: "h", Field: "a", Value: []byte("2")}, 2, 0)
+	if !res.Applied || res.Created {
+		t.Errorf("expected existing field to be updated, got %+v", res)
+	}
+	kf.apply(Command{Op: OpTypeHSet, Key: "h", Field: "b", Value: []byte("3")}, 3, 0)
+
+	e, _ := kf.load("h", 0)
+	if want := map[string][]byte{"a": []byte("2"), "b": []byte("3")}; e.Type != TypeHash || !reflect.DeepEqual(e.Hash, want) {
+		t.Errorf("expected hash %v, got %+v", want, e)
+	}
+
+	// Earlier revisions are not modified
+	if e, _ := kf.loadAt("h", 1); string(e.Hash["a"]) != "1" || len(e.Hash) != 1 {
+		t.Errorf("expected hash at revision 1 to be unchanged, got %+v", e)
+	}
+
+	if res, _ := kf.apply(Command{Op: OpTypeHDel, Key: "h", Field: "missing"}, 4, 0); res.Applied {
+		t.Errorf("expected delete of missing field not to apply")
+	}
+	kf.apply(Command{Op: OpTypeHDel, Key: "h", Field: "a"}, 5, 0)
+	kf.apply(Command{Op: OpTypeHDel, Key: "h", Field: "b"}, 6, 0)
+	if _, ok := kf.load("h", 0); ok {
+		t.Errorf("expected empty hash to be deleted")
+	}
+}
+
+func TestApply_List(t *testing.T) {
+	kf := newTestFsm()
+	kf.apply(Command{Op: OpTypeRPush, Key: "l", Value: []byte("b")}, 1, 0)
+	kf.apply(Command{Op: OpTypeLPush, Key: "l", Value: []byte("a")}, 2, 0)
+	res, _ := kf.apply(Command{Op: OpTypeRPush, Key: "l", Value: []byte("c")}, 3, 0)
+	if res.Count != 3 {
+		t.Errorf("expected 3 elements, got %+v", res)
+	}
+
+	res, _ = kf.apply(Command{Op: OpTypeLPop, Key: "l"}, 4, 0)
+	if string(res.Value) != "a" {
+		t.Errorf("expected 'a', got %+v", res)
+	}
+	res, _ = kf.apply(Command{Op: OpTypeRPop, Key: "l"}, 5, 0)
+	if string(res.Value) != "c" || res.Count != 1 {
+		t.Errorf("expected 'c' with 1 element left, got %+v", res)
+	}
+
+	if e, _ := kf.loadAt("l", 3); !reflect.DeepEqual(e.List, [][]byte{[]byte("a"), []byte("b"), []byte("c")}) {
+		t.Errorf("expected list at revision 3 to be unchanged, got %q", e.List)
+	}
+
+	kf.apply(Command{Op: OpTypeRPop, Key: "l"}, 6, 0)
+	if res, _ := kf.apply(Command{Op: OpTypeLPop, Key: "l"}, 7, 0); res.Applied {
+		t.Errorf("expected pop of empty list not to apply")
+	}
+}
+
+func TestApply_Set(t *testing.T) {
+	kf := newTestFsm()
+	kf.apply(Command{Op: OpTypeSAdd, Key: "s", Value: []byte("a")}, 1, 0)
+	if res, _ := kf.apply(Command{Op: OpTypeSAdd, Key: "s", Value: []byte("a")}, 2, 0); res.Applied || res.Count != 1 {
+		t.Errorf("expected add of existing member not to apply, got %+v", res)
+	}
+	kf.apply(Command{Op: OpTypeSAdd, Key: "s", Value: []byte("b")}, 3, 0)
+
+	if e, _ := kf.load("s", 0); e.Type != TypeSet || len(e.Set) != 2 {
+		t.Errorf("expected set of 2 members, got %+v", e)
+	}
+	if res, _ := kf.apply(Command{Op: OpTypeSRem, Key: "s", Value: []byte("b")}, 4, 0); !res.Applied || res.Count != 1 {
+		t.Errorf("expected member to be removed, got %+v", res)
+	}
+}
+
+func TestApply_WrongType(t *testing.T) {
+	kf := newTestFsm()
+	kf.apply(Command{Op: OpTypeSet, Key: "str", Value: []byte("1")}, 1, 0)
+	kf.apply(Command{Op: OpTypeSAdd, Key: "set", Value: []byte("a")}, 2, 0)
+
+	for _, c := range []Command{
+		{Op: OpTypeHSet, Key: "str", Field: "f", Value: []byte("v")},
+		{Op: OpTypeLPush, Key: "set", Value: []byte("v")},
+		{Op: OpTypeIncr, Key: "set", Delta: 1},
+	} {
+		if _, err := kf.apply(c, 3, 0); !errors.Is(err, ErrWrongType) {
+			t.Errorf("%s on %s: expected ErrWrongType, got %v", c.Op, c.Key, err)
+		}
+	}
+
+	// A set command replaces a value of any type
+	if res, err := kf.apply(Command{Op: OpTypeSet, Key: "set", Value: []byte("v")}, 4, 0); err != nil || !res.Applied {
+		t.Errorf("expected set to replace the set, got %+v, err %v", res, err)
+	}
+}
+
+func TestApply_CollectionFull(t *testing.T) {
+	kf := newTestFsm()
+	kf.put("l", revision{Entry: Entry{Type: TypeList, List: make([][]byte, maxCollectionLen), Version: 1}})
+
+	if _, err := kf.apply(Command{Op: OpTypeRPush, Key: "l", Value: []byte("v")}, 2, 0); !errors.Is(err, ErrCollectionFull) {
+		t.Errorf("expected ErrCollectionFull, got %v", err)
+	}
+	if e, _ := kf.load("l", 0); e.Version != 1 {
+		t.Errorf("expected full list to be unchanged, got version %d", e.Version)
+	}
+	if res, err := kf.apply(Command{Op: OpTypeLPop, Key: "l"}, 3, 0); err != nil || res.Count != maxCollectionLen-1 {
+		t.Errorf("expected pop from a full list to apply, got %+v, err %v", res, err)
+	}
+}
@@ -0,0 +1,272 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
+
+var validate = newValidator()
+
+func newValidator() *validator.Validate {
+	v := validator.New()
+
+	// Report fields by their JSON name, which is what clients send
+	v.RegisterTagNameFunc(func(f reflect.StructField) string {
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+	return v
+}
+
+// FieldError describes why a field of a command is invalid.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError is returned for commands that are malformed or invalid.
+// Such commands are rejected before being proposed to Raft.
+type ValidationError struct {
+	// Problem with the command as a whole, such as malformed JSON
+	Message string
+
+	// Problems with individual fields
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Fields)+1)
+	if e.Message != "" {
+		msgs = append(msgs, e.Message)
+	}
+	for _, f := range e.Fields {
+		msgs = append(msgs, f.Field+" "+f.Message)
+	}
+	return "invalid command: " + strings.Join(msgs, "; ")
+}
+
+// parseCommand decodes a JSON command and validates it.
+func parseCommand(data []byte) (Command, error) {
+	var c Command
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&c); err != nil {
+		return Command{}, jsonError(err)
+	}
+	if decoder.More() {
+		return Command{}, &ValidationError{Message: "unexpected data after command"}
+	}
+
+	if err := validateCommand(c); err != nil {
+		return Command{}, err
+	}
+	return withDefaults(c), nil
+}
+
+// withDefaults fills in the default values of the fields of a command and
+// of the commands of a transaction.
+func withDefaults(c Command) Command {
+	if (c.Op == OpTypeIncr || c.Op == OpTypeDecr) && c.Delta == 0 {
+		c.Delta = 1
+	}
+	if c.Op == OpTypeDequeue && c.Ttl == 0 {
+		c.Ttl = defaultVisibilityTimeout
+	}
+
+	for _, ops := range [][]Command{c.Then, c.Else} {
+		for i := range ops {
+			ops[i] = withDefaults(ops[i])
+		}
+	}
+	return c
+}
+
+// validateCommand checks that a command is well formed.
+func validateCommand(c Command) error {
+	verr := &ValidationError{}
+
+	err := validate.Struct(c)
+	var errs validator.ValidationErrors
+	if errors.As(err, &errs) {
+		for _, fe := range errs {
+			// Drop the name of the root struct from the namespace
+			_, field, _ := strings.Cut(fe.Namespace(), ".")
+			verr.Fields = append(verr.Fields, FieldError{Field: field, Message: fieldMessage(fe)})
+		}
+	} else if err != nil {
+		return err
+	}
+
+	// Transactions may only contain commands on single keys
+	for _, branch := range []struct {
+		name string
+		ops  []Command
+	}{{"then", c.Then}, {"else", c.Else}} {
+		for i, op := range branch.ops {
+			switch op.Op {
+			case OpTypeCompact, OpTypeGrant, OpTypeKeepAlive, OpTypeRevoke,
+				OpTypeEnqueue, OpTypeDequeue, OpTypeAck, OpTypePeek, OpTypeCreateIndex, OpTypeDropIndex, OpTypeNsCreate, OpTypeNsUpdate, OpTypeNsDelete, OpTypeTxn:
+				verr.Fields = append(verr.Fields, FieldError{
+					Field:   fmt.Sprintf("%s[%d].op", branch.name, i),
+					Message: "must be one of: set, del, incr, decr, add, hset, hdel, lpush, rpush, lpop, rpop, sadd, srem, jset, jdel, jmerge",
+				})
+			}
+		}
+	}
+
+	verr.Fields = appendJSONErrors(verr.Fields, "", c)
+	verr.Fields = appendKeyErrors(verr.Fields, "", c)
+
+	if len(verr.Fields) > 0 {
+		return verr
+	}
+	return nil
+}
+
+// checkSize checks that the keys, hash fields and values of a command,
+// including the comparisons and commands of a transaction, do not exceed the
+// maximum sizes in bytes. A maximum of zero means no limit.
+func checkSize(c Command, maxKeySize, maxValueSize int) error {
+	verr := &ValidationError{}
+	verr.Fields = appendSizeErrors(verr.Fields, "", c, maxKeySize, maxValueSize)
+
+	if len(verr.Fields) > 0 {
+		return verr
+	}
+	return nil
+}
+
+func appendSizeErrors(fields []FieldError, prefix string, c Command, maxKeySize, maxValueSize int) []FieldError {
+	if maxKeySize > 0 && len(c.Key) > maxKeySize {
+		fields = append(fields, FieldError{Field: prefix + "key", Message: fmt.Sprintf("must be at most %d bytes", maxKeySize)})
+	}
+	if maxKeySize > 0 && len(c.Field) > maxKeySize {
+		fields = append(fields, FieldError{Field: prefix + "field", Message: fmt.Sprintf("must be at most %d bytes", maxKeySize)})
+	}
+	if maxValueSize > 0 && len(c.Value) > maxValueSize {
+		fields = append(fields, FieldError{Field: prefix + "value", Message: fmt.Sprintf("must be at most %d bytes", maxValueSize)})
+	}
+
+	for i, cmp := range c.Compare {
+		if maxKeySize > 0 && len(cmp.Key) > maxKeySize {
+			fields = append(fields, FieldError{Field: fmt.Sprintf("%scompare[%d].key", prefix, i), Message: fmt.Sprintf("must be at most %d bytes", maxKeySize)})
+		}
+		if maxValueSize > 0 && len(cmp.Value) > maxValueSize {
+			fields = append(fields, FieldError{Field: fmt.Sprintf("%scompare[%d].value", prefix, i), Message: fmt.Sprintf("must be at most %d bytes", maxValueSize)})
+		}
+	}
+
+	for i, op := range c.Then {
+		fields = appendSizeErrors(fields, fmt.Sprintf("%sthen[%d].", prefix, i), op, maxKeySize, maxValueSize)
+	}
+	for i, op := range c.Else {
+		fields = appendSizeErrors(fields, fmt.Sprintf("%selse[%d].", prefix, i), op, maxKeySize, maxValueSize)
+	}
+	return fields
+}
+
+// appendKeyErrors checks that keys do not start with the prefix of the keys
+// of namespaces, and that namespace commands name a valid namespace.
+func appendKeyErrors(fields []FieldError, prefix string, c Command) []FieldError {
+	switch c.Op {
+	case OpTypeNsCreate, OpTypeNsUpdate, OpTypeNsDelete:
+		if c.Key != "" && !namespaceName.MatchString(c.Key) {
+			fields = append(fields, FieldError{Field: prefix + "key", Message: "must be a namespace name of letters, digits, '_', '.' and '-'"})
+		}
+	default:
+		if strings.HasPrefix(c.Key, nsSep) {
+			fields = append(fields, FieldError{Field: prefix + "key", Message: "must not start with a NUL byte"})
+		}
+	}
+
+	for i, cmp := range c.Compare {
+		if strings.HasPrefix(cmp.Key, nsSep) {
+			fields = append(fields, FieldError{Field: fmt.Sprintf("%scompare[%d].key", prefix, i), Message: "must not start with a NUL byte"})
+		}
+	}
+	for i, op := range c.Then {
+		fields = appendKeyErrors(fields, fmt.Sprintf("%sthen[%d].", prefix, i), op)
+	}
+	for i, op := range c.Else {
+		fields = appendKeyErrors(fields, fmt.Sprintf("%selse[%d].", prefix, i), op)
+	}
+	return fields
+}
+
+// appendJSONErrors checks the path and value of JSON commands, including the
+// commands of a transaction.
+func appendJSONErrors(fields []FieldError, prefix string, c Command) []FieldError {
+	switch c.Op {
+	case OpTypeJSet, OpTypeJDel, OpTypeJMerge, OpTypeCreateIndex:
+		if _, err := parsePointer(c.Path); err != nil {
+			fields = append(fields, FieldError{Field: prefix + "path", Message: "must be a JSON Pointer"})
+		}
+		if (c.Op == OpTypeJSet || c.Op == OpTypeJMerge) && c.Value != nil && !json.Valid(c.Value) {
+			fields = append(fields, FieldError{Field: prefix + "value", Message: "must be a JSON document"})
+		}
+	default:
+		if c.Path != "" {
+			fields = append(fields, FieldError{Field: prefix + "path", Message: "is only allowed when op is jset, jdel, jmerge or createindex"})
+		}
+	}
+
+	for i, op := range c.Then {
+		fields = appendJSONErrors(fields, fmt.Sprintf("%sthen[%d].", prefix, i), op)
+	}
+	for i, op := range c.Else {
+		fields = appendJSONErrors(fields, fmt.Sprintf("%selse[%d].", prefix, i), op)
+	}
+	return fields
+}
+
+// fieldMessage returns a human readable message for a failed validation.
+func fieldMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required", "required_unless":
+		return "is required"
+	case "required_if":
+		field, value, _ := strings.Cut(fe.Param(), " ")
+		return fmt.Sprintf("is required when %s is %s", strings.ToLower(field), value)
+	case "excluded_unless":
+		field, value, _ := strings.Cut(fe.Param(), " ")
+		return fmt.Sprintf("is only allowed when %s is %s", strings.ToLower(field), value)
+	case "oneof":
+		return "must be one of: " + strings.ReplaceAll(fe.Param(), " ", ", ")
+	case "min":
+		return "must be at least " + fe.Param()
+	case "max":
+		return "must be at most " + fe.Param()
+	}
+	return fmt.Sprintf("failed on the '%s' validation", fe.Tag())
+}
+
+// jsonError converts a JSON decoding error into a ValidationError.
+func jsonError(err error) error {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		msg := "must be of type " + typeErr.Type.String()
+		if typeErr.Type == reflect.TypeOf([]byte(nil)) {
+			msg = "must be base64 encoded"
+		}
+		return &ValidationError{Fields: []FieldError{{Field: typeErr.Field, Message: msg}}}
+	}
+
+	if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		return &ValidationError{Fields: []FieldError{{
+			Field:   strings.Trim(field, `"`),
+			Message: "is not a known field",
+		}}}
+	}
+
+	return &ValidationError{Message: "malformed JSON: " + err.Error()}
+}
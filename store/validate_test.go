@@ -0,0 +1,173 @@
+package store
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseCommand(t *testing.T) {
+	c, err := parseCommand([]byte(`{"op": "set", "key": "x", "value": "MjM=", "cond": "nx"}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := (Command{Op: OpTypeSet, Key: "x", Value: []byte("23"), Cond: CondNotExists}); !reflect.DeepEqual(c, want) {
+		t.Errorf("expected %+v, got %+v", want, c)
+	}
+}
+
+func TestParseCommand_DefaultDelta(t *testing.T) {
+	c, err := parseCommand([]byte(`{"op": "txn", "then": [{"op": "incr", "key": "a"}, {"op": "decr", "key": "b", "delta": 5}]}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c.Then[0].Delta != 1 || c.Then[1].Delta != 5 {
+		t.Errorf("expected deltas 1 and 5, got %+v", c.Then)
+	}
+
+	if _, err := parseCommand([]byte(`{"op": "add", "key": "a"}`)); err == nil {
+		t.Errorf("expected add without delta to be rejected")
+	}
+}
+
+func TestParseCommand_FieldErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []FieldError
+	}{
+		{
+			name: "empty key",
+			data: `{"op": "set", "key": "", "value": "dg=="}`,
+			want: []FieldError{{"key", "is required"}},
+		},
+		{
+			name: "missing value",
+			data: `{"op": "set", "key": "k"}`,
+			want: []FieldError{{"value", "is required when op is set"}},
+		},
+		{
+			name: "unknown op",
+			data: `{"op": "put", "key": "k"}`,
+			want: []FieldError{{"op", "must be one of: set, del, incr, decr, add, hset, hdel, lpush, rpush, lpop, rpop, sadd, srem, jset, jdel, jmerge, compact, grant, keepalive, revoke, enqueue, dequeue, ack, peek, createindex, dropindex, nscreate, nsupdate, nsdelete, txn"}},
+		},
+		{
+			name: "condition on delete",
+			data: `{"op": "del", "key": "k", "cond": "nx"}`,
+			want: []FieldError{{"cond", "is only allowed when op is set"}},
+		},
+		{
+			name: "invalid txn",
+			data: `{"op": "txn", "compare": [{"key": "k", "target": "size", "op": "eq"}], "then": [{"op": "txn"}, {"op": "set", "key": "k"}]}`,
+			want: []FieldError{
+				{"compare[0].target", "must be one of: value, version, exists"},
+				{"then[1].value", "is required when op is set"},
+				{"then[0].op", "must be one of: set, del, incr, decr, add, hset, hdel, lpush, rpush, lpop, rpop, sadd, srem, jset, jdel, jmerge"},
+			},
+		},
+		{
+			name: "invalid json command",
+			data: `{"op": "jset", "key": "k", "path": "a", "value": "e30="}`,
+			want: []FieldError{{"path", "must be a JSON Pointer"}},
+		},
+		{
+			name: "json value",
+			data: `{"op": "jmerge", "key": "k", "value": "ew=="}`,
+			want: []FieldError{{"value", "must be a JSON document"}},
+		},
+		{
+			name: "path on set",
+			data: `{"op": "set", "key": "k", "value": "dg==", "path": "/a"}`,
+			want: []FieldError{{"path", "is only allowed when op is jset, jdel, jmerge or createindex"}},
+		},
+		{
+			name: "key in namespace prefix",
+			data: `{"op": "set", "key": "\u0000a\u0000k", "value": "dg=="}`,
+			want: []FieldError{{"key", "must not start with a NUL byte"}},
+		},
+		{
+			name: "namespace name",
+			data: `{"op": "nscreate", "key": "a/b", "max_keys": -1}`,
+			want: []FieldError{{"max_keys", "must be at least 0"}, {"key", "must be a namespace name of letters, digits, '_', '.' and '-'"}},
+		},
+		{
+			name: "negative ttl",
+			data: `{"op": "set", "key": "k", "value": "dg==", "ttl": -1}`,
+			want: []FieldError{{"ttl", "must be at least 0"}},
+		},
+		{
+			name: "wrong type",
+			data: `{"op": "set", "key": "k", "value": "dg==", "ttl": "1s"}`,
+			want: []FieldError{{"ttl", "must be of type int64"}},
+		},
+		{
+			name: "value not base64",
+			data: `{"op": "set", "key": "k", "value": "23"}`,
+			want: []FieldError{{"value", "must be base64 encoded"}},
+		},
+		{
+			name: "unknown field",
+			data: `{"op": "set", "key": "k", "vaule": "v"}`,
+			want: []FieldError{{"vaule", "is not a known field"}},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := parseCommand([]byte(tc.data))
+
+			var verr *ValidationError
+			if !errors.As(err, &verr) {
+				t.Fatalf("expected ValidationError, got %v", err)
+			}
+			if len(verr.Fields) != len(tc.want) {
+				t.Fatalf("expected fields %+v, got %+v", tc.want, verr.Fields)
+			}
+			for i := range tc.want {
+				if verr.Fields[i] != tc.want[i] {
+					t.Errorf("expected %+v, got %+v", tc.want[i], verr.Fields[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseCommand_Malformed(t *testing.T) {
+	for _, data := range []string{``, `{"op": "set"`, `{"op": "del", "key": "k"} {}`} {
+		_, err := parseCommand([]byte(data))
+
+		var verr *ValidationError
+		if !errors.As(err, &verr) || verr.Message == "" {
+			t.Errorf("%q: expected ValidationError with message, got %v", data, err)
+		}
+	}
+}
+
+func TestCheckSize(t *testing.T) {
+	c := Command{Op: OpTypeSet, Key: "key", Value: []byte("value")}
+	if err := checkSize(c, 3, 5); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := checkSize(c, 0, 0); err != nil {
+		t.Errorf("expected no limit, got %v", err)
+	}
+
+	var verr *ValidationError
+	if err := checkSize(c, 2, 4); !errors.As(err, &verr) || len(verr.Fields) != 2 {
+		t.Errorf("expected key and value errors, got %v", err)
+	}
+
+	// Fields are limited like keys, and compared values like values
+	c = Command{Op: OpTypeTxn,
+		Compare: []Compare{{Key: "key", Target: CompareValue, Op: CompareEqual, Value: []byte("value")}},
+		Then:    []Command{{Op: OpTypeHSet, Key: "k", Field: "field", Value: []byte("v")}},
+	}
+	want := []FieldError{
+		{Field: "compare[0].key", Message: "must be at most 2 bytes"},
+		{Field: "compare[0].value", Message: "must be at most 4 bytes"},
+		{Field: "then[0].field", Message: "must be at most 2 bytes"},
+	}
+	if err := checkSize(c, 2, 4); !errors.As(err, &verr) || !reflect.DeepEqual(verr.Fields, want) {
+		t.Errorf("expected %v, got %v", want, err)
+	}
+}